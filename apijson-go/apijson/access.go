@@ -0,0 +1,320 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//RequestRole 请求角色，对应 APIJSON 的 RequestRole
+type RequestRole string
+
+//请求角色
+const (
+	RoleUnknown RequestRole = "UNKNOWN" //未登录，不明身份的用户
+	RoleLogin   RequestRole = "LOGIN"   //已登录的用户
+	RoleContact RequestRole = "CONTACT" //联系人，必须已登录
+	RoleCircle  RequestRole = "CIRCLE"  //圈子成员(CONTACT + OWNER)，必须已登录
+	RoleOwner   RequestRole = "OWNER"   //拥有者，必须已登录
+	RoleAdmin   RequestRole = "ADMIN"   //管理员，必须已登录
+)
+
+//IsValid 是否合法的角色
+func (r RequestRole) IsValid() bool {
+	switch r {
+	case RoleUnknown, RoleLogin, RoleContact, RoleCircle, RoleOwner, RoleAdmin:
+		return true
+	}
+
+	return false
+}
+
+//KeyRole 请求中指定角色的 key，可以放在最外层或表对象内，如 "@role": "OWNER"
+const KeyRole = "@role"
+
+//表中访问者 id 的字段，OWNER、CONTACT、CIRCLE 角色按它加条件
+func (p *Parser) getVisitorIDKey(table string) string {
+	if key, ok := p.config().VisitorIDKeys[table]; ok {
+		return key
+	}
+
+	return p.config().VisitorIDKey
+}
+
+//Visitor 访问者，由调用方登录校验后通过 WithVisitor 放入 ctx
+type Visitor struct {
+	ID         interface{}   //用户 id，未登录为 nil
+	ContactIDs []interface{} //联系人 id，CONTACT、CIRCLE 角色用
+	IsAdmin    bool          //是否管理员，ADMIN 角色用
+}
+
+type visitorKey struct{}
+
+//WithVisitor 将访问者放入 ctx
+func WithVisitor(ctx context.Context, visitor *Visitor) context.Context {
+	return context.WithValue(ctx, visitorKey{}, visitor)
+}
+
+//VisitorFromContext 从 ctx 获取访问者，没有则返回未登录的访问者
+func VisitorFromContext(ctx context.Context) *Visitor {
+	if visitor, ok := ctx.Value(visitorKey{}).(*Visitor); ok && visitor != nil {
+		return visitor
+	}
+
+	return &Visitor{}
+}
+
+//IsLogin 是否已登录，id 为正数或非空字符串
+func (v *Visitor) IsLogin() bool {
+	switch id := v.ID.(type) {
+	case string:
+		return strings.TrimSpace(id) != ""
+	case float64:
+		return id > 0
+	case int:
+		return id > 0
+	case int64:
+		return id > 0
+	}
+
+	return false
+}
+
+//获取请求角色，表对象内的 @role 优先，其次为最外层的 @role，都没有则为 UNKNOWN
+func getRole(where *orderedmap.OrderedMap, globalRole RequestRole) (RequestRole, error) {
+	role := globalRole
+	if tmp, ok := where.Get(KeyRole); ok {
+		str, _ := tmp.(string)
+		role = RequestRole(strings.ToUpper(str))
+	}
+
+	if role == "" {
+		role = RoleUnknown
+	}
+
+	if !role.IsValid() {
+		return "", fmt.Errorf("role %s is invalid", role)
+	}
+
+	return role, nil
+}
+
+//从 Access 表加载权限，每次请求加载一次
+func (p *Parser) loadAccess(ctx context.Context) (map[string]map[RequestMethod][]RequestRole, error) {
+	if p.access == nil {
+		p.access = &accessRules{}
+	}
+
+	if p.access.roles != nil {
+		return p.access.roles, nil
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(p.config().AccessTable)
+
+	rows, err := p.DB.FindAllMaps(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []RequestMethod{MethodGet, MethodHead, MethodGets, MethodHeads, MethodPost, MethodPut, MethodDelete}
+
+	access := make(map[string]map[RequestMethod][]RequestRole, len(rows))
+	for _, row := range rows {
+		name, err := rowToString(indirectValue(row["name"]))
+		if err != nil {
+			return nil, err
+		}
+
+		roles := make(map[RequestMethod][]RequestRole, len(methods))
+		for _, method := range methods {
+			str, err := rowToString(indirectValue(row[string(method)]))
+			if err != nil {
+				return nil, err
+			}
+
+			if str == "" {
+				continue
+			}
+
+			var rs []RequestRole
+			err = json.Unmarshal([]byte(str), &rs)
+			if err != nil {
+				return nil, newError(CodeServerError, "%s %s of %s is invalid: %v", p.config().AccessTable, method, name, err)
+			}
+
+			roles[method] = rs
+		}
+
+		access[name] = roles
+	}
+
+	p.access.roles = access
+	return access, nil
+}
+
+//校验表的访问权限，返回去掉 @role 的 where 和角色的条件，请求中的 where 不会被修改
+//OWNER 查询、修改、删除时返回 userId = 访问者 id 的条件，由 Statement.AccessWhere 单独 AND 连接，不参与 @combine，
+//新增时 userId 设为访问者 id，没有条件
+//有表结构时先校验表和字段，条件和新增的值转为字段的类型
+func (p *Parser) verifyAccess(ctx context.Context, table string,
+	where *orderedmap.OrderedMap) (newWhere, access *orderedmap.OrderedMap, err error) {
+	where, err = p.verifySchema(table, where, p.Method == MethodPost)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	role, err := getRole(where, p.Role)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accesses, err := p.loadAccess(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roles, ok := accesses[table]
+	if !ok {
+		return nil, nil, NewIllegalAccessError("table %s is not accessible", table)
+	}
+
+	visitor := VisitorFromContext(ctx)
+	if role != RoleUnknown && !visitor.IsLogin() {
+		return nil, nil, NewNotLoggedInError("not logged in, %s role requires login", role)
+	}
+
+	if !containsRole(roles[p.Method], role) {
+		return nil, nil, NewIllegalAccessError("%s is not allowed for %s role in %s request", table, role, p.Method)
+	}
+
+	newWhere = orderedmap.New()
+	for _, key := range where.Keys() {
+		if key != KeyRole {
+			val, _ := where.Get(key)
+			newWhere.Set(key, val)
+		}
+	}
+
+	switch role {
+	case RoleContact, RoleCircle:
+		ids := make([]interface{}, 0, len(visitor.ContactIDs)+1)
+		ids = append(ids, visitor.ContactIDs...)
+		if role == RoleCircle {
+			ids = append(ids, visitor.ID)
+		}
+
+		access, err = verifyVisitorIDs(table, p.getVisitorIDKey(table), role, newWhere, ids, p.Method == MethodPost)
+	case RoleOwner:
+		access, err = verifyVisitorIDs(table, p.getVisitorIDKey(table), role, newWhere, []interface{}{visitor.ID}, p.Method == MethodPost)
+	case RoleAdmin:
+		if !visitor.IsAdmin {
+			err = NewIllegalAccessError("%s is not allowed for non-admin visitor", table)
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newWhere, access, nil
+}
+
+//请求中已有 key、key{} 时校验都在 ids 内，返回 key = id 或 key{} = ids 的条件，
+//isValues 为 true 时 where 是新增的值，没有 key 时设为访问者 id，只能是一个 key，ids 有多个时必须在请求中指定
+func verifyVisitorIDs(table, key string, role RequestRole, where *orderedmap.OrderedMap,
+	ids []interface{}, isValues bool) (*orderedmap.OrderedMap, error) {
+	if _, ok := where.Get(key + "{}"); ok && isValues {
+		return nil, NewConditionError("%s{} of %s is not a value, use %s", key, table, key)
+	}
+
+	var requestIDs []interface{}
+
+	if id, _ := where.Get(key); id != nil {
+		requestIDs = append(requestIDs, id)
+	}
+
+	if tmp, _ := where.Get(key + "{}"); tmp != nil {
+		list, ok := tmp.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s{} of %s must be an array", key, table)
+		}
+
+		requestIDs = append(requestIDs, list...)
+	}
+
+	for _, id := range requestIDs {
+		if !containsID(ids, id) {
+			return nil, NewIllegalAccessError("%s with %s = %v is not allowed for %s role", table, key, id, role)
+		}
+	}
+
+	if isValues && len(requestIDs) > 0 {
+		return nil, nil
+	}
+
+	if len(ids) == 0 {
+		return nil, NewIllegalAccessError("%s is not allowed for %s role without contacts", table, role)
+	}
+
+	if isValues {
+		if len(ids) > 1 {
+			return nil, NewConditionError("%s of %s is required for %s role", key, table, role)
+		}
+
+		where.Set(key, ids[0])
+		return nil, nil
+	}
+
+	//请求中的条件可能在 @combine 中被 OR 掉，角色的条件总是单独加上
+	access := orderedmap.New()
+	if len(ids) == 1 {
+		access.Set(key, ids[0])
+	} else {
+		access.Set(key+"{}", ids)
+	}
+
+	return access, nil
+}
+
+//OWNER、CONTACT、CIRCLE 修改时不能设置访问者 id 的字段，防止把记录转给其他用户
+func (p *Parser) verifyVisitorIDValues(table string, role RequestRole, values *orderedmap.OrderedMap) error {
+	switch role {
+	case RoleOwner, RoleContact, RoleCircle:
+	default:
+		return nil
+	}
+
+	key := p.getVisitorIDKey(table)
+	for _, k := range values.Keys() {
+		if column, _, _, _ := pregOperatorMatch(k); column == key {
+			return NewIllegalAccessError("%s of %s can not be put by %s role", key, table, role)
+		}
+	}
+
+	return nil
+}
+
+func containsRole(roles []RequestRole, role RequestRole) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+//JSON 中的数字为 float64，与访问者 id 的类型可能不同，按字符串比较
+func containsID(ids []interface{}, id interface{}) bool {
+	for _, v := range ids {
+		if fmt.Sprint(v) == fmt.Sprint(id) {
+			return true
+		}
+	}
+
+	return false
+}
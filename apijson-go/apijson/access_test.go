@@ -0,0 +1,171 @@
+package apijson
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAccessRoles(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//评论允许所有登录的角色增删改
+	_, err := e.DB().ExecContext(context.Background(), `UPDATE Access SET post = '["CONTACT", "CIRCLE", "OWNER", "ADMIN"]',
+		put = '["CONTACT", "CIRCLE", "OWNER", "ADMIN"]', "delete" = '["CONTACT", "CIRCLE", "OWNER", "ADMIN"]' WHERE name = 'Comment'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//修改评论的规则不拒绝 userId，由角色校验拒绝
+	_, err = e.DB().ExecContext(context.Background(), `UPDATE Request SET structure = '{"MUST": "id"}' WHERE id = 5`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//82001 的联系人为 82002、38710、70793，评论 22 是自己的，4 是 38710 的，47 是 70793 的，13 是 82005 的
+	visitor := &Visitor{ID: int64(82001), ContactIDs: []interface{}{float64(82002), float64(38710), float64(70793)}}
+
+	tests := []struct {
+		name   string
+		method RequestMethod
+		body   string
+		count  interface{} //PUT、DELETE 的修改条数，GET 的 id，POST 为 nil
+		err    error
+	}{
+		{"get own", MethodGet, `{"Comment": {"id": 22, "@role": "OWNER"}}`, float64(22), nil},
+		{"get own as contact", MethodGet, `{"Comment": {"id": 22, "@role": "CONTACT"}}`, nil, nil},
+		{"get own as circle", MethodGet, `{"Comment": {"id": 22, "@role": "CIRCLE"}}`, float64(22), nil},
+		{"get contact's", MethodGet, `{"Comment": {"id": 47, "@role": "CONTACT"}}`, float64(47), nil},
+		{"get not contact's", MethodGet, `{"Comment": {"id": 13, "@role": "CIRCLE"}}`, nil, nil},
+		{"get as admin", MethodGet, `{"Comment": {"id": 13, "@role": "ADMIN"}}`, nil, ErrIllegalAccess},
+
+		{"post as owner", MethodPost, `{"Comment": {"momentId": 12, "content": "owner", "@role": "OWNER"}, "tag": "Comment"}`, nil, nil},
+		{"post as circle", MethodPost, `{"Comment": {"momentId": 12, "content": "circle", "userId": 82001, "@role": "CIRCLE"}, "tag": "Comment"}`, nil, nil},
+		{"post as contact", MethodPost, `{"Comment": {"momentId": 12, "content": "contact", "userId": 70793, "@role": "CONTACT"}, "tag": "Comment"}`, nil, nil},
+		{"post without user", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "@role": "CONTACT"}, "tag": "Comment"}`, nil, ErrConditionError},
+		{"post with users", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "userId{}": [70793], "@role": "CIRCLE"}, "tag": "Comment"}`, nil, ErrConditionError},
+		{"post for not contact", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "userId": 82003, "@role": "CONTACT"}, "tag": "Comment"}`, nil, ErrIllegalAccess},
+		{"post for other", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "userId": 70793, "@role": "OWNER"}, "tag": "Comment"}`, nil, ErrIllegalAccess},
+		{"post as admin", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "@role": "ADMIN"}, "tag": "Comment"}`, nil, ErrIllegalAccess},
+
+		{"put own", MethodPut, `{"Comment": {"id": 22, "content": "own", "@role": "OWNER"}, "tag": "Comment"}`, float64(1), nil},
+		{"put own as contact", MethodPut, `{"Comment": {"id": 22, "content": "x", "@role": "CONTACT"}, "tag": "Comment"}`, float64(0), nil},
+		{"put own as circle", MethodPut, `{"Comment": {"id": 22, "content": "circle", "@role": "CIRCLE"}, "tag": "Comment"}`, float64(1), nil},
+		{"put contact's", MethodPut, `{"Comment": {"id": 47, "content": "contact", "@role": "CONTACT"}, "tag": "Comment"}`, float64(1), nil},
+		{"put other's", MethodPut, `{"Comment": {"id": 13, "content": "x", "@role": "OWNER"}, "tag": "Comment"}`, float64(0), nil},
+		{"put own to other", MethodPut, `{"Comment": {"id": 22, "userId": 82003, "@role": "OWNER"}, "tag": "Comment"}`, nil, ErrIllegalAccess},
+		{"put contact's to self", MethodPut, `{"Comment": {"id": 47, "userId": 82001, "@role": "CONTACT"}, "tag": "Comment"}`, nil, ErrIllegalAccess},
+
+		{"delete other's", MethodDelete, `{"Comment": {"id": 13}, "tag": "Comment", "@role": "CIRCLE"}`, float64(0), nil},
+		{"delete contact's", MethodDelete, `{"Comment": {"id": 4}, "tag": "Comment", "@role": "CONTACT"}`, float64(1), nil},
+		{"delete own", MethodDelete, `{"Comment": {"id": 22}, "tag": "Comment", "@role": "OWNER"}`, float64(1), nil},
+	}
+
+	for _, test := range tests {
+		res, err := parseSQLite(t, e, visitor, test.method, test.body)
+		if test.err != nil || err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+			}
+
+			continue
+		}
+
+		comment, _ := res["Comment"].(map[string]interface{})
+		switch test.method {
+		case MethodGet:
+			if comment["id"] != test.count {
+				t.Errorf("%s: Comment = %v, want id %v", test.name, res["Comment"], test.count)
+			}
+		case MethodPost:
+			if comment["count"] != float64(1) {
+				t.Errorf("%s: Comment = %v", test.name, res["Comment"])
+			}
+		default:
+			if comment["count"] != test.count {
+				t.Errorf("%s: count = %v, want %v", test.name, comment["count"], test.count)
+			}
+		}
+	}
+
+	//新增的评论属于请求中指定的用户，没有指定时为访问者本身
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"Comment": {"momentId": 12, "id>": 97, "@order": "id+", "@column": "userId,content"}}}`)
+	want := []struct {
+		userID  float64
+		content string
+	}{{82001, "owner"}, {82001, "circle"}, {70793, "contact"}}
+
+	items := getList(t, res, "[]")
+	if len(items) != len(want) {
+		t.Fatalf("[] = %v", items)
+	}
+
+	for i, item := range items {
+		obj, _ := item.(map[string]interface{})
+		if comment := getObject(t, obj, "Comment"); comment["userId"] != want[i].userID || comment["content"] != want[i].content {
+			t.Errorf("Comment = %v, want %v", comment, want[i])
+		}
+	}
+}
+
+func TestAccessCombine(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//删除评论的规则不限制 @combine，由角色的条件限制
+	_, err := e.DB().ExecContext(context.Background(), `UPDATE Request SET structure = '{"MUST": "id"}' WHERE id = 6`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//82001 的评论只有 22，联系人 70793 的评论只有 47
+	visitor := &Visitor{ID: int64(82001), ContactIDs: []interface{}{float64(70793)}}
+
+	tests := []struct {
+		where string
+		want  []float64
+	}{
+		//@combine 中没有的 key 报错，或者结果只有自己的评论
+		{`"@role": "OWNER", "id>": 0, "@combine": "userId | id>"`, nil},
+		{`"@role": "OWNER", "id>": 0, "userId": 82001, "@combine": "userId | id>"`, []float64{22}},
+		{`"@role": "CIRCLE", "id>": 0, "userId{}": [82001], "@combine": "userId{} | id>"`, []float64{22, 47}},
+		{`"@role": "OWNER", "id>": 0, "userId": 82001, "@combine": "!userId | id>"`, []float64{22}},
+	}
+
+	for _, test := range tests {
+		res, err := parseSQLite(t, e, visitor, MethodGet, `{"[]": {"count": 50, "Comment": {`+test.where+`, "@order": "id+", "@column": "id,userId"}}}`)
+		if err != nil {
+			if test.want != nil {
+				t.Errorf("%s: %v", test.where, err)
+			}
+
+			continue
+		}
+
+		var ids []interface{}
+		if items, ok := res["[]"].([]interface{}); ok {
+			ids = getIDs(t, items, "Comment")
+		}
+
+		if !equalIDs(ids, test.want...) {
+			t.Errorf("%s: ids = %v, want %v", test.where, ids, test.want)
+		}
+	}
+
+	//13 是 82005 的评论，不能通过 @combine 删除
+	for _, where := range []string{
+		`"id": 13, "@combine": "userId | id"`,
+		`"id": 13, "id!": 22, "userId": 82001, "@combine": "userId & id! | id"`,
+	} {
+		res, err := parseSQLite(t, e, visitor, MethodDelete, `{"Comment": {"@role": "OWNER", `+where+`}, "tag": "Comment"}`)
+		if err == nil {
+			if count := getObject(t, res, "Comment")["count"]; count != float64(0) {
+				t.Errorf("%s: count = %v, want 0", where, count)
+			}
+		}
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Comment": {"id": 13, "@column": "id"}}`)
+	if id := getObject(t, res, "Comment")["id"]; id != float64(13) {
+		t.Errorf("Comment 13 = %v, want not deleted", res["Comment"])
+	}
+}
@@ -3,7 +3,9 @@ package apijson
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/iancoleman/orderedmap"
@@ -16,8 +18,15 @@ const (
 	IsArrayField = 2 //数组字段提取
 )
 
+//数组查询内容 query
+const (
+	QueryTable = 0 //只查询数据
+	QueryTotal = 1 //只查询总条数
+	QueryAll   = 2 //查询数据和总条数
+)
+
 type Join struct {
-	Type  string //join 类型 LEFT JOIN, RIGHT JOIN, INNER JOIN, FULL JOIN, OUTTER JOIN
+	Type  string //join 类型 LEFT JOIN, RIGHT JOIN, INNER JOIN, FULL JOIN, ANTI JOIN
 	Table string //关联副表
 	Field string //关联字段
 }
@@ -25,8 +34,11 @@ type Join struct {
 type ParseTree struct {
 	Key          string                              //key
 	IsArray      bool                                //是否数组
-	Size         int                                 //数组大小
-	SQLCount     int                                 //sql count
+	Size         int                                 //数组元素个数，记录在数组每个子节点（元素的第一个节点）中
+	SQLCount     int                                 //sql count，数组每页条数
+	Page         int                                 //数组页码，从 0 开始
+	Query        int                                 //数组查询内容，QueryTable、QueryTotal、QueryAll
+	Totals       []uint64                            //数组总条数，每个父数组元素一个
 	Joins        map[string]*Join                    //表 join
 	Children     []*ParseTree                        //子节点（在数组元素下的 '[]' 会有多个子节点）
 	Parent       *ParseTree                          //父节点
@@ -36,126 +48,351 @@ type ParseTree struct {
 	Index        int                                 //子节点索引 index
 	IsFieldArray bool                                //是否字段提取数组
 	FieldData    [][]interface{}                     //字段数组提取数据
+	IsValue      bool                                //是否引用赋值的值节点，如 "total@": "/[]/total"
+	Values       []interface{}                       //值节点数据
 	Data         []map[string]map[string]interface{} //数据
 }
 
-func Parse(ctx context.Context, dataSourceName string, reqbody []byte) ([]byte, error) {
+//Parser 一次请求的解析器
+type Parser struct {
+	Method RequestMethod //请求方法
+	DB     *Client       //数据库客户端
+	Role   RequestRole   //最外层 @role 指定的角色
+	Schema *Schema       //表结构，为空时不校验表和字段
+	Config *Config       //引擎配置，为空时使用 NewConfig 的默认配置
+
+	Concurrency int //最外层节点并发查询的最大数量，不大于 1 时顺序查询
+
+	access *accessRules //Access 表权限，每次请求加载一次，子查询复制的 Parser 共用
+}
+
+//Access 表中各表的方法对应的角色
+type accessRules struct {
+	roles map[string]map[RequestMethod][]RequestRole
+}
+
+func (p *Parser) config() *Config {
+	if p.Config == nil {
+		return &defaultConfig
+	}
+
+	return p.Config
+}
+
+//Parse 解析请求体，返回结果 JSON
+func (p *Parser) Parse(ctx context.Context, reqbody []byte) ([]byte, error) {
+	req, err := decodeRequest(reqbody)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.parseRequest(ctx, req)
+}
+
+//解析请求体为有序的 JSON 对象
+func decodeRequest(reqbody []byte) (*orderedmap.OrderedMap, error) {
 	req := orderedmap.New()
 	err := json.Unmarshal(reqbody, &req)
+	if err != nil {
+		return nil, fmt.Errorf("request body is not a valid JSON object: %v", err)
+	}
 
-	db, err := NewOrmClient(dataSourceName)
+	return req, nil
+}
+
+//解析已解码的请求，按最外层的 @role、@transaction 设置角色和事务
+func (p *Parser) parseRequest(ctx context.Context, req *orderedmap.OrderedMap) ([]byte, error) {
+	if tmp, ok := req.Get(KeyRole); ok {
+		role, _ := tmp.(string)
+		p.Role = RequestRole(strings.ToUpper(role))
+	}
+
+	opts, err := getTxOptions(req, p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		return p.parse(ctx, req)
+	}
+
+	//在一个事务中校验和执行，任何一步出错都回滚，已提交后回滚不生效
+	db := p.DB
+	tx, err := db.Begin(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p.DB = tx
+	defer func() {
+		_ = tx.Rollback()
+		p.DB = db
+	}()
+
+	ret, err := p.parse(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+//校验并解析请求
+func (p *Parser) parse(ctx context.Context, req *orderedmap.OrderedMap) ([]byte, error) {
+	//非开放请求按 Request 表校验请求结构
+	err := p.verifyRequest(ctx, req)
 	if err != nil {
 		return nil, err
 	}
 
 	head := ParseTree{}
-	err = ParseNode(ctx, req, 0, &head, &head, db)
+	err = p.ParseNode(ctx, req, 0, &head, &head)
+	if err != nil {
+		return nil, err
+	}
 
 	ret := orderedmap.New()
+	encodeResult(&head, ret)
 
-	if head.IsArray {
-		if head.IsFieldArray {
-			ret.Set(head.Key, &head.FieldData[0])
-		} else {
-			sub := []*orderedmap.OrderedMap{}
-			ret.Set(head.Key, &sub)
-			encodeArrayResult(head.Children[0], head.Size, &sub)
+	//与 APIJSON 的 JSONResponse 一致，成功时带上 code、msg、ok
+	ret.Set("code", CodeSuccess)
+	ret.Set("msg", MsgSuccess)
+	ret.Set("ok", true)
+
+	return ret.MarshalJSON()
+}
+
+//节点类型
+const (
+	nodeSkip   = iota //不是节点，如 count、page、@column
+	nodeValue         //引用赋值的值节点，如 "total@": "/[]/total"
+	nodeBatch         //批量新增、修改，如 "Moment[]": [{...}, {...}]
+	nodeObject        //对象或数组
+)
+
+//获取 key 对应的节点类型
+func (p *Parser) getNodeKind(req *orderedmap.OrderedMap, k string) int {
+	tmp, _ := req.Get(k)
+	if tmp == nil {
+		return nodeSkip
+	}
+
+	if isRef, _ := isAssociated(k); isRef {
+		if _, isString := tmp.(string); isString {
+			return nodeValue
 		}
-	} else {
-		encodeResult(&head, ret)
 	}
 
-	return ret.MarshalJSON()
+	//子查询在生成语句时编译，如 "id{}@": {"from": "Comment", ...}
+	if isSubquery(k, tmp) {
+		return nodeSkip
+	}
+
+	if p.Method.IsBatch() && isKeyArray(k) == IsArrayTrue {
+		if _, isList := tmp.([]interface{}); isList {
+			return nodeBatch
+		}
+	}
+
+	if _, isObject := tmp.(orderedmap.OrderedMap); isObject {
+		return nodeObject
+	}
+
+	return nodeSkip
+}
+
+//创建 key 对应的节点，值节点的 key 去掉 "@"
+func getKindNode(kind int, k string, node *ParseTree) *ParseTree {
+	if kind == nodeValue {
+		_, newKey := isAssociated(k)
+		return getValueNode(newKey, node)
+	}
+
+	return getObjectNode(k, node)
 }
 
-func ParseNode(ctx context.Context, req *orderedmap.OrderedMap,
-	index int, head, node *ParseTree, db *Client) error {
+//待解析的节点
+type parseItem struct {
+	key  string
+	kind int
+	node *ParseTree
+}
+
+//按顺序创建所有节点，引用后面的节点时找得到节点，数据为空
+func (p *Parser) getParseItems(req *orderedmap.OrderedMap, node *ParseTree) []*parseItem {
+	var items []*parseItem
+
 	for _, k := range req.Keys() {
-		v, ok := getSubMap(req, k)
-		if !ok {
+		kind := p.getNodeKind(req, k)
+		if kind == nodeSkip {
 			continue
 		}
 
-		if node.Key == "" {
-			node.Key = k
-		} else {
-			node = getSliblingNode(k, node)
+		node = getKindNode(kind, k, node)
+		items = append(items, &parseItem{key: k, kind: kind, node: node})
+	}
+
+	return items
+}
+
+//ParseNode 解析查询节点，返回的错误带有出错节点的路径
+func (p *Parser) ParseNode(ctx context.Context, req *orderedmap.OrderedMap,
+	index int, head, node *ParseTree) error {
+	items := p.getParseItems(req, node)
+
+	//最外层互不引用的节点并发查询
+	if p.isConcurrent(node) && len(items) > 1 {
+		return p.parseConcurrently(ctx, req, head, items)
+	}
+
+	for _, item := range items {
+		err := p.parseKey(ctx, req, item.kind, item.key, index, head, item.node)
+		if err != nil {
+			return errorWithPath(err, item.key)
 		}
+	}
 
-		isKeyArray := isKeyArray(k)
+	return nil
+}
 
-		if isKeyArray == IsArrayTrue || isKeyArray == IsArrayField {
-			//数组或者数组提取
-			node.IsArray = true
+//解析一个节点，node 为 getKindNode 创建的节点
+func (p *Parser) parseKey(ctx context.Context, req *orderedmap.OrderedMap, kind int, k string,
+	index int, head, node *ParseTree) error {
+	tmp, _ := req.Get(k)
 
-			if node.Parent != nil && node.Parent.IsArray {
-				for i := 0; i < node.Parent.Size; i++ {
-					err := parseArray(ctx, isKeyArray, i, k, v, head, node, db)
-					if err != nil {
-						return err
-					}
-				}
-			} else {
-				err := parseArray(ctx, isKeyArray, 0, k, v, head, node, db)
-				if err != nil {
-					return err
-				}
-			}
+	switch kind {
+	case nodeValue:
+		return parseValue(tmp.(string), index, head, node)
+	case nodeBatch:
+		d, err := p.parseBatch(ctx, k, tmp.([]interface{}), index, head, node)
+		if err != nil {
+			return err
+		}
+
+		node.Data = append(node.Data, d)
+		return nil
+	}
+
+	v, _ := getSubMap(req, k)
+
+	isKeyArray := isKeyArray(k)
+
+	if isKeyArray == IsArrayTrue || isKeyArray == IsArrayField {
+		//数组或者数组提取
+		if !p.Method.IsGet() {
+			return fmt.Errorf("array %s is only supported by %s and %s", k, MethodGet, MethodGets)
+		}
+
+		node.IsArray = true
+
+		err := parseArrayParams(v, node, p.config().Limits)
+		if err != nil {
+			return err
+		}
+
+		//数组元素下的数组每个父数组元素解析一次，其它数组只有一个元素
+		size := 1
+		if node.Parent != nil && node.Parent.IsArray {
+			size = elementSize(node)
+		}
+
+		//每个父数组元素一个总条数，引用的对象不存在等没有查询的为 0，与 Children 一一对应
+		if node.Query != QueryTable {
+			node.Totals = make([]uint64, size)
+		}
 
-			if isKeyArray == IsArrayField { //数组字段提取
-				node.IsFieldArray = true
-				node.Children = nil
-				node.Data = nil
-				node.Size = len(node.FieldData)
+		for i := 0; i < size; i++ {
+			err := p.parseArray(ctx, isKeyArray, i, k, v, head, node)
+			if err != nil {
+				return err
 			}
-		} else {
-			if node.Parent != nil && node.Parent.IsArray {
-				if node.First == nil { //数组元素的第一个节点
-					if len(node.Parent.Joins) > 0 { //解析join
-						err := parseJoin(req, head, node)
-						if err != nil {
-							return err
-						}
-					}
-
-					ds, err := findAll(ctx, k, v, index, head, node, db)
-					if err != nil {
-						return err
-					}
-
-					node.Parent.Size = len(ds)
-
-					for _, d := range ds {
-						data := map[string]map[string]interface{}{k: d}
-						node.Data = append(node.Data, data)
-					}
-				} else {
-					for i := 0; i < node.Parent.Size; i++ {
-						d, err := findOne(ctx, k, v, i, head, node, db)
-						if err != nil {
-							return err
-						}
-
-						node.Data = append(node.Data, d)
-					}
-				}
-			} else { //对象元素
-				d, err := findOne(ctx, k, v, index, head, node, db)
-				if err != nil {
-					return err
-				}
-
-				node.Data = append(node.Data, d)
+		}
+
+		if isKeyArray == IsArrayField { //数组字段提取
+			node.IsFieldArray = true
+			node.Children = nil
+			node.Data = nil
+		}
+
+		return nil
+	}
+
+	if node.Parent == nil || !node.Parent.IsArray { //对象元素
+		d, err := p.parseObject(ctx, k, v, index, head, node)
+		if err != nil {
+			return err
+		}
+
+		node.Data = append(node.Data, d)
+		return nil
+	}
+
+	if node.First == nil { //数组元素的第一个节点，有 join 时一并查询副表
+		ds, err := p.findAll(ctx, req, k, v, index, head, node)
+		if err != nil {
+			return err
+		}
+
+		node.Size = len(ds)
+		node.Data = ds
+		return nil
+	}
+
+	if _, isJoined := node.Parent.Joins[k]; isJoined { //join 副表，数据已随第一个节点查出
+		for i := 0; i < elementSize(node); i++ {
+			data := map[string]map[string]interface{}{k: node.First.Data[i][k]}
+
+			err := p.callFunctions(ctx, v, data[k], index, head, node)
+			if err != nil {
+				return err
 			}
+
+			node.Data = append(node.Data, data)
+		}
+
+		return nil
+	}
+
+	//按引用批量查询，不能批量时逐个元素查询
+	ds, isBatch, err := p.findBatch(ctx, k, v, head, node)
+	if err != nil {
+		return err
+	}
+
+	if isBatch {
+		node.Data = ds
+		return nil
+	}
+
+	for i := 0; i < elementSize(node); i++ {
+		d, err := p.findOne(ctx, k, v, i, head, node)
+		if err != nil {
+			return err
 		}
+
+		node.Data = append(node.Data, d)
 	}
 
 	return nil
 }
 
+//数组元素的个数，由元素的第一个节点查询后记录，数组内的其它节点按它逐个解析
+func elementSize(node *ParseTree) int {
+	if node.First != nil {
+		return node.First.Size
+	}
+
+	return node.Size
+}
+
 //解析数组
-func parseArray(ctx context.Context, isKeyArray, index int, k string, v *orderedmap.OrderedMap,
-	head, node *ParseTree, db *Client) error {
+func (p *Parser) parseArray(ctx context.Context, isKeyArray, index int, k string, v *orderedmap.OrderedMap,
+	head, node *ParseTree) error {
 	child := ParseTree{
 		Index:  index,
 		Parent: node,
@@ -163,11 +400,70 @@ func parseArray(ctx context.Context, isKeyArray, index int, k string, v *ordered
 
 	node.Children = append(node.Children, &child)
 
-	//是否有 count
-	if cntTmp, hasCount := v.Get("count"); hasCount {
-		count, _ := cntTmp.(float64)
-		node.SQLCount = int(count)
-		v.Delete("count")
+	err := p.ParseNode(ctx, v, index, head, &child)
+	if err != nil {
+		return err
+	}
+
+	if isKeyArray == IsArrayField { //数组字段提取
+		if node.FieldData == nil {
+			//最外层的数组字段提取只有一个元素
+			size := 1
+			if node.Parent != nil && node.Parent.IsArray {
+				size = elementSize(node)
+			}
+
+			node.FieldData = make([][]interface{}, size)
+		}
+
+		node.FieldData[index], _ = getFieldArray(k, &child)
+	}
+
+	return nil
+}
+
+//解析数组参数 count、page、query 和 join，非对象值在 ParseNode 中会被跳过，所以这里不删除
+func parseArrayParams(v *orderedmap.OrderedMap, node *ParseTree, limits Limits) error {
+	limits = limits.orDefault()
+	node.SQLCount = limits.DefaultCount
+	node.Page = 0
+	node.Query = QueryTable
+
+	if tmp, ok := v.Get("count"); ok {
+		count, err := getNonNegativeInt(tmp)
+		if err != nil {
+			return fmt.Errorf("count is invalid: %v", err)
+		}
+
+		if count > 0 {
+			node.SQLCount = count
+		}
+	}
+
+	if node.SQLCount > limits.MaxCount {
+		return NewOutOfRangeError("count must be in range [0, %d]", limits.MaxCount)
+	}
+
+	if tmp, ok := v.Get("page"); ok {
+		page, err := getNonNegativeInt(tmp)
+		if err != nil {
+			return fmt.Errorf("page is invalid: %v", err)
+		}
+
+		if page > limits.MaxPage {
+			return NewOutOfRangeError("page must be in range [0, %d]", limits.MaxPage)
+		}
+
+		node.Page = page
+	}
+
+	if tmp, ok := v.Get("query"); ok {
+		query, err := getNonNegativeInt(tmp)
+		if err != nil || query > QueryAll {
+			return fmt.Errorf("query must be one of %d, %d, %d", QueryTable, QueryTotal, QueryAll)
+		}
+
+		node.Query = query
 	}
 
 	//是否有 join
@@ -178,23 +474,23 @@ func parseArray(ctx context.Context, isKeyArray, index int, k string, v *ordered
 		}
 
 		node.Joins = joins
-		v.Delete("join")
 	}
 
-	err := ParseNode(ctx, v, index, head, &child, db)
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
-	if isKeyArray == IsArrayField { //数组字段提取
-		if node.FieldData == nil {
-			node.FieldData = make([][]interface{}, node.Parent.Size)
-		}
+//获取非负整数
+func getNonNegativeInt(tmp interface{}) (int, error) {
+	f, ok := tmp.(float64)
+	if !ok {
+		return 0, fmt.Errorf("not a number")
+	}
 
-		node.FieldData[index], _ = getFieldArray(k, &child)
+	if f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, fmt.Errorf("must be a non-negative integer")
 	}
 
-	return nil
+	return int(f), nil
 }
 
 //获取sub map
@@ -212,6 +508,47 @@ func getSubMap(req *orderedmap.OrderedMap, key string) (*orderedmap.OrderedMap,
 	return &v, true
 }
 
+//获取值节点
+func getValueNode(key string, node *ParseTree) *ParseTree {
+	node = getObjectNode(key, node)
+	node.IsValue = true
+	return node
+}
+
+//获取对象节点，第一个节点直接使用当前节点
+func getObjectNode(key string, node *ParseTree) *ParseTree {
+	if node.Key == "" {
+		node.Key = key
+		return node
+	}
+
+	return getSliblingNode(key, node)
+}
+
+//解析值节点，数组元素下每个元素一个值
+func parseValue(associated string, index int, head, node *ParseTree) error {
+	if node.Parent != nil && node.Parent.IsArray {
+		for i := 0; i < elementSize(node); i++ {
+			val, err := associatedAssignment(associated, i, head, node)
+			if err != nil {
+				return err
+			}
+
+			node.Values = append(node.Values, val)
+		}
+
+		return nil
+	}
+
+	val, err := associatedAssignment(associated, index, head, node)
+	if err != nil {
+		return err
+	}
+
+	node.Values = append(node.Values, val)
+	return nil
+}
+
 //获取兄弟节点
 func getSliblingNode(key string, node *ParseTree) *ParseTree {
 	slibling := ParseTree{
@@ -229,35 +566,270 @@ func getSliblingNode(key string, node *ParseTree) *ParseTree {
 	return &slibling
 }
 
-//解析join
-func parseJoin(req *orderedmap.OrderedMap, head, node *ParseTree) error {
+//生成 join 语句，主表和副表的条件各自加上表名前缀，查询字段别名为 "表名.字段名"
+func (p *Parser) genJoinStatement(ctx context.Context, req *orderedmap.OrderedMap, table string,
+	where *orderedmap.OrderedMap, index int, head, node *ParseTree) (*Statement, error) {
+	where, access, err := p.verifyAccess(ctx, table, where)
+	if err != nil {
+		return nil, err
+	}
+
+	where, err = p.subqueries(ctx, where, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	newWhere, err := associatedAssignments(where, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(table)
+	statement.SetDialect(p.DB.GetDialect())
+	statement.SetFunctions(p.config().ColumnFunctions, p.config().AggregateFunctions)
+
+	columns, err := p.joinColumns(ctx, table, newWhere)
+	if err != nil {
+		return nil, err
+	}
+
+	statement.Where(scopeWhere(table, newWhere))
+	statement.AccessWhere(scopeWhere(table, access))
+
 	joins := node.Parent.Joins
+	joinedTables := map[string]bool{table: true}
+
 	for _, k := range req.Keys() {
 		join, ok := joins[k]
-		if ! ok {
+		if !ok {
 			continue
 		}
 
-		tmp, ok := req.Get(k)
+		v, ok := getSubMap(req, k)
 		if !ok {
-			continue
+			return nil, fmt.Errorf("joined table %s is not in array", k)
 		}
 
-		v, ok := tmp.(orderedmap.OrderedMap)
+		tmp, ok := v.Get(join.Field)
 		if !ok {
-			continue
+			return nil, fmt.Errorf("not find joined field %s in %s", join.Field, k)
 		}
 
-		joinedTableField, ok := v.Get(join.Field)
+		associated, ok := tmp.(string)
 		if !ok {
-			return fmt.Errorf("not find joined field")
+			return nil, fmt.Errorf("joined field %s in %s is invalid", join.Field, k)
 		}
 
-		fmt.Println(v)
-		fmt.Println(join)
-		fmt.Println(joinedTableField)
+		onTable, onColumn, err := getJoinOn(associated)
+		if err != nil {
+			return nil, err
+		}
+
+		if !joinedTables[onTable] {
+			return nil, fmt.Errorf("table %s joined by %s is not joined before", onTable, k)
+		}
+
+		joinedTables[k] = true
+
+		//副表的 join 字段作为 ON 条件，其余作为 WHERE 条件
+		joinWhere := orderedmap.New()
+		for _, key := range v.Keys() {
+			if key == join.Field {
+				continue
+			}
+
+			//ANTI JOIN 的副表没有匹配的行，其它条件没有意义
+			if join.Type == "ANTI JOIN" && key != KeyColumn {
+				return nil, fmt.Errorf("anti joined table %s can not have condition %s", k, key)
+			}
+
+			val, _ := v.Get(key)
+			joinWhere.Set(key, val)
+		}
+
+		joinWhere, joinAccess, err := p.verifyAccess(ctx, k, joinWhere)
+		if err != nil {
+			return nil, err
+		}
+
+		joinWhere, err = p.subqueries(ctx, joinWhere, index, head, node)
+		if err != nil {
+			return nil, err
+		}
+
+		joinWhere, err = associatedAssignments(joinWhere, index, head, node)
+		if err != nil {
+			return nil, err
+		}
+
+		on := JoinOn{onTable + "." + onColumn: strings.TrimSuffix(join.Field, "@")}
+
+		switch join.Type {
+		case "LEFT JOIN":
+			statement.LeftJoin(k, on)
+		case "RIGHT JOIN":
+			statement.RightJoin(k, on)
+		case "INNER JOIN":
+			statement.InnerJoin(k, on)
+		case "FULL JOIN":
+			statement.FullJoin(k, on)
+		case "ANTI JOIN":
+			statement.LeftJoin(k, on)
+			joinWhere.Set(strings.TrimSuffix(join.Field, "@"), nil)
+		default:
+			return nil, fmt.Errorf("join type %s is not supported", join.Type)
+		}
+
+		joinedColumns, err := p.joinColumns(ctx, k, joinWhere)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, joinedColumns...)
+		statement.Where(scopeWhere(k, joinWhere))
+		statement.AccessWhere(scopeWhere(k, joinAccess))
 	}
-	return nil
+
+	statement.Select(columns...)
+
+	return statement, nil
+}
+
+//获取 join ON 条件引用的表和字段，如 "/Moment/userId"
+func getJoinOn(associated string) (table, column string, err error) {
+	path := strings.Split(strings.TrimPrefix(associated, "/"), "/")
+	if len(path) != 2 || path[0] == "" || path[1] == "" {
+		return "", "", fmt.Errorf("joined path %s is invalid", associated)
+	}
+
+	return path[0], path[1], nil
+}
+
+//join 查询字段，未指定 @column 时查询表的所有字段，别名为 "表名.字段名"
+func (p *Parser) joinColumns(ctx context.Context, table string, where *orderedmap.OrderedMap) ([]string, error) {
+	tmp, ok := where.Get(KeyColumn)
+	if !ok {
+		//表的字段名来自数据库，不需要校验
+		names, err := p.DB.Columns(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+
+		columns := make([]string, 0, len(names))
+		for _, name := range names {
+			column, err := Column{Expr: name}.SQL(table, table+"."+name)
+			if err != nil {
+				return nil, err
+			}
+
+			columns = append(columns, column)
+		}
+
+		return columns, nil
+	}
+
+	column, _ := tmp.(string)
+	fields, err := ParseColumns(column, p.config().ColumnFunctions)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(fields))
+	for _, field := range fields {
+		column, err := field.SQL(table, table+"."+field.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, column)
+	}
+
+	return columns, nil
+}
+
+//条件加上表名前缀，where 为 nil 时返回 nil，如没有角色条件
+func scopeWhere(table string, where *orderedmap.OrderedMap) *orderedmap.OrderedMap {
+	if where == nil {
+		return nil
+	}
+
+	scoped := orderedmap.New()
+
+	for _, key := range where.Keys() {
+		val, _ := where.Get(key)
+
+		switch key {
+		case KeyColumn: //查询字段由 joinColumns 处理
+		case "@order":
+			order, _ := val.(string)
+			fields := strings.Split(order, ",")
+			for i, field := range fields {
+				fields[i] = table + "." + strings.TrimSpace(field)
+			}
+			scoped.Set(key, strings.Join(fields, ","))
+		case KeyGroup:
+			group, _ := val.(string)
+			fields := strings.Split(group, ",")
+			for i, field := range fields {
+				fields[i] = table + "." + strings.TrimSpace(field)
+			}
+			scoped.Set(key, strings.Join(fields, ","))
+		case KeyHaving: //聚合函数的参数加上表名前缀，解析失败时原样保留，由 Statement.Where 报错
+			having, err := getHavingMap(val)
+			if err != nil {
+				scoped.Set(key, val)
+				continue
+			}
+
+			scopedHaving := orderedmap.New()
+			for _, k := range having.Keys() {
+				v, _ := having.Get(k)
+				column, _, _, _ := pregOperatorMatch(k)
+				scopedHaving.Set(scopeAggregate(table, column)+strings.TrimPrefix(k, column), v)
+			}
+			scoped.Set(key, scopedHaving)
+		case KeyCombine: //条件组合的 key 同样加上表名前缀，解析失败时原样保留，由 Statement.Where 报错
+			expr, _ := val.(string)
+			combine, err := ParseCombine(expr)
+			if err != nil {
+				scoped.Set(key, val)
+				continue
+			}
+
+			scoped.Set(key, combine.Map(func(k string) string { return table + "." + k }).String())
+		default:
+			if strings.HasPrefix(key, "@") { //自定义关键词不是条件，原样保留
+				scoped.Set(key, val)
+				continue
+			}
+
+			scoped.Set(table+"."+key, val)
+		}
+	}
+
+	return scoped
+}
+
+//将 join 查询结果按表名拆分
+func splitJoinRow(row map[string]interface{}) map[string]map[string]interface{} {
+	ret := map[string]map[string]interface{}{}
+
+	for column, val := range row {
+		dotIndex := strings.Index(column, ".")
+		if dotIndex == -1 {
+			continue
+		}
+
+		table := column[:dotIndex]
+		if ret[table] == nil {
+			ret[table] = map[string]interface{}{}
+		}
+
+		ret[table][column[dotIndex+1:]] = val
+	}
+
+	return ret
 }
 
 //是否数组，字段提取数组
@@ -351,17 +923,30 @@ func realGetFieldArray(path []string, node *ParseTree, result *[]interface{}) er
 }
 
 //查询一个记录
-func findOne(ctx context.Context, table string,
+func (p *Parser) findOne(ctx context.Context, table string,
 	where *orderedmap.OrderedMap, index int,
-	head, node *ParseTree, db *Client) (map[string]map[string]interface{}, error) {
-	statement := genStatement(table, where, index, head, node)
+	head, node *ParseTree) (map[string]map[string]interface{}, error) {
+	where, access, err := p.verifyAccess(ctx, table, where)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := p.genStatement(ctx, table, where, access, index, head, node)
+	if err != nil {
+		return nil, err
+	}
 
-	//引用赋值异常，查询结果直接置为 nil
+	//引用的对象不存在，查询结果直接置为 nil
 	if statement == nil {
 		return nil, nil
 	}
 
-	d, err := db.FindOneMap(ctx, statement)
+	d, err := p.DB.FindOneMap(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.callFunctions(ctx, where, d, index, head, node)
 	if err != nil {
 		return nil, err
 	}
@@ -369,39 +954,123 @@ func findOne(ctx context.Context, table string,
 	return map[string]map[string]interface{}{table: d}, nil
 }
 
-//查询多条记录
-func findAll(ctx context.Context, table string,
+//查询多条记录，有 join 时结果包含副表数据
+func (p *Parser) findAll(ctx context.Context, req *orderedmap.OrderedMap, table string,
 	where *orderedmap.OrderedMap, index int,
-	head, node *ParseTree, db *Client) ([]map[string]interface{}, error) {
-	statement := genStatement(table, where, index, head, node)
+	head, node *ParseTree) ([]map[string]map[string]interface{}, error) {
+	parent := node.Parent
+	isJoin := len(parent.Joins) > 0
+
+	var statement *Statement
+	if isJoin {
+		var err error
+		statement, err = p.genJoinStatement(ctx, req, table, where, index, head, node)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		where, access, err := p.verifyAccess(ctx, table, where)
+		if err != nil {
+			return nil, err
+		}
+
+		statement, err = p.genStatement(ctx, table, where, access, index, head, node)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	//引用赋值异常，查询结果直接置为 nil
+	//引用的对象不存在，查询结果直接置为 nil，总条数为 0
 	if statement == nil {
 		return nil, nil
 	}
 
-	d, err := db.FindAllMaps(ctx, statement)
+	//总条数，count 会改写 select，所以复制一份语句
+	if parent.Query != QueryTable {
+		//分组时保留查询字段，@having 可能用到其中的别名
+		countStatement := *statement
+		if countStatement.GetGroupby() == "" {
+			countStatement.Select("*")
+		}
+
+		total, err := p.DB.Count(ctx, &countStatement)
+		if err != nil {
+			return nil, err
+		}
+
+		parent.Totals[node.Index] = total
+
+		if parent.Query == QueryTotal {
+			return nil, nil
+		}
+	}
+
+	//分页，每个父数组元素各自分页，偏移量按 int64 计算，超过 int32 时报错
+	if parent.SQLCount > 0 {
+		offset := int64(parent.Page) * int64(parent.SQLCount)
+		if offset > math.MaxInt32 {
+			return nil, NewOutOfRangeError("offset %d of page %d is too large", offset, parent.Page)
+		}
+
+		statement.LimitOffset(int32(parent.SQLCount), int32(offset))
+	}
+
+	rows, err := p.DB.FindAllMaps(ctx, statement)
 	if err != nil {
 		return nil, err
 	}
 
-	return d, nil
+	ds := make([]map[string]map[string]interface{}, 0, len(rows))
+	for _, row := range rows {
+		var d map[string]map[string]interface{}
+		if isJoin {
+			d = splitJoinRow(row)
+		} else {
+			d = map[string]map[string]interface{}{table: row}
+		}
+
+		err = p.callFunctions(ctx, where, d[table], index, head, node)
+		if err != nil {
+			return nil, err
+		}
+
+		ds = append(ds, d)
+	}
+
+	return ds, nil
 }
 
-//生成 sql 语法
-func genStatement(table string, where *orderedmap.OrderedMap,
-	index int, head, node *ParseTree) *Statement {
+//生成 sql 语法，access 为 verifyAccess 返回的角色条件，引用的对象不存在时返回 nil 语句，其余错误如引用路径错误直接返回
+func (p *Parser) genStatement(ctx context.Context, table string, where, access *orderedmap.OrderedMap,
+	index int, head, node *ParseTree) (*Statement, error) {
+	//子查询
+	where, err := p.subqueries(ctx, where, index, head, node)
+	if errors.Is(err, ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
 	//关联引用赋值
 	newWhere, err := associatedAssignments(where, index, head, node)
+	if errors.Is(err, ErrNotExist) {
+		return nil, nil
+	}
+
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	statement := NewDbStatement()
 	statement.SetTableName(table)
+	statement.SetDialect(p.DB.GetDialect())
+	statement.SetFunctions(p.config().ColumnFunctions, p.config().AggregateFunctions)
 	statement.Where(newWhere)
+	statement.AccessWhere(access)
 
-	return statement
+	return statement, nil
 }
 
 //关联引用赋值
@@ -435,7 +1104,12 @@ func associatedAssignments(orderMap *orderedmap.OrderedMap,
 			continue
 		}
 
-		//判断是否引用
+		//判断是否引用，子查询已编译，不是引用
+		if _, isSub := val.(*Subquery); isSub {
+			newWhere.Set(key, val)
+			continue
+		}
+
 		if ok, newKey := isAssociated(key); ok {
 			associated, isString := val.(string)
 			if !isString {
@@ -520,9 +1194,14 @@ func associatedAssignment(associated string,
 		return nil, fmt.Errorf("associated path is end")
 	}
 
+	//数组总条数
+	if next.IsArray && !next.IsFieldArray && field == "total" {
+		return getArrayTotal(node, next, index)
+	}
+
 	if (isKeyArr != IsArrayField && len(next.Data) == 0) ||
 		(isKeyArr == IsArrayField && len(next.FieldData) == 0) {
-		return nil, fmt.Errorf("associated data is empty")
+		return nil, NewNotExistError("associated data of %s is empty", associated)
 	}
 
 	index = findChildIndex(node, next, index)
@@ -533,19 +1212,33 @@ func associatedAssignment(associated string,
 	if isKeyArr != IsArrayField {
 		data := next.Data[index][next.Key]
 		if _, ok := data[field]; !ok {
-			return nil, fmt.Errorf("not find associated field")
+			return nil, NewNotExistError("associated field of %s is not found", associated)
 		}
 
 		return data[field], nil
 	} else {
 		if len(next.FieldData[index]) == 0 {
-			return nil, fmt.Errorf("associated data is empty")
+			return nil, NewNotExistError("associated data of %s is empty", associated)
 		}
 
 		return next.FieldData[index], nil
 	}
 }
 
+//获取数组总条数
+func getArrayTotal(node, next *ParseTree, index int) (interface{}, error) {
+	if next.Query == QueryTable {
+		return nil, fmt.Errorf("array total is not queried, query must be %d or %d", QueryTotal, QueryAll)
+	}
+
+	index = findChildIndex(node, next, index)
+	if index == -1 || index >= len(next.Totals) { //关联引用值不在本节点的所有父层级
+		return nil, fmt.Errorf("associated is not in parents layer")
+	}
+
+	return next.Totals[index], nil
+}
+
 //从 node 回溯找到子路径 index
 func findChildIndex(node, next *ParseTree, index int) int {
 	//首先判断是否与 next 为平行节点
@@ -622,7 +1315,10 @@ func getJoins(joinTmp interface{}) (map[string]*Join, error) {
 }
 
 func getJoin(joinStr string) (*Join, error) {
-	joinFields := strings.Split(joinStr, "/")
+	joinFields := strings.Split(strings.TrimSpace(joinStr), "/")
+	if len(joinFields) != 3 || joinFields[1] == "" || joinFields[2] == "" {
+		return nil, fmt.Errorf("join %s is invalid", joinStr)
+	}
 
 	join := Join{}
 
@@ -633,10 +1329,10 @@ func getJoin(joinStr string) (*Join, error) {
 		join.Type = "RIGHT JOIN"
 	case "&":
 		join.Type = "INNER JOIN"
-	case "FULL":
+	case "|", "FULL":
 		join.Type = "FULL JOIN"
-	case "!":
-		join.Type = "OUTER JOIN"
+	case "!": //主表中没有关联副表的行，用 LEFT JOIN 加副表关联字段 IS NULL 实现
+		join.Type = "ANTI JOIN"
 	default:
 		return nil, fmt.Errorf("join type is invalid")
 	}
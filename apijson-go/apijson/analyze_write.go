@@ -0,0 +1,426 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//解析对象，按请求方法查询、统计、新增、修改或删除
+func (p *Parser) parseObject(ctx context.Context, table string,
+	where *orderedmap.OrderedMap, index int,
+	head, node *ParseTree) (map[string]map[string]interface{}, error) {
+	var d map[string]interface{}
+	var err error
+
+	switch {
+	case p.Method.IsHead():
+		d, err = p.count(ctx, table, where, index, head, node)
+	case p.Method == MethodPost:
+		d, err = p.insert(ctx, table, where, index, head, node)
+	case p.Method == MethodPut:
+		d, err = p.update(ctx, table, where, index, head, node)
+	case p.Method == MethodDelete:
+		d, err = p.delete(ctx, table, where, index, head, node)
+	default:
+		return p.findOne(ctx, table, where, index, head, node)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]map[string]interface{}{table: d}, nil
+}
+
+//批量新增、修改，k 为 "Table[]"
+func (p *Parser) parseBatch(ctx context.Context, k string, items []interface{}, index int,
+	head, node *ParseTree) (map[string]map[string]interface{}, error) {
+	table := k[:len(k)-2]
+
+	var count int64
+	ids := make([]interface{}, 0, len(items))
+
+	for i, item := range items {
+		v, ok := item.(orderedmap.OrderedMap)
+		if !ok {
+			return nil, fmt.Errorf("%s[%d] is not an object", k, i)
+		}
+
+		var d map[string]interface{}
+		var err error
+
+		if p.Method == MethodPost {
+			d, err = p.insert(ctx, table, &v, index, head, node)
+		} else {
+			d, err = p.update(ctx, table, &v, index, head, node)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		ids = append(ids, d["id"])
+		count += d["count"].(int64)
+	}
+
+	return map[string]map[string]interface{}{k: {"count": count, "id[]": ids}}, nil
+}
+
+//统计
+func (p *Parser) count(ctx context.Context, table string,
+	where *orderedmap.OrderedMap, index int,
+	head, node *ParseTree) (map[string]interface{}, error) {
+	where, access, err := p.verifyAccess(ctx, table, where)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := p.genStatement(ctx, table, where, access, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	//引用的对象不存在，查询结果直接置为 nil
+	if statement == nil {
+		return nil, nil
+	}
+
+	statement.Select("*")
+
+	total, err := p.DB.Count(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"count": total}, nil
+}
+
+//新增
+func (p *Parser) insert(ctx context.Context, table string,
+	values *orderedmap.OrderedMap, index int,
+	head, node *ParseTree) (map[string]interface{}, error) {
+	newValues, err := associatedAssignments(values, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	newValues, _, err = p.verifyAccess(ctx, table, newValues)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := getSetMap(newValues, p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) == 0 {
+		return nil, fmt.Errorf("%s has no value to %s", table, p.Method)
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(table)
+	statement.InsertMap(attributes)
+
+	lastID, err := p.DB.Insert(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	var id interface{} = lastID
+	if v, ok := attributes["id"]; ok {
+		id = v
+	}
+
+	return map[string]interface{}{"id": id, "count": int64(1)}, nil
+}
+
+//修改，只能按 id 或 id{} 修改，其余字段为修改的值
+func (p *Parser) update(ctx context.Context, table string,
+	values *orderedmap.OrderedMap, index int,
+	head, node *ParseTree) (map[string]interface{}, error) {
+	newValues, err := associatedAssignments(values, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	where := orderedmap.New()
+	set := orderedmap.New()
+
+	for _, key := range newValues.Keys() {
+		val, _ := newValues.Get(key)
+		if key == "id" || key == "id{}" || key == KeyRole {
+			where.Set(key, val)
+		} else {
+			set.Set(key, val)
+		}
+	}
+
+	role, err := getRole(where, p.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	where, access, err := p.verifyAccess(ctx, table, where)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err = p.verifySchema(table, set, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = p.verifyVisitorIDValues(table, role, set); err != nil {
+		return nil, err
+	}
+
+	ret, err := getIDResult(table, where, p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err = p.mergeArrays(ctx, table, where, access, set)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := getSetMap(set, p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(attributes) == 0 {
+		return nil, fmt.Errorf("%s has no value to %s", table, p.Method)
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(table)
+	statement.SetDialect(p.DB.GetDialect())
+	statement.UpdateMap(attributes)
+	statement.Where(where)
+	statement.AccessWhere(access)
+
+	count, err := p.DB.Update(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	ret["count"] = count
+	return ret, nil
+}
+
+//删除，必须包含 id 或 id{} 条件
+func (p *Parser) delete(ctx context.Context, table string,
+	where *orderedmap.OrderedMap, index int,
+	head, node *ParseTree) (map[string]interface{}, error) {
+	newWhere, err := p.subqueries(ctx, where, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	newWhere, err = associatedAssignments(newWhere, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	newWhere, access, err := p.verifyAccess(ctx, table, newWhere)
+	if err != nil {
+		return nil, err
+	}
+
+	ret, err := getIDResult(table, newWhere, p.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(table)
+	statement.Where(newWhere)
+	statement.AccessWhere(access)
+
+	count, err := p.DB.Delete(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	ret["count"] = count
+	return ret, nil
+}
+
+//校验 id 或 id{} 条件，防止误改、误删整张表，返回包含 id 或 id[] 的结果
+func getIDResult(table string, where *orderedmap.OrderedMap, method RequestMethod) (map[string]interface{}, error) {
+	if id, ok := where.Get("id"); ok && id != nil {
+		return map[string]interface{}{"id": id}, nil
+	}
+
+	if tmp, ok := where.Get("id{}"); ok {
+		if ids, isList := tmp.([]interface{}); isList && len(ids) > 0 {
+			return map[string]interface{}{"id[]": ids}, nil
+		}
+	}
+
+	return nil, NewConditionError("%s must have id or id{} to %s", table, method)
+}
+
+//PUT 数组字段增删，如 "praiseUserIdList+": [82001]，查出原数组增删后整体替换，与 APIJSON 一致，
+//在事务中锁定要修改的行后再查询，防止并发的增删互相覆盖
+func (p *Parser) mergeArrays(ctx context.Context, table string,
+	where, access, set *orderedmap.OrderedMap) (*orderedmap.OrderedMap, error) {
+	merged := orderedmap.New()
+
+	for _, key := range set.Keys() {
+		val, _ := set.Get(key)
+
+		column, operator, _, _ := pregOperatorMatch(key)
+		items, isList := val.([]interface{})
+		if !isList || (operator != OPAdd && operator != OPSub) {
+			merged.Set(key, val)
+			continue
+		}
+
+		id, ok := where.Get("id")
+		if !ok {
+			return nil, NewConditionError("%s must have id to put %s", table, key)
+		}
+
+		if p.DB.Tx == nil {
+			return nil, fmt.Errorf("%s of %s can not be put without transaction, remove %s: false", key, table, KeyTransaction)
+		}
+
+		statement := NewDbStatement()
+		statement.SetTableName(table)
+		statement.Select(columnQuote(column))
+		statement.Where(where)
+		statement.AccessWhere(access)
+		statement.ForUpdate(p.DB.GetDialect().ForUpdate())
+
+		row, err := p.DB.FindOneMap(ctx, statement)
+		if err != nil {
+			return nil, err
+		}
+
+		if row == nil {
+			return nil, NewNotExistError("%s id %v not exist", table, id)
+		}
+
+		current, err := getJSONArray(row[column])
+		if err != nil {
+			return nil, fmt.Errorf("%s of %s is not an array: %v", column, table, err)
+		}
+
+		for _, item := range items {
+			i := indexOfArray(current, item)
+
+			if operator == OPAdd {
+				if i != -1 {
+					return nil, NewConflictError("%s of %s already contains %v", column, table, item)
+				}
+
+				current = append(current, item)
+			} else {
+				if i == -1 {
+					return nil, NewNotExistError("%s of %s does not contain %v", column, table, item)
+				}
+
+				current = append(current[:i], current[i+1:]...)
+			}
+		}
+
+		merged.Set(column, current)
+	}
+
+	return merged, nil
+}
+
+//解析数据库中的 JSON 数组字段，空值为空数组
+func getJSONArray(val interface{}) ([]interface{}, error) {
+	arr := []interface{}{}
+
+	if v := reflect.ValueOf(val); v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return arr, nil
+		}
+
+		val = v.Elem().Interface()
+	}
+
+	str, err := rowToString(val)
+	if err != nil {
+		return nil, err
+	}
+
+	if str == "" {
+		return arr, nil
+	}
+
+	err = json.Unmarshal([]byte(str), &arr)
+	if err != nil {
+		return nil, err
+	}
+
+	return arr, nil
+}
+
+//数组中元素的位置，不存在则为 -1
+func indexOfArray(arr []interface{}, item interface{}) int {
+	for i, v := range arr {
+		if reflect.DeepEqual(v, item) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+//获取新增、修改的字段和值，对象和数组转为 JSON 字符串，PUT 支持 "key+"、"key-" 自增、自减
+func getSetMap(values *orderedmap.OrderedMap, method RequestMethod) (SetMap, error) {
+	attributes := SetMap{}
+
+	for _, key := range values.Keys() {
+		if key == "" || key[0] == '@' {
+			continue
+		}
+
+		val, _ := values.Get(key)
+
+		column, operator, _, _ := pregOperatorMatch(key)
+		if !aliasRegexp.MatchString(column) {
+			return nil, fmt.Errorf("key %s is not a valid column", key)
+		}
+
+		switch operator {
+		case "": //普通字段
+		case OPAdd, OPSub:
+			if method != MethodPut {
+				return nil, fmt.Errorf("key %s is only supported by %s", key, MethodPut)
+			}
+
+			_, isNumber := val.(float64)
+			_, isString := val.(string)
+			if !isNumber && !(isString && operator == OPAdd) {
+				return nil, fmt.Errorf("value of %s must be a number, or a string for key+", key)
+			}
+		default:
+			return nil, fmt.Errorf("key %s is not supported to set value", key)
+		}
+
+		switch val.(type) {
+		case orderedmap.OrderedMap, []interface{}:
+			b, err := json.Marshal(val)
+			if err != nil {
+				return nil, err
+			}
+
+			val = string(b)
+		}
+
+		attributes[key] = val
+	}
+
+	return attributes, nil
+}
@@ -0,0 +1,252 @@
+package apijson
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func getList(t *testing.T, res map[string]interface{}, key string) []interface{} {
+	list, ok := res[key].([]interface{})
+	if !ok {
+		t.Fatalf("%s is not an array: %v", key, res)
+	}
+
+	return list
+}
+
+//数组每个元素中的 key 对象的 id
+func getIDs(t *testing.T, list []interface{}, key string) []interface{} {
+	ids := make([]interface{}, len(list))
+	for i, item := range list {
+		obj, _ := item.(map[string]interface{})
+		ids[i] = getObject(t, obj, key)["id"]
+	}
+
+	return ids
+}
+
+func equalIDs(ids []interface{}, want ...float64) bool {
+	if len(ids) != len(want) {
+		return false
+	}
+
+	for i, id := range ids {
+		if id != want[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestArrayPagination(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		body string
+		key  string
+		want []float64
+	}{
+		{`{"[]": {"count": 2, "Moment": {"@order": "id+"}}}`, "Moment", []float64{12, 15}},
+		{`{"[]": {"count": 2, "page": 1, "Moment": {"@order": "id+"}}}`, "Moment", []float64{32, 58}},
+		{`{"[]": {"count": 2, "page": 2, "Moment": {"@order": "id+"}}}`, "Moment", []float64{170}},
+		{`{"[]": {"count": 2, "page": 3, "Moment": {"@order": "id+"}}}`, "Moment", nil},
+		//未指定 count 时为默认的 10 条
+		{`{"[]": {"Comment": {"@order": "id+"}}}`, "Comment", []float64{4, 13, 22, 44, 45, 47, 51, 54, 68, 76}},
+	}
+
+	for _, test := range tests {
+		res := mustParseSQLite(t, e, nil, MethodGet, test.body)
+		if ids := getIDs(t, getList(t, res, "[]"), test.key); !equalIDs(ids, test.want...) {
+			t.Errorf("%s: ids = %v, want %v", test.body, ids, test.want)
+		}
+	}
+
+	for _, body := range []string{
+		`{"[]": {"count": 101, "Moment": {}}}`,
+		`{"[]": {"count": -1, "Moment": {}}}`,
+		`{"[]": {"page": 1.5, "Moment": {}}}`,
+	} {
+		_, err := parseSQLite(t, e, nil, MethodGet, body)
+		if err == nil {
+			t.Errorf("%s: want error", body)
+		}
+	}
+
+	for _, body := range []string{
+		`{"[]": {"count": 101, "Moment": {}}}`,
+		`{"[]": {"page": 101, "Moment": {}}}`,
+		`{"[]": {"count": 100, "page": 2147483647, "Moment": {}}}`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, body); !errors.Is(err, ErrOutOfRange) {
+			t.Errorf("%s: error = %v, want out of range", body, err)
+		}
+	}
+
+	//页码不限制时偏移量超过 int32 同样报错
+	e = newSQLiteEngine(t, func(config *Config) {
+		config.Limits.MaxPage = math.MaxInt32
+	})
+
+	if _, err := parseSQLite(t, e, nil, MethodGet, `{"[]": {"count": 100, "page": 2147483647, "Moment": {}}}`); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("error = %v, want out of range", err)
+	}
+}
+
+func TestParseArrayParamsLimits(t *testing.T) {
+	tests := []struct {
+		body   string
+		limits Limits
+		count  int
+		err    error
+	}{
+		//Config 字面量未设置 Limits 时按默认值
+		{`{}`, Limits{}, 10, nil},
+		{`{"count": 100}`, Limits{}, 100, nil},
+		{`{"count": 101}`, Limits{}, 0, ErrOutOfRange},
+		{`{"page": 101}`, Limits{}, 0, ErrOutOfRange},
+		//默认条数不超过最大条数
+		{`{}`, Limits{MaxCount: 5}, 5, nil},
+		{`{"count": 3}`, Limits{DefaultCount: 2, MaxCount: 3, MaxPage: 1}, 3, nil},
+		{`{"page": 2}`, Limits{DefaultCount: 2, MaxCount: 3, MaxPage: 1}, 0, ErrOutOfRange},
+	}
+
+	for _, test := range tests {
+		v := orderedmap.New()
+		if err := json.Unmarshal([]byte(test.body), &v); err != nil {
+			t.Fatal(err)
+		}
+
+		node := &ParseTree{}
+		err := parseArrayParams(v, node, test.limits)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s %+v: error = %v, want %v", test.body, test.limits, err, test.err)
+			}
+
+			continue
+		}
+
+		if err != nil || node.SQLCount != test.count {
+			t.Errorf("%s %+v: count = %d, error = %v, want %d", test.body, test.limits, node.SQLCount, err, test.count)
+		}
+	}
+}
+
+func TestNestedArray(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		body     string
+		moments  []float64
+		comments [][]float64
+	}{
+		{
+			`{"[]": {"count": 2, "Moment": {"id{}": [12, 32]},
+				"Comment[]": {"count": 1, "Comment": {"momentId@": "[]/Moment/id"}}}}`,
+			[]float64{12, 32},
+			[][]float64{{97}, {}},
+		},
+		{
+			`{"[]": {"count": 3, "Moment": {"id{}": [12, 15]},
+				"Comment[]": {"count": 5, "Comment": {"momentId@": "[]/Moment/id", "@order": "id+"}}}}`,
+			[]float64{12, 15},
+			[][]float64{{97}, {68, 77}},
+		},
+		{
+			`{"[]": {"count": 2, "page": 1, "Moment": {"@order": "id+"},
+				"Comment[]": {"count": 5, "Comment": {"momentId@": "[]/Moment/id", "@order": "id+"}}}}`,
+			[]float64{32, 58},
+			[][]float64{{}, {13, 76}},
+		},
+		{
+			//每个父数组元素各自分页
+			`{"[]": {"count": 3, "Moment": {"id{}": [15, 58, 170], "@order": "id+"},
+				"Comment[]": {"count": 1, "page": 1, "Comment": {"momentId@": "[]/Moment/id", "@order": "id+"}}}}`,
+			[]float64{15, 58, 170},
+			[][]float64{{77}, {76}, {54}},
+		},
+	}
+
+	for _, test := range tests {
+		res := mustParseSQLite(t, e, nil, MethodGet, test.body)
+
+		items := getList(t, res, "[]")
+		if ids := getIDs(t, items, "Moment"); !equalIDs(ids, test.moments...) {
+			t.Fatalf("%s: Moment ids = %v, want %v", test.body, ids, test.moments)
+		}
+
+		for i, item := range items {
+			obj, _ := item.(map[string]interface{})
+			if ids := getIDs(t, getList(t, obj, "Comment[]"), "Comment"); !equalIDs(ids, test.comments[i]...) {
+				t.Errorf("%s: Comment ids of Moment %v = %v, want %v", test.body, test.moments[i], ids, test.comments[i])
+			}
+		}
+	}
+}
+
+func TestArrayTotal(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{
+		"[]": {"count": 2, "query": 2, "Moment": {"userId": 70793}},
+		"total@": "/[]/total"
+	}`)
+
+	if total := res["total"]; total != float64(3) {
+		t.Errorf("total = %v, want 3", total)
+	}
+
+	if items := getList(t, res, "[]"); len(items) != 2 {
+		t.Errorf("[] = %v, want 2 items", items)
+	}
+
+	//只查询总条数，不返回数组
+	res = mustParseSQLite(t, e, nil, MethodGet, `{
+		"[]": {"query": 1, "Comment": {"momentId": 470}},
+		"total@": "/[]/total"
+	}`)
+
+	if _, ok := res["[]"]; ok || res["total"] != float64(3) {
+		t.Errorf("res = %v, want only total 3", res)
+	}
+
+	_, err := parseSQLite(t, e, nil, MethodGet, `{"[]": {"Moment": {}}, "total@": "/[]/total"}`)
+	if err == nil {
+		t.Error("total without query should fail")
+	}
+}
+
+func TestNestedArrayTotal(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//评论 13 的用户不存在，Moment[] 的引用为空，没有查询，总条数为 0
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {
+		"Comment": {"id{}": [13, 44, 47], "@order": "id+"},
+		"apijson_user": {"id@": "/Comment/userId", "@column": "id"},
+		"Moment[]": {"count": 1, "query": 2, "Moment": {"userId@": "[]/apijson_user/id"}},
+		"total@": "/Moment[]/total"
+	}}`)
+
+	items := getList(t, res, "[]")
+	if ids := getIDs(t, items, "Comment"); !equalIDs(ids, 13, 44, 47) {
+		t.Fatalf("Comment ids = %v", ids)
+	}
+
+	totals := []float64{0, 0, 3}
+	sizes := []int{0, 0, 1}
+	for i, item := range items {
+		obj, _ := item.(map[string]interface{})
+		if obj["total"] != totals[i] {
+			t.Errorf("total of Comment %v = %v, want %v", getObject(t, obj, "Comment")["id"], obj["total"], totals[i])
+		}
+
+		if moments := getList(t, obj, "Moment[]"); len(moments) != sizes[i] {
+			t.Errorf("Moment[] of Comment %v = %v, want %d", getObject(t, obj, "Comment")["id"], moments, sizes[i])
+		}
+	}
+}
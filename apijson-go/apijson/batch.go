@@ -0,0 +1,253 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//数组内非第一个节点的批量查询，如 "[]": {"Moment": {}, "apijson_user": {"id@": "/Moment/userId"}}，
+//收集所有元素的引用值，用一条 WHERE id IN (...) 代替每个元素一次的查询，再按引用值把结果分给各个元素
+
+//获取可以批量查询的引用字段，只有一个等值引用，且没有子查询、分组时才能批量
+func getBatchKey(where *orderedmap.OrderedMap) (column, associated string, ok bool) {
+	for _, key := range where.Keys() {
+		val, _ := where.Get(key)
+
+		switch key {
+		case KeyGroup, KeyHaving, KeyCombine:
+			return "", "", false
+		}
+
+		if isSubquery(key, val) {
+			return "", "", false
+		}
+
+		isRef, newKey := isAssociated(key)
+		if !isRef {
+			continue
+		}
+
+		str, isString := val.(string)
+		if !isString || column != "" {
+			return "", "", false
+		}
+
+		//只支持等值引用，如 "id@"，不支持 "id{}@"、"id>@" 等
+		if col, operator, _, _ := pregOperatorMatch(newKey); operator != "" || col != newKey ||
+			!identifierRegexp.MatchString(newKey) {
+			return "", "", false
+		}
+
+		column, associated = newKey, str
+	}
+
+	return column, associated, column != ""
+}
+
+//结果中必须有引用字段才能按引用值分配，@column 未包含该字段时不批量
+func hasBatchColumn(where *orderedmap.OrderedMap, column string, functions map[string]bool) bool {
+	tmp, ok := where.Get(KeyColumn)
+	if !ok {
+		return true
+	}
+
+	str, _ := tmp.(string)
+	columns, err := ParseColumns(str, functions)
+	if err != nil {
+		return false
+	}
+
+	for _, c := range columns {
+		if c.Expr == column && c.Name() == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+//引用值作为分组的 key，查询结果和引用值的类型可能不同，如 int64 的 1000000 和 float64 的 1e+06，
+//数字转为最简分数，fold 为 true 时字符串转为小写，用于不区分大小写的排序规则
+func batchValueKey(val interface{}, fold bool) string {
+	val = indirectValue(val)
+
+	switch v := val.(type) {
+	case int64, uint64, int, float64, json.Number:
+		if r, ok := new(big.Rat).SetString(fmt.Sprint(v)); ok {
+			return r.RatString()
+		}
+	case string:
+		if fold {
+			return strings.ToLower(v)
+		}
+	}
+
+	return fmt.Sprint(val)
+}
+
+//引用值按引用字段的编解码转为查询参数的类型，如定点数的字符串，没有表结构或转换失败时不转换
+func (p *Parser) encodeBatchValue(table, column string, val interface{}) interface{} {
+	val = indirectValue(val)
+
+	c := p.Schema.Table(table).Column(column)
+	if c == nil || c.Codec.Encode == nil || val == nil {
+		return val
+	}
+
+	v, err := c.Codec.Encode(val, p.config().Time)
+	if err != nil {
+		return val
+	}
+
+	//定点数的参数是字符串，按数字比较
+	if str, ok := v.(string); ok && c.Codec.Kind == DecimalCodec.Kind {
+		return json.Number(str)
+	}
+
+	return v
+}
+
+//引用字段必须是唯一的，如主键 id，否则一个引用值对应多行，IN 查询会读出所有匹配的行，
+//没有表结构时按 APIJSON 的约定只有 id 是唯一的
+func (p *Parser) isUniqueColumn(table, column string) bool {
+	if p.Schema == nil {
+		return column == "id"
+	}
+
+	c := p.Schema.Table(table).Column(column)
+	return c != nil && c.Unique
+}
+
+//批量查询数组内每个元素的对象，不能批量时 ok 为 false，由调用方逐个查询
+func (p *Parser) findBatch(ctx context.Context, table string, where *orderedmap.OrderedMap,
+	head, node *ParseTree) (ds []map[string]map[string]interface{}, ok bool, err error) {
+	column, associated, ok := getBatchKey(where)
+	if !ok || !hasBatchColumn(where, column, p.config().ColumnFunctions) || !p.isUniqueColumn(table, column) {
+		return nil, false, nil
+	}
+
+	//批量查询用 "key{}" 作为条件，已有时不批量
+	if _, exist := where.Get(column + "{}"); exist {
+		return nil, false, nil
+	}
+
+	size := elementSize(node)
+
+	//每个元素的引用值，引用的对象不存在时该元素结果为 nil，与 findOne 一致
+	values := make([]interface{}, size)
+	exists := make([]bool, size)
+	var inValues []interface{}
+	seen := map[string]bool{}
+
+	for i := 0; i < size; i++ {
+		val, err := associatedAssignment(associated, i, head, node)
+		if errors.Is(err, ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		//空值的条件不是 IN，逐个查询
+		if indirectValue(val) == nil {
+			return nil, false, nil
+		}
+
+		values[i] = val
+		exists[i] = true
+
+		if key := batchValueKey(p.encodeBatchValue(table, column, val), false); !seen[key] {
+			seen[key] = true
+			inValues = append(inValues, val)
+		}
+	}
+
+	ds = make([]map[string]map[string]interface{}, size)
+	if len(inValues) == 0 {
+		return ds, true, nil
+	}
+
+	where, access, err := p.verifyAccess(ctx, table, where)
+	if err != nil {
+		return nil, false, err
+	}
+
+	batchWhere := orderedmap.New()
+	for _, key := range where.Keys() {
+		if key == column+"@" {
+			continue
+		}
+
+		val, _ := where.Get(key)
+		batchWhere.Set(key, val)
+	}
+
+	batchWhere.Set(column+"{}", inValues)
+
+	statement, err := p.genStatement(ctx, table, batchWhere, access, 0, head, node)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if statement == nil {
+		return nil, false, nil
+	}
+
+	//字段唯一，每个引用值最多一行
+	statement.Limit(int32(len(inValues)))
+
+	rows, err := p.DB.FindAllMaps(ctx, statement)
+	if err != nil {
+		return nil, false, err
+	}
+
+	//每个引用值一行，与 findOne 的 LIMIT 1 一致，字符串大小写不同时再按小写匹配
+	rowMap := make(map[string]map[string]interface{}, len(inValues))
+	foldMap := make(map[string]map[string]interface{}, len(inValues))
+	for _, row := range rows {
+		if key := batchValueKey(row[column], false); rowMap[key] == nil {
+			rowMap[key] = row
+		}
+
+		if key := batchValueKey(row[column], true); foldMap[key] == nil {
+			foldMap[key] = row
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		if !exists[i] {
+			continue
+		}
+
+		val := p.encodeBatchValue(table, column, values[i])
+		row, ok := rowMap[batchValueKey(val, false)]
+		if !ok {
+			row, ok = foldMap[batchValueKey(val, true)]
+		}
+
+		var d map[string]interface{}
+		if ok {
+			//多个元素引用同一行时各自复制一份，远程函数的结果可能不同
+			d = make(map[string]interface{}, len(row))
+			for k, v := range row {
+				d[k] = v
+			}
+		}
+
+		err = p.callFunctions(ctx, where, d, i, head, node)
+		if err != nil {
+			return nil, false, err
+		}
+
+		ds[i] = map[string]map[string]interface{}{table: d}
+	}
+
+	return ds, true, nil
+}
@@ -0,0 +1,142 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestSchemaUnique(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	s, err := e.Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		table, column string
+		unique        bool
+	}{
+		{"Moment", "id", true},
+		{"Moment", "userId", false},
+		{"Access", "name", true},
+		{"Comment", "momentId", false},
+	}
+
+	for _, test := range tests {
+		if c := s.Table(test.table).Column(test.column); c == nil || c.Unique != test.unique {
+			t.Errorf("%s.%s = %+v, want unique %v", test.table, test.column, c, test.unique)
+		}
+	}
+
+	p := &Parser{Schema: s}
+	if !p.isUniqueColumn("apijson_user", "id") || p.isUniqueColumn("Comment", "momentId") {
+		t.Error("only unique columns can be batched")
+	}
+
+	//没有表结构时只有 id
+	p.Schema = nil
+	if !p.isUniqueColumn("Comment", "id") || p.isUniqueColumn("Comment", "momentId") {
+		t.Error("only id can be batched without schema")
+	}
+}
+
+func TestBatch(t *testing.T) {
+	for _, introspect := range []bool{true, false} {
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.Introspect = introspect
+		})
+
+		//唯一字段批量查询，多个元素引用同一个用户
+		res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"count": 5, "Moment": {"@order": "id+"},
+			"apijson_user": {"id@": "/Moment/userId", "@column": "id,name"}}}`)
+
+		items := getList(t, res, "[]")
+		if ids := getIDs(t, items, "apijson_user"); !equalIDs(ids, 70793, 70793, 82002, 38710, 70793) {
+			t.Errorf("introspect %v: apijson_user ids = %v", introspect, ids)
+		}
+
+		//不唯一的字段逐个查询，每个元素一条
+		res = mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"count": 5, "Moment": {"@order": "id+"},
+			"Comment": {"momentId@": "/Moment/id", "@order": "id-"}}}`)
+
+		items = getList(t, res, "[]")
+		want := []interface{}{float64(97), float64(77), nil, float64(76), float64(54)}
+		for i, item := range items {
+			obj, _ := item.(map[string]interface{})
+			comment, _ := obj["Comment"].(map[string]interface{})
+
+			var id interface{}
+			if comment != nil {
+				id = comment["id"]
+			}
+
+			if id != want[i] {
+				t.Errorf("introspect %v: Comment %d = %v, want id %v", introspect, i, obj["Comment"], want[i])
+			}
+		}
+	}
+}
+
+func TestBatchValueKey(t *testing.T) {
+	tests := []struct {
+		a, b interface{}
+		fold bool
+		same bool
+	}{
+		{int64(1000000), float64(1e+06), false, true},
+		{json.Number("12.50"), float64(12.5), false, true},
+		{uint64(7), int64(7), false, true},
+		{int64(7), "7", false, true},
+		{int64(7), int64(8), false, false},
+		{"Go", "go", false, false},
+		{"Go", "go", true, true},
+	}
+
+	for _, test := range tests {
+		if same := batchValueKey(test.a, test.fold) == batchValueKey(test.b, test.fold); same != test.same {
+			t.Errorf("%#v, %#v fold %v: same = %v, want %v", test.a, test.b, test.fold, same, test.same)
+		}
+	}
+}
+
+//引用值与查询结果的类型、大小写不同时也能分配到各个元素
+func TestBatchNormalize(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	for _, query := range []string{
+		`CREATE TABLE Tag (code TEXT PRIMARY KEY COLLATE NOCASE, price DECIMAL(10,2) UNIQUE, name TEXT)`,
+		`CREATE TABLE Item (id INTEGER PRIMARY KEY, tagCode TEXT, price TEXT)`,
+		`INSERT INTO Tag VALUES ('go', 1000000, 'Go'), ('sql', 12.5, 'SQL')`,
+		`INSERT INTO Item VALUES (1, 'GO', '1e+06'), (2, 'sql', '12.50'), (3, 'Sql', '1000000.00')`,
+		`INSERT INTO Access (id, name) VALUES (10, 'Tag'), (11, 'Item')`,
+	} {
+		if _, err := e.DB().Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := e.RefreshSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		column string
+		want   []interface{}
+	}{
+		{"code@\": \"/Item/tagCode", []interface{}{"Go", "SQL", "SQL"}},
+		{"price@\": \"/Item/price", []interface{}{"Go", "SQL", "Go"}},
+	} {
+		res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"Item": {"@order": "id+"}, "Tag": {"`+test.column+`", "@column": "code,price,name"}}}`)
+
+		items := getList(t, res, "[]")
+		for i, item := range items {
+			obj, _ := item.(map[string]interface{})
+			tag, _ := obj["Tag"].(map[string]interface{})
+			if tag == nil || tag["name"] != test.want[i] {
+				t.Errorf("%s: Tag %d = %v, want %v", test.column, i, obj["Tag"], test.want[i])
+			}
+		}
+	}
+}
@@ -4,133 +4,80 @@ package apijson
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strings"
-	"time"
 )
 
 // Client mysql客户端连接
 type Client struct {
-	NameSrv string
-	Proxy   *sql.DB //可以换成任何支持 SQL 协议的引擎，如： postgres 、 mysql
-	Tx      *sql.Tx
+	NameSrv  string
+	Proxy    *sql.DB //可以换成任何支持 SQL 协议的引擎，如： postgres 、 mysql
+	Tx       *sql.Tx
+	Dialect  Dialect          //SQL 方言，与 Proxy 的驱动一致，为空时为 MySQL
+	KeepNull bool             //查询结果保留 NULL，输出为 JSON null，否则转为 ""、0、false
+	Time     TimeConfig       //时间的时区和输出格式
+	Codecs   map[string]Codec //替换方言默认的编解码，key 为小写并去掉长度的类型
 }
 
 type Next func(rows *sql.Rows) (err error)
 
-//NewOrmClient 创建 Client 指针
-var NewOrmClient = func(dataSourceName string) (*Client, error) {
-	db, err := sql.Open("mysql", dataSourceName)
-	if err != nil {
-		return nil, err
+//GetDialect 获取 SQL 方言，未设置时为 MySQL
+func (c *Client) GetDialect() Dialect {
+	if c == nil || c.Dialect == nil {
+		return MySQLDialect{}
 	}
 
-	ormClient := &Client{
-		NameSrv: dataSourceName,
-		Proxy:   db,
-		Tx:      nil,
+	return c.Dialect
+}
+
+//数据库类型的编解码，Codecs 中的优先，ok 为 false 时为未注册的类型
+func (c *Client) lookupCodec(dbType string) (Codec, bool) {
+	if codec, ok := c.Codecs[normalizeDBType(dbType)]; ok {
+		return codec, true
 	}
 
-	return ormClient, nil
+	return lookupCodec(c.GetDialect().Name(), dbType)
 }
 
-func nextRows(rows *sql.Rows) (map[string]interface{}, error) {
-	var row []interface{}
-	tmp := map[string]interface{}{}
+//scanPlan 查询结果的扫描计划，每个结果集按字段类型生成一次
+type scanPlan struct {
+	columns  []string
+	codecs   []Codec
+	keepNull bool
+	time     TimeConfig
+}
 
-	columns, e := rows.Columns()
-	if e != nil {
-		return nil, e
+//按结果集的字段类型生成扫描计划
+func (c *Client) newScanPlan(rows *sql.Rows) (*scanPlan, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
 	}
 
-	columnTypes, e := rows.ColumnTypes()
-	if e != nil {
-		return nil, e
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
 	}
 
-	columnLen := len(columns)
-	for i := 0; i < columnLen; i++ {
-		columnType := columnTypes[i]
-		dbType := strings.ToLower(columnType.DatabaseTypeName())
-		goType, ok := typeForMysqlToGo[dbType]
-		if !ok { //默认转化为 string
-			goType = "string"
+	codecs := make([]Codec, len(columnTypes))
+	for i, columnType := range columnTypes {
+		codec, ok := c.lookupCodec(columnType.DatabaseTypeName())
+		if !ok {
+			codec = driverCodec
 		}
 
-		if nullable, _ := columnType.Nullable(); nullable { //空值
-			goType, ok = nullTypeForMysqlToGo[dbType]
-			if !ok {
-				goType = "NullString"
-			}
-		}
+		codecs[i] = codec
+	}
 
-		switch goType {
-		case "bool":
-			var val bool
-			row = append(row, &val)
-		case "int":
-			var val int
-			row = append(row, &val)
-		case "int8":
-			var val int8
-			row = append(row, &val)
-		case "int16":
-			var val int16
-			row = append(row, &val)
-		case "int32":
-			var val int32
-			row = append(row, &val)
-		case "int64":
-			var val int64
-			row = append(row, &val)
-		case "uint":
-			var val uint
-			row = append(row, &val)
-		case "uint8":
-			var val uint8
-			row = append(row, &val)
-		case "uint16":
-			var val uint16
-			row = append(row, &val)
-		case "uint32":
-			var val uint32
-			row = append(row, &val)
-		case "uint64":
-			var val uint64
-			row = append(row, &val)
-		case "float32":
-			var val float32
-			row = append(row, &val)
-		case "float64":
-			var val float64
-			row = append(row, &val)
-		case "string":
-			var val string
-			row = append(row, &val)
-		case "time.Time":
-			var val time.Time
-			row = append(row, &val)
-		case "[]byte":
-			var val []byte
-			row = append(row, &val)
-		case "NullBool":
-			var val NullBool
-			row = append(row, &val)
-		case "NullInt":
-			var val NullInt
-			row = append(row, &val)
-		case "NullFloat":
-			var val NullFloat
-			row = append(row, &val)
-		case "NullString":
-			var val NullString
-			row = append(row, &val)
-		case "NullTime":
-			var val NullTime
-			row = append(row, &val)
-		default:
-			var val interface{}
-			row = append(row, &val)
-		}
+	return &scanPlan{columns: columns, codecs: codecs, keepNull: c.KeepNull, time: c.Time}, nil
+}
+
+//扫描当前行，驱动返回的值由字段的 Codec 转为输出的值，NULL 为 nil 或 Codec.Zero
+func (plan *scanPlan) next(rows *sql.Rows) (map[string]interface{}, error) {
+	values := make([]interface{}, len(plan.columns))
+	row := make([]interface{}, len(plan.columns))
+	for i := range values {
+		row[i] = &values[i]
 	}
 
 	err := rows.Scan(row...)
@@ -138,12 +85,24 @@ func nextRows(rows *sql.Rows) (map[string]interface{}, error) {
 		return nil, err
 	}
 
-	for k, column := range columns {
-		if row == nil {
+	tmp := make(map[string]interface{}, len(plan.columns))
+	for i, column := range plan.columns {
+		codec := plan.codecs[i]
+
+		if values[i] == nil {
+			if !plan.keepNull {
+				tmp[column] = codec.Zero
+			} else {
+				tmp[column] = nil
+			}
+
 			continue
 		}
 
-		tmp[column] = row[k]
+		tmp[column], err = codec.Decode(values[i], plan.time)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", column, err)
+		}
 	}
 
 	return tmp, nil
@@ -153,8 +112,15 @@ func nextRows(rows *sql.Rows) (map[string]interface{}, error) {
 //ctx
 //statement 组装的条件
 func (c *Client) FindAllMaps(ctx context.Context, statement *Statement) (dest []map[string]interface{}, err error) {
+	var plan *scanPlan
 	next := func(rows *sql.Rows) (err error) {
-		tmp, err := nextRows(rows)
+		if plan == nil {
+			if plan, err = c.newScanPlan(rows); err != nil {
+				return err
+			}
+		}
+
+		tmp, err := plan.next(rows)
 		if err != nil {
 			return err
 		}
@@ -163,13 +129,14 @@ func (c *Client) FindAllMaps(ctx context.Context, statement *Statement) (dest []
 		return nil
 	}
 
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateFindSQL(statement)
 
 	if err != nil {
 		return
 	}
 
-	err = c.realQuery(ctx, next, query, statement.params...)
+	err = c.realQuery(ctx, next, query, statement.GetParams()...)
 	return
 }
 
@@ -178,29 +145,56 @@ func (c *Client) FindAllMaps(ctx context.Context, statement *Statement) (dest []
 //statement 组装的条件
 func (c *Client) FindOneMap(ctx context.Context, statement *Statement) (dest map[string]interface{}, err error) {
 	next := func(rows *sql.Rows) error {
-		dest, err = nextRows(rows)
+		plan, err := c.newScanPlan(rows)
 		if err != nil {
 			return err
 		}
 
-		return nil
+		dest, err = plan.next(rows)
+		return err
 	}
 
 	statement.limit = 1
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateFindSQL(statement)
 	if err != nil {
 		return
 	}
 
-	err = c.realQuery(ctx, next, query, statement.params...)
+	err = c.realQuery(ctx, next, query, statement.GetParams()...)
 	return
 }
 
+//Columns 获取表的所有字段名
+func (c *Client) Columns(ctx context.Context, table string) ([]string, error) {
+	dialect := c.GetDialect()
+	query := convertSQL(dialect, fmt.Sprint("SELECT * FROM `", table, "`", dialect.Limit(0, -1)))
+
+	var rows *sql.Rows
+	var err error
+
+	if c.Tx != nil {
+		rows, err = c.Tx.QueryContext(ctx, query)
+	} else {
+		rows, err = c.Proxy.QueryContext(ctx, query)
+	}
+
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	return columns, dbError(err)
+}
+
 //Count 统计
 //ctx
 //statement 组装的条件
 //count 统计个数
 func (c *Client) Count(ctx context.Context, statement *Statement) (uint64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateCountSQL(statement)
 	var count uint64
 	next := func(rows *sql.Rows) error {
@@ -214,28 +208,30 @@ func (c *Client) Count(ctx context.Context, statement *Statement) (uint64, error
 		return count, err
 	}
 
-	err = c.realQuery(ctx, next, query, statement.params...)
+	err = c.realQuery(ctx, next, query, statement.GetParams()...)
 	return count, err
 }
 
 //Insert 返回 LastInsertId 和 error
 func (c *Client) Insert(ctx context.Context, statement *Statement) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateInsertSQL(statement)
 
 	if err != nil {
 		return 0, err
 	}
 
-	return c.Exec(ctx, query, statement.params...)
+	return c.insert(ctx, query, statement.GetParams()...)
 }
 
 //InsertIgnore 忽略主键冲突插入，返回 LastInsertId 和 error ，
 func (c *Client) InsertIgnore(ctx context.Context, statement *Statement) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateInsertIgnoreSQL(statement)
 	if err != nil {
 		return 0, err
 	}
-	return c.Exec(ctx, query, statement.params...)
+	return c.insert(ctx, query, statement.GetParams()...)
 }
 
 //InsertOnDuplicateKeyUpdate insert into on duplicate key update， 表示插入更新数据，当记录中有PrimaryKey，
@@ -243,40 +239,59 @@ func (c *Client) InsertIgnore(ctx context.Context, statement *Statement) (int64,
 //updateKeys 为需要更新的字段
 func (c *Client) InsertOnDuplicateKeyUpdate(ctx context.Context,
 	statement *Statement, updateKeys map[string]string) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateInsertOnDuplicateKeyUpdateSQL(statement, updateKeys)
 	if err != nil {
 		return 0, err
 	}
-	return c.Exec(ctx, query, statement.params...)
+	return c.insert(ctx, query, statement.GetParams()...)
 }
 
 //Replace 替换replace
 func (c *Client) Replace(ctx context.Context, statement *Statement, _ ...bool) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateReplaceSQL(statement)
 
 	if err != nil {
 		return 0, err
 	}
-	return c.Exec(ctx, query, statement.params...)
+	return c.insert(ctx, query, statement.GetParams()...)
+}
+
+//执行插入语句，方言有 RETURNING 子句时返回查询到的主键，否则为 LastInsertId，冲突被忽略时为 0
+func (c *Client) insert(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	if c.GetDialect().ReturnID() == "" {
+		return c.Exec(ctx, query, args...)
+	}
+
+	var id int64
+	next := func(rows *sql.Rows) error {
+		return rows.Scan(&id)
+	}
+
+	err := c.realQuery(ctx, next, query, args...)
+	return id, err
 }
 
 //Update 返回更新条数
 func (c *Client) Update(ctx context.Context, statement *Statement) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateUpdateSQL(statement)
 	if err != nil {
 		return 0, err
 	}
-	return c.Exec(ctx, query, statement.params...)
+	return c.Exec(ctx, query, statement.GetParams()...)
 }
 
 //Delete DELETE删除，返回删除条数
 func (c *Client) Delete(ctx context.Context, statement *Statement) (int64, error) {
+	statement.SetDialect(c.GetDialect())
 	query, err := CreateDeleteSQL(statement)
 	if err != nil {
 		return 0, err
 	}
 
-	return c.Exec(ctx, query, statement.params...)
+	return c.Exec(ctx, query, statement.GetParams()...)
 }
 
 //Exec 原生操作支持，支持自定义sql语句，比如delete，update,insert,replace
@@ -288,31 +303,41 @@ func (c *Client) Exec(ctx context.Context, query string, args ...interface{}) (i
 	var err error
 
 	if c.Tx != nil {
-		ret, err = c.Tx.Exec(query, args...)
+		ret, err = c.Tx.ExecContext(ctx, query, args...)
 	} else {
 		ret, err = c.Proxy.ExecContext(ctx, query, args...)
 	}
 
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	query = strings.TrimSpace(query)
 	prefix := strings.ToLower(query[:6])
 
+	var n int64
 	if prefix == "update" || prefix == "delete" {
-		return ret.RowsAffected()
+		n, err = ret.RowsAffected()
+	} else {
+		n, err = ret.LastInsertId()
 	}
 
-	return ret.LastInsertId()
+	return n, dbError(err)
 }
 
 //Query 原生 Query ，执行mysql select命令
 func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (ret []map[string]interface{}, err error) {
 	ret = []map[string]interface{}{}
 
+	var plan *scanPlan
 	next := func(rows *sql.Rows) (err error) {
-		tmp, err := nextRows(rows)
+		if plan == nil {
+			if plan, err = c.newScanPlan(rows); err != nil {
+				return err
+			}
+		}
+
+		tmp, err := plan.next(rows)
 		if err != nil {
 			return err
 		}
@@ -332,25 +357,26 @@ func (c *Client) Query(ctx context.Context, query string, args ...interface{}) (
 
 func (c *Client) realQuery(ctx context.Context, next Next,
 	query string, args ...interface{}) (err error) {
+	//数据库返回的错误视为服务器内部错误
+	defer func() {
+		err = dbError(err)
+	}()
+
 	var rows *sql.Rows
 
 	if c.Tx != nil {
-		rows, err = c.Tx.Query(query, args...)
-		if err != nil {
-			return err
-		}
-
-		if rows.Err() != nil {
-			return rows.Err()
-		}
-
+		rows, err = c.Tx.QueryContext(ctx, query, args...)
 	} else {
 		rows, err = c.Proxy.QueryContext(ctx, query, args...)
-		if err != nil {
-			return err
-		}
 	}
 
+	if err != nil {
+		return err
+	}
+
+	//事务中未关闭的 rows 会占住连接，出错提前返回时也要关闭
+	defer rows.Close()
+
 	for rows.Next() {
 		err = next(rows)
 		if err != nil {
@@ -358,9 +384,38 @@ func (c *Client) realQuery(ctx context.Context, next Next,
 		}
 	}
 
-	if rows != nil {
-		return rows.Close()
+	return rows.Err()
+}
+
+//Begin 开启事务，返回在事务中执行的 Client，opts 为 nil 时使用数据库默认的隔离级别
+func (c *Client) Begin(ctx context.Context, opts *sql.TxOptions) (*Client, error) {
+	if c.Tx != nil {
+		return nil, fmt.Errorf("transaction is already started")
 	}
 
-	return
+	tx, err := c.Proxy.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	return &Client{NameSrv: c.NameSrv, Proxy: c.Proxy, Tx: tx, Dialect: c.Dialect,
+		KeepNull: c.KeepNull, Time: c.Time, Codecs: c.Codecs}, nil
+}
+
+//Commit 提交事务
+func (c *Client) Commit() error {
+	if c.Tx == nil {
+		return fmt.Errorf("transaction is not started")
+	}
+
+	return dbError(c.Tx.Commit())
+}
+
+//Rollback 回滚事务，已提交或已回滚时返回 sql.ErrTxDone
+func (c *Client) Rollback() error {
+	if c.Tx == nil {
+		return fmt.Errorf("transaction is not started")
+	}
+
+	return c.Tx.Rollback()
 }
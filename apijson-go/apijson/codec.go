@@ -0,0 +1,492 @@
+package apijson
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Codec 数据库类型的编解码，查询结果先扫描为驱动返回的值，再由 Decode 转为输出 JSON 的值
+type Codec struct {
+	Kind   string                                                      //值的分类，如 int、float、bool、string、binary、json、date、time、datetime
+	Zero   interface{}                                                 //KeepNull 关闭时 NULL 输出的值
+	Decode func(value interface{}, tc TimeConfig) (interface{}, error) //驱动返回的值转为输出 JSON 的值，value 不为 nil，如 int64、[]byte、time.Time
+	Encode func(value interface{}, tc TimeConfig) (interface{}, error) //请求中的 JSON 值转为查询参数，用于条件和新增、修改的值，为空时不转换
+}
+
+//常用的编解码，可以在 Config.Codecs 中用于其它数据库类型
+var (
+	IntCodec      = Codec{Kind: "int", Zero: int64(0), Decode: decodeInt, Encode: encodeInt}
+	UintCodec     = Codec{Kind: "int", Zero: uint64(0), Decode: decodeUint, Encode: encodeInt}
+	BitCodec      = Codec{Kind: "int", Zero: uint64(0), Decode: decodeBit, Encode: encodeInt}
+	FloatCodec    = Codec{Kind: "float", Zero: float64(0), Decode: decodeFloat, Encode: encodeFloat}
+	DecimalCodec  = Codec{Kind: "decimal", Zero: json.Number("0"), Decode: decodeDecimal, Encode: encodeDecimal} //输出为 JSON 数字，不经过 float64，保留所有位数
+	BoolCodec     = Codec{Kind: "bool", Zero: false, Decode: decodeBool, Encode: encodeBool}
+	StringCodec   = Codec{Kind: "string", Zero: "", Decode: decodeString, Encode: encodeString}
+	BinaryCodec   = Codec{Kind: "binary", Zero: "", Decode: decodeBinary, Encode: encodeBinary}       //base64 编码
+	JSONCodec     = Codec{Kind: "json", Zero: "", Decode: decodeJSON}                                 //直接嵌入 JSON，不是合法的 JSON 时为字符串
+	DateCodec     = Codec{Kind: "date", Zero: "", Decode: decodeDate, Encode: encodeDate}             //ISO 8601 日期，如 2006-01-02
+	TimeCodec     = Codec{Kind: "time", Zero: "", Decode: decodeTime}                                 //ISO 8601 时间，如 15:04:05
+	DateTimeCodec = Codec{Kind: "datetime", Zero: "", Decode: decodeDateTime, Encode: encodeDateTime} //按 TimeConfig 输出，默认为 RFC 3339，如 2006-01-02T15:04:05+08:00
+)
+
+//查询结果中未注册的类型，如 SQLite 中 count(*) 等表达式没有声明类型，按驱动返回的值输出
+var driverCodec = Codec{Kind: "driver", Zero: "", Decode: decodeDriver}
+
+//方言名称对应的数据库类型编解码，类型为小写并去掉长度，如 varchar、bigint unsigned，
+//只读，各引擎用 Config.Codecs 替换
+var codecs = map[string]map[string]Codec{
+	(MySQLDialect{}).Name(): {
+		"bool":               BoolCodec,
+		"boolean":            BoolCodec,
+		"int":                IntCodec,
+		"integer":            IntCodec,
+		"tinyint":            IntCodec,
+		"smallint":           IntCodec,
+		"mediumint":          IntCodec,
+		"bigint":             IntCodec,
+		"year":               IntCodec,
+		"unsigned int":       UintCodec,
+		"unsigned tinyint":   UintCodec,
+		"unsigned smallint":  UintCodec,
+		"unsigned mediumint": UintCodec,
+		"unsigned bigint":    UintCodec,
+		"int unsigned":       UintCodec,
+		"tinyint unsigned":   UintCodec,
+		"smallint unsigned":  UintCodec,
+		"mediumint unsigned": UintCodec,
+		"bigint unsigned":    UintCodec,
+		"bit":                BitCodec,
+		"float":              FloatCodec,
+		"double":             FloatCodec,
+		"decimal":            DecimalCodec,
+		"decimal unsigned":   DecimalCodec,
+		"enum":               StringCodec,
+		"set":                StringCodec,
+		"varchar":            StringCodec,
+		"char":               StringCodec,
+		"tinytext":           StringCodec,
+		"mediumtext":         StringCodec,
+		"text":               StringCodec,
+		"longtext":           StringCodec,
+		"blob":               BinaryCodec,
+		"tinyblob":           BinaryCodec,
+		"mediumblob":         BinaryCodec,
+		"longblob":           BinaryCodec,
+		"binary":             BinaryCodec,
+		"varbinary":          BinaryCodec,
+		"json":               JSONCodec,
+		"date":               DateCodec,
+		"time":               TimeCodec,
+		"datetime":           DateTimeCodec,
+		"timestamp":          DateTimeCodec,
+	},
+	(PostgresDialect{}).Name(): {
+		"bool":                        BoolCodec,
+		"boolean":                     BoolCodec,
+		"int2":                        IntCodec,
+		"int4":                        IntCodec,
+		"int8":                        IntCodec,
+		"smallint":                    IntCodec,
+		"integer":                     IntCodec,
+		"bigint":                      IntCodec,
+		"float4":                      FloatCodec,
+		"float8":                      FloatCodec,
+		"real":                        FloatCodec,
+		"double precision":            FloatCodec,
+		"numeric":                     DecimalCodec,
+		"decimal":                     DecimalCodec,
+		"varchar":                     StringCodec,
+		"bpchar":                      StringCodec,
+		"text":                        StringCodec,
+		"uuid":                        StringCodec,
+		"character varying":           StringCodec,
+		"character":                   StringCodec,
+		"bytea":                       BinaryCodec,
+		"json":                        JSONCodec,
+		"jsonb":                       JSONCodec,
+		"date":                        DateCodec,
+		"time":                        TimeCodec,
+		"timetz":                      TimeCodec,
+		"time without time zone":      TimeCodec,
+		"time with time zone":         TimeCodec,
+		"timestamp":                   DateTimeCodec,
+		"timestamptz":                 DateTimeCodec,
+		"timestamp without time zone": DateTimeCodec,
+		"timestamp with time zone":    DateTimeCodec,
+	},
+	//SQLite 的声明类型，按类型亲和性规则取常用的名称
+	(SQLiteDialect{}).Name(): {
+		"boolean":   BoolCodec,
+		"bool":      BoolCodec,
+		"int":       IntCodec,
+		"integer":   IntCodec,
+		"tinyint":   IntCodec,
+		"smallint":  IntCodec,
+		"mediumint": IntCodec,
+		"bigint":    IntCodec,
+		"int2":      IntCodec,
+		"int8":      IntCodec,
+		"real":      FloatCodec,
+		"float":     FloatCodec,
+		"double":    FloatCodec,
+		"numeric":   DecimalCodec,
+		"decimal":   DecimalCodec,
+		"varchar":   StringCodec,
+		"char":      StringCodec,
+		"nchar":     StringCodec,
+		"nvarchar":  StringCodec,
+		"text":      StringCodec,
+		"clob":      StringCodec,
+		"blob":      BinaryCodec,
+		"json":      JSONCodec,
+		"date":      DateCodec,
+		"time":      TimeCodec,
+		"datetime":  DateTimeCodec,
+		"timestamp": DateTimeCodec,
+	},
+}
+
+//GetCodec 获取方言中数据库类型的编解码，未注册的类型按字符串处理
+func GetCodec(dialect, dbType string) Codec {
+	codec, ok := lookupCodec(dialect, dbType)
+	if !ok {
+		return StringCodec
+	}
+
+	return codec
+}
+
+func lookupCodec(dialect, dbType string) (Codec, bool) {
+	codec, ok := codecs[dialect][normalizeDBType(dbType)]
+	return codec, ok
+}
+
+//数据库类型转为小写并去掉长度，如 VARCHAR(20) 转为 varchar，DECIMAL(10,2) UNSIGNED 转为 decimal unsigned
+func normalizeDBType(dbType string) string {
+	dbType = strings.ToLower(strings.TrimSpace(dbType))
+	if i := strings.Index(dbType, "("); i != -1 {
+		if j := strings.Index(dbType[i:], ")"); j != -1 {
+			dbType = dbType[:i] + dbType[i+j+1:]
+		} else {
+			dbType = dbType[:i]
+		}
+	}
+
+	return strings.Join(strings.Fields(dbType), " ")
+}
+
+func decodeInt(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	}
+
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.ParseInt(str, 10, 64)
+}
+
+func decodeUint(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		if v < 0 {
+			return nil, fmt.Errorf("%d is not an unsigned integer", v)
+		}
+
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	}
+
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.ParseUint(str, 10, 64)
+}
+
+//MySQL 的 bit 返回大端字节
+func decodeBit(value interface{}, tc TimeConfig) (interface{}, error) {
+	b, ok := value.([]byte)
+	if !ok {
+		return decodeUint(value, tc)
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+
+	return n, nil
+}
+
+func decodeFloat(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return strconv.ParseFloat(str, 64)
+}
+
+//MySQL、PostgreSQL 返回字符串，原样输出，SQLite 按亲和性返回 int64、float64
+func decodeDecimal(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(v, 'f', -1, 64)), nil
+	}
+
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDecimal(str)
+}
+
+func decodeBool(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	}
+
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err := strconv.ParseBool(str); err == nil {
+		return b, nil
+	}
+
+	return str == "yes" || str == "y", nil
+}
+
+//int64、float64、bool 原样输出，其它按字符串输出
+func decodeDriver(value interface{}, tc TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case int64, float64, bool:
+		return v, nil
+	}
+
+	return decodeString(value, tc)
+}
+
+func decodeString(value interface{}, tc TimeConfig) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return tc.format(tc.fromDB(t)), nil
+	}
+
+	return rowToString(value)
+}
+
+func decodeBinary(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	case string:
+		return base64.StdEncoding.EncodeToString([]byte(v)), nil
+	}
+
+	return nil, fmt.Errorf("binary value %T is not supported", value)
+}
+
+func decodeJSON(value interface{}, _ TimeConfig) (interface{}, error) {
+	str, err := rowToString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !json.Valid([]byte(str)) {
+		return str, nil
+	}
+
+	return json.RawMessage(str), nil
+}
+
+func decodeDate(value interface{}, _ TimeConfig) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.Format(dateLayout), nil
+	}
+
+	return rowToString(value)
+}
+
+func decodeTime(value interface{}, _ TimeConfig) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t.Format("15:04:05.999999999"), nil
+	}
+
+	return rowToString(value)
+}
+
+//驱动已解析为 time.Time 时按 TimeConfig 转换时区，如 MySQL 的 parseTime=true，字符串按数据库时区解析，无法解析时原样输出
+func decodeDateTime(value interface{}, tc TimeConfig) (interface{}, error) {
+	t, ok := value.(time.Time)
+	if !ok {
+		str, err := rowToString(value)
+		if err != nil {
+			return nil, err
+		}
+
+		t, err = tc.parseDB(str)
+		if err != nil {
+			return str, nil
+		}
+	}
+
+	return tc.format(tc.fromDB(t)), nil
+}
+
+func encodeInt(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		if v != math.Trunc(v) {
+			return nil, fmt.Errorf("%v is not an integer", v)
+		}
+
+		return int64(v), nil
+	case string:
+		return strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+
+		return int64(0), nil
+	}
+
+	return nil, fmt.Errorf("%T is not an integer", value)
+}
+
+func encodeFloat(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+
+	return nil, fmt.Errorf("%T is not a number", value)
+}
+
+//字符串原样作为参数，不转为 float64，超过 float64 精度的值要用字符串传，如 "12345678901234567.89"
+func encodeDecimal(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string, json.Number:
+		n, err := parseDecimal(fmt.Sprint(v))
+		return string(n), err
+	}
+
+	return nil, fmt.Errorf("%T is not a number", value)
+}
+
+//JSON 数字的格式，如 -12.50、1e+06
+var decimalRegexp = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+func parseDecimal(str string) (json.Number, error) {
+	str = strings.TrimSpace(str)
+	if !decimalRegexp.MatchString(str) {
+		return "", fmt.Errorf("%q is not a number", str)
+	}
+
+	return json.Number(str), nil
+}
+
+func encodeBool(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		if v != 0 && v != 1 {
+			return nil, fmt.Errorf("%v is not a boolean", v)
+		}
+
+		return v == 1, nil
+	case string:
+		return strconv.ParseBool(strings.TrimSpace(v))
+	}
+
+	return nil, fmt.Errorf("%T is not a boolean", value)
+}
+
+func encodeString(value interface{}, _ TimeConfig) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+
+	return nil, fmt.Errorf("%T is not a string", value)
+}
+
+//请求中的 base64 转为字节
+func encodeBinary(value interface{}, _ TimeConfig) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a base64 string", value)
+	}
+
+	return base64.StdEncoding.DecodeString(str)
+}
+
+func encodeDate(value interface{}, tc TimeConfig) (interface{}, error) {
+	if str, ok := value.(string); ok {
+		if _, err := time.Parse(dateLayout, str); err == nil {
+			return str, nil
+		}
+	}
+
+	t, err := tc.parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.Format(dateLayout), nil
+}
+
+//请求中的时间转为数据库时区不带时区的字符串，各数据库都可以和时间字段比较
+func encodeDateTime(value interface{}, tc TimeConfig) (interface{}, error) {
+	t, err := tc.parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return tc.toDB(t), nil
+}
@@ -0,0 +1,63 @@
+package apijson
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDecimalCodec(t *testing.T) {
+	//MySQL、PostgreSQL 返回字符串，超过 float64 精度的位数不丢失
+	for _, test := range []struct {
+		value interface{}
+		want  json.Number
+	}{
+		{[]byte("12345678901234567.89"), "12345678901234567.89"},
+		{"-0.10", "-0.10"},
+		{int64(1000000), "1000000"},
+		{float64(12.5), "12.5"},
+		{float64(1e+21), "1000000000000000000000"},
+	} {
+		got, err := decodeDecimal(test.value, TimeConfig{})
+		if err != nil || got != test.want {
+			t.Errorf("decode %#v = %#v, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		value interface{}
+		want  string
+	}{
+		{"12345678901234567.89", "12345678901234567.89"},
+		{" 1e+06", "1e+06"},
+		{json.Number("0.3"), "0.3"},
+		{float64(0.1), "0.1"},
+		{float64(1e+06), "1000000"},
+	} {
+		got, err := encodeDecimal(test.value, TimeConfig{})
+		if err != nil || got != test.want {
+			t.Errorf("encode %#v = %#v, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+
+	for _, value := range []interface{}{"abc", "1.", ".5", "0x10", "NaN", true} {
+		if _, err := encodeDecimal(value, TimeConfig{}); err == nil {
+			t.Errorf("encode %#v: want error", value)
+		}
+	}
+
+	if _, err := decodeDecimal([]byte("NaN"), TimeConfig{}); err == nil {
+		t.Error("decode NaN: want error")
+	}
+}
+
+func TestDecodeUint(t *testing.T) {
+	for _, value := range []interface{}{int64(-1), []byte("-1")} {
+		if got, err := decodeUint(value, TimeConfig{}); err == nil {
+			t.Errorf("decode %#v = %v, want error", value, got)
+		}
+	}
+
+	if got, err := decodeUint([]byte("18446744073709551615"), TimeConfig{}); err != nil || got != uint64(1<<64-1) {
+		t.Errorf("decode max = %v, %v", got, err)
+	}
+}
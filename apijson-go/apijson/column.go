@@ -0,0 +1,110 @@
+package apijson
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//KeyColumn 查询字段，如 "@column": "id,name:userName;count(*):total"
+const KeyColumn = "@column"
+
+//@column 默认允许的函数，只支持一个字段参数或 count(*)，只读，各引擎用 Config.ColumnFunctions 替换
+var columnFunctions = map[string]bool{
+	"COUNT":       true,
+	"SUM":         true,
+	"AVG":         true,
+	"MAX":         true,
+	"MIN":         true,
+	"JSON_LENGTH": true,
+	"LENGTH":      true,
+	"CHAR_LENGTH": true,
+	"UPPER":       true,
+	"LOWER":       true,
+	"ABS":         true,
+}
+
+//别名
+var aliasRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+//Column @column 解析后的查询字段
+type Column struct {
+	Expr  string //字段或函数，如 id、count(*)
+	Alias string //别名，没有则为空
+
+	functions map[string]bool //允许的函数
+}
+
+//ParseColumns 解析 @column，字段之间用 , 或 ; 分隔，"字段:别名" 指定别名，
+//函数必须在 functions 中，functions 为 nil 时为默认允许的函数
+func ParseColumns(column string, functions map[string]bool) ([]Column, error) {
+	if functions == nil {
+		functions = columnFunctions
+	}
+
+	var columns []Column
+
+	for _, item := range strings.FieldsFunc(column, func(r rune) bool { return r == ',' || r == ';' }) {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		c := Column{Expr: item, functions: functions}
+		if index := strings.LastIndex(item, ":"); index != -1 {
+			c.Expr = strings.TrimSpace(item[:index])
+			c.Alias = strings.TrimSpace(item[index+1:])
+
+			if !aliasRegexp.MatchString(c.Alias) {
+				return nil, fmt.Errorf("%s alias %s is invalid", KeyColumn, c.Alias)
+			}
+		}
+
+		_, err := functionQuote(KeyColumn, functions, c.Expr)
+		if err != nil {
+			return nil, err
+		}
+
+		columns = append(columns, c)
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("%s %s has no field", KeyColumn, column)
+	}
+
+	return columns, nil
+}
+
+//Name 查询结果中的字段名，有别名时为别名
+func (c Column) Name() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+
+	return c.Expr
+}
+
+//SQL 组装查询字段，table 不为空时字段加上表名前缀，alias 不为空时指定别名
+func (c Column) SQL(table, alias string) (string, error) {
+	expr := c.Expr
+	if table != "" {
+		expr = scopeAggregate(table, expr)
+	}
+
+	functions := c.functions
+	if functions == nil {
+		functions = columnFunctions
+	}
+
+	sql, err := functionQuote(KeyColumn, functions, expr)
+	if err != nil {
+		return "", err
+	}
+
+	sql = strings.TrimSpace(sql)
+	if alias != "" {
+		sql = fmt.Sprint(sql, " AS `", alias, "`")
+	}
+
+	return sql, nil
+}
@@ -0,0 +1,82 @@
+package apijson
+
+import "testing"
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns(" id, name:userName;count(*):total , upper(name) ,count(distinct userId):n", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		name string
+		sql  string
+	}{
+		{"id", "`id`"},
+		{"userName", "`name` AS `userName`"},
+		{"total", "COUNT(*) AS `total`"},
+		{"upper(name)", "UPPER( `name` )"},
+		{"n", "COUNT(DISTINCT `userId` ) AS `n`"},
+	}
+
+	if len(columns) != len(want) {
+		t.Fatalf("columns = %v", columns)
+	}
+
+	for i, c := range columns {
+		sql, err := c.SQL("", c.Alias)
+		if err != nil {
+			t.Errorf("%s: %v", c.Expr, err)
+			continue
+		}
+
+		if c.Name() != want[i].name || sql != want[i].sql {
+			t.Errorf("%d: %s %s, want %s %s", i, c.Name(), sql, want[i].name, want[i].sql)
+		}
+	}
+
+	//join 时加上表名前缀
+	if sql, err := columns[4].SQL("Comment", "Comment.n"); err != nil || sql != "COUNT(DISTINCT `Comment`.`userId` ) AS `Comment.n`" {
+		t.Errorf("scoped sql = %s, %v", sql, err)
+	}
+
+	for _, column := range []string{"", " , ", "id:", "id:user name", "name`", "sleep(1)", "concat(id,name)", "count(id) OR 1=1", "1"} {
+		if _, err := ParseColumns(column, nil); err == nil {
+			t.Errorf("%q: want error", column)
+		}
+	}
+
+	//只允许指定的函数
+	if _, err := ParseColumns("upper(name)", map[string]bool{"LOWER": true}); err == nil {
+		t.Error("upper should not be allowed")
+	}
+}
+
+func TestColumn(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"apijson_user": {"id": 70793, "@column": "id:userId,upper(name):upperName,length(name);name"}}`)
+	user := getObject(t, res, "apijson_user")
+	if len(user) != 4 || user["userId"] != float64(70793) || user["upperName"] != "STRONG" || user["length(name)"] != float64(6) || user["name"] != "Strong" {
+		t.Errorf("apijson_user = %v", user)
+	}
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"join": "&/apijson_user/id@",
+		"Moment": {"id": 32, "@column": "id:momentId"}, "apijson_user": {"id@": "/Moment/userId", "@column": "name:userName"}}}`)
+	items := getList(t, res, "[]")
+	if len(items) != 1 {
+		t.Fatalf("[] = %v", items)
+	}
+
+	item, _ := items[0].(map[string]interface{})
+	if moment, user := getObject(t, item, "Moment"), getObject(t, item, "apijson_user"); len(moment) != 1 || moment["momentId"] != float64(32) ||
+		len(user) != 1 || user["userName"] != "Happy~" {
+		t.Errorf("[] = %v", item)
+	}
+
+	for _, column := range []string{"id,nickname", "sleep(1)", "id:a b"} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, `{"apijson_user": {"id": 70793, "@column": "`+column+`"}}`); err == nil {
+			t.Errorf("%s: want error", column)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package apijson
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//条件组合逻辑
+const (
+	LogicAnd = "&" //与
+	LogicOr  = "|" //或
+	LogicNot = "!" //非
+)
+
+//KeyCombine 条件组合的 key，如 "@combine": "name~,tag~" 或 "@combine": "name~ | (tag~ & !id>)"
+const KeyCombine = "@combine"
+
+//Combine @combine 解析后的条件树，叶子节点为条件 key
+type Combine struct {
+	Logic    string     //LogicAnd、LogicOr、LogicNot，叶子节点为空
+	Key      string     //叶子节点的条件 key
+	Children []*Combine //子节点，LogicNot 只有一个
+}
+
+//ParseCombine 解析 @combine
+//旧格式 "&key0,&key1,|key2,key3,!key4,!key5"，| 可省略，同类合并后组合为 (key0 & key1) & (key2 | key3) & !(key4 | key5)
+//表达式格式 "key0 | (key1 & !key2)"，& 、| 前后要有空格，! 优先于 &，& 优先于 |，逗号等同于 |
+func ParseCombine(expr string) (*Combine, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return nil, fmt.Errorf("%s is empty", KeyCombine)
+	}
+
+	isExpression := strings.ContainsAny(expr, "()")
+	for _, field := range strings.Fields(expr) {
+		if field == LogicAnd || field == LogicOr || field == LogicNot {
+			isExpression = true
+		}
+	}
+
+	if !isExpression {
+		return parseCombineList(expr)
+	}
+
+	tokens := tokenizeCombine(expr)
+	parser := &combineParser{expr: expr, tokens: tokens}
+	combine, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if parser.pos < len(tokens) {
+		return nil, fmt.Errorf("%s %s is invalid: unexpected %s", KeyCombine, expr, tokens[parser.pos])
+	}
+
+	return combine, nil
+}
+
+//解析旧格式
+func parseCombineList(expr string) (*Combine, error) {
+	groups := map[string]*Combine{
+		LogicAnd: {Logic: LogicAnd},
+		LogicOr:  {Logic: LogicOr},
+		LogicNot: {Logic: LogicOr},
+	}
+
+	for _, item := range strings.Split(expr, ",") {
+		item = strings.TrimSpace(item)
+
+		logic := LogicOr
+		if item != "" {
+			switch item[:1] {
+			case LogicAnd, LogicOr, LogicNot:
+				logic = item[:1]
+				item = strings.TrimSpace(item[1:])
+			}
+		}
+
+		if item == "" {
+			return nil, fmt.Errorf("%s %s is invalid: empty key", KeyCombine, expr)
+		}
+
+		group := groups[logic]
+		group.Children = append(group.Children, &Combine{Key: item})
+	}
+
+	root := &Combine{Logic: LogicAnd}
+	root.Children = append(root.Children, groups[LogicAnd].Children...)
+
+	if or := groups[LogicOr]; len(or.Children) > 0 {
+		root.Children = append(root.Children, or.simplify())
+	}
+
+	if not := groups[LogicNot]; len(not.Children) > 0 {
+		root.Children = append(root.Children, &Combine{Logic: LogicNot, Children: []*Combine{not.simplify()}})
+	}
+
+	return root.simplify(), nil
+}
+
+//只有一个子节点的 & 、| 节点替换为子节点
+func (c *Combine) simplify() *Combine {
+	if (c.Logic == LogicAnd || c.Logic == LogicOr) && len(c.Children) == 1 {
+		return c.Children[0]
+	}
+
+	return c
+}
+
+//按空白、逗号和括号拆分，逗号转为 |，key 前的 ! 单独拆出
+func tokenizeCombine(expr string) []string {
+	var tokens []string
+	var word strings.Builder
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range expr {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ',':
+			flush()
+			tokens = append(tokens, LogicOr)
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			flush()
+		case r == '!' && word.Len() == 0:
+			tokens = append(tokens, LogicNot)
+		default:
+			word.WriteRune(r)
+		}
+	}
+
+	flush()
+	return tokens
+}
+
+//表达式格式的递归下降解析
+type combineParser struct {
+	expr   string
+	tokens []string
+	pos    int
+}
+
+func (p *combineParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+
+	return ""
+}
+
+func (p *combineParser) parseOr() (*Combine, error) {
+	return p.parseBinary(LogicOr, p.parseAnd)
+}
+
+func (p *combineParser) parseAnd() (*Combine, error) {
+	return p.parseBinary(LogicAnd, p.parseNot)
+}
+
+func (p *combineParser) parseBinary(logic string, next func() (*Combine, error)) (*Combine, error) {
+	first, err := next()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Combine{Logic: logic, Children: []*Combine{first}}
+	for p.peek() == logic {
+		p.pos++
+
+		child, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		node.Children = append(node.Children, child)
+	}
+
+	return node.simplify(), nil
+}
+
+func (p *combineParser) parseNot() (*Combine, error) {
+	switch token := p.peek(); token {
+	case LogicNot:
+		p.pos++
+
+		child, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+
+		return &Combine{Logic: LogicNot, Children: []*Combine{child}}, nil
+	case "(":
+		p.pos++
+
+		child, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("%s %s is invalid: missing )", KeyCombine, p.expr)
+		}
+
+		p.pos++
+		return child, nil
+	case "", ")", LogicAnd, LogicOr:
+		return nil, fmt.Errorf("%s %s is invalid: missing key", KeyCombine, p.expr)
+	default:
+		p.pos++
+		return &Combine{Key: token}, nil
+	}
+}
+
+//Keys 条件树中所有的 key
+func (c *Combine) Keys() []string {
+	if c.Logic == "" {
+		return []string{c.Key}
+	}
+
+	var keys []string
+	for _, child := range c.Children {
+		keys = append(keys, child.Keys()...)
+	}
+
+	return keys
+}
+
+//Map 对每个 key 做转换，返回新的条件树，如 join 时加上表名前缀
+func (c *Combine) Map(fn func(key string) string) *Combine {
+	if c.Logic == "" {
+		return &Combine{Key: fn(c.Key)}
+	}
+
+	children := make([]*Combine, len(c.Children))
+	for i, child := range c.Children {
+		children[i] = child.Map(fn)
+	}
+
+	return &Combine{Logic: c.Logic, Children: children}
+}
+
+//String 转为表达式格式
+func (c *Combine) String() string {
+	switch c.Logic {
+	case "":
+		return c.Key
+	case LogicNot:
+		return LogicNot + c.Children[0].wrap()
+	}
+
+	items := make([]string, len(c.Children))
+	for i, child := range c.Children {
+		items[i] = child.wrap()
+	}
+
+	return strings.Join(items, " "+c.Logic+" ")
+}
+
+func (c *Combine) wrap() string {
+	if c.Logic == LogicAnd || c.Logic == LogicOr {
+		return "(" + c.String() + ")"
+	}
+
+	return c.String()
+}
+
+//组装条件树的 SQL，参数按条件在 SQL 中的顺序加入 params
+func (c *Combine) condition(where *orderedmap.OrderedMap, params *[]interface{}) (string, error) {
+	switch c.Logic {
+	case "":
+		value, ok := where.Get(c.Key)
+		if !ok {
+			return "", fmt.Errorf("%s key %s is not in conditions", KeyCombine, c.Key)
+		}
+
+		if sub, isSub := value.(*Subquery); isSub {
+			cond, subParams, err := subqueryCondition(c.Key, sub)
+			if err != nil {
+				return "", err
+			}
+
+			*params = append(*params, subParams...)
+			return "(" + cond + ")", nil
+		}
+
+		if err := verifyConditionKey(c.Key); err != nil {
+			return "", err
+		}
+
+		var cond string
+		whereImplode(c.Key, value, &cond, params, "AND")
+
+		cond = strings.TrimSpace(cond)
+		if cond == "" {
+			return "", fmt.Errorf("%s key %s is invalid", KeyCombine, c.Key)
+		}
+
+		return "(" + cond + ")", nil
+	case LogicNot:
+		cond, err := c.Children[0].condition(where, params)
+		if err != nil {
+			return "", err
+		}
+
+		return "(NOT " + cond + ")", nil
+	}
+
+	connector := " AND "
+	if c.Logic == LogicOr {
+		connector = " OR "
+	}
+
+	conds := make([]string, len(c.Children))
+	for i, child := range c.Children {
+		cond, err := child.condition(where, params)
+		if err != nil {
+			return "", err
+		}
+
+		conds[i] = cond
+	}
+
+	return "(" + strings.Join(conds, connector) + ")", nil
+}
@@ -0,0 +1,107 @@
+package apijson
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestParseCombine(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"name~,tag~", "name~ | tag~"},
+		{"&id>, &userId, |name~, tag~, !id{}, !date>", "id> & userId & (name~ | tag~) & !(id{} | date>)"},
+		{"!id{}", "!id{}"},
+		{"name~ | (tag~ & !id>)", "name~ | (tag~ & !id>)"},
+		{"!name~ & tag~ | id>", "(!name~ & tag~) | id>"},
+		{"name~, tag~ & id>", "name~ | (tag~ & id>)"},
+		{"!(name~ | tag~)", "!(name~ | tag~)"},
+	}
+
+	for _, test := range tests {
+		combine, err := ParseCombine(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+
+		if s := combine.String(); s != test.want {
+			t.Errorf("%s: got %s, want %s", test.expr, s, test.want)
+		}
+	}
+
+	for _, expr := range []string{"", "name~,,tag~", "name~ |", "(name~ & tag~", "name~ & )", "name~ tag~ & id>"} {
+		if _, err := ParseCombine(expr); err == nil {
+			t.Errorf("%s: want error", expr)
+		}
+	}
+}
+
+func TestCombineAccessWhere(t *testing.T) {
+	where := orderedmap.New()
+	if err := json.Unmarshal([]byte(`{"id>": 0, "userId": 82001, "@combine": "userId | id>"}`), &where); err != nil {
+		t.Fatal(err)
+	}
+
+	access := orderedmap.New()
+	access.Set("userId", 82001)
+
+	//角色的条件在 @combine 之外 AND 连接
+	statement := NewDbStatement()
+	statement.SetTableName("Comment")
+	statement.Where(where)
+	statement.AccessWhere(access)
+
+	sql, err := CreateFindSQL(statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "SELECT * FROM `Comment` WHERE (((`userId` = ?) OR (`id` > ?))) AND (`userId` = ?)"
+	if sql != want {
+		t.Errorf("\n got: %s\nwant: %s", sql, want)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		where string
+		want  []float64
+	}{
+		{`"userId": 93793, "momentId": 15, "@combine": "userId | momentId"`, []float64{45, 68, 76, 77}},
+		{`"userId": 93793, "momentId": 15, "@combine": "userId,momentId"`, []float64{45, 68, 76, 77}},
+		{`"id>": 50, "userId": 82003, "@combine": "!userId & id>"`, []float64{54, 68, 76, 77, 97}},
+		//不在 @combine 中的条件 AND 连接
+		{`"momentId": 470, "userId": 38710, "toId": 4, "@combine": "userId | toId"`, []float64{4, 47}},
+		{`"momentId{}": [58, 15], "userId{}": [93793], "content$": "%-13", "@combine": "momentId{} & (userId{} | content$)"`, []float64{13, 76, 77}},
+	}
+
+	for _, test := range tests {
+		res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"count": 20, "Comment": {`+test.where+`, "@order": "id+", "@column": "id"}}}`)
+		if ids := getIDs(t, getList(t, res, "[]"), "Comment"); !equalIDs(ids, test.want...) {
+			t.Errorf("%s: ids = %v, want %v", test.where, ids, test.want)
+		}
+	}
+
+	//join 时条件加上表名前缀
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"join": "&/apijson_user/id@",
+		"Moment": {"userId": 82002, "id": 58, "@combine": "userId | id", "@order": "id+"}, "apijson_user": {"id@": "/Moment/userId"}}}`)
+	if ids := getIDs(t, getList(t, res, "[]"), "Moment"); !equalIDs(ids, 32, 58) {
+		t.Errorf("join ids = %v, want [32, 58]", ids)
+	}
+
+	for _, where := range []string{
+		`"userId": 93793, "@combine": "userId | momentId"`,
+		`"userId": 93793, "@combine": "userId |"`,
+		`"userId": 93793, "@combine": 1`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, `{"Comment": {`+where+`}}`); err == nil {
+			t.Errorf("%s: want error", where)
+		}
+	}
+}
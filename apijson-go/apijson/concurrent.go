@@ -0,0 +1,163 @@
+package apijson
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//最外层节点并发查询，如 {"Moment": {...}, "Comment[]": {...}}，
+//节点之间没有引用时并发，有引用时后面的节点等前面的节点完成，结果顺序与请求一致
+
+//是否并发查询，只有开放请求的最外层、不在事务中时才并发
+func (p *Parser) isConcurrent(node *ParseTree) bool {
+	return p.Concurrency > 1 && p.Method.IsPublic() && node.Parent == nil &&
+		(p.DB == nil || p.DB.Tx == nil)
+}
+
+//并发解析最外层节点，节点已按顺序创建，查询时只修改各自的节点，返回按请求顺序的第一个错误
+func (p *Parser) parseConcurrently(ctx context.Context, req *orderedmap.OrderedMap,
+	head *ParseTree, items []*parseItem) error {
+	refs := make([]map[string]bool, len(items))
+	for i, item := range items {
+		refs[i] = map[string]bool{}
+		val, _ := req.Get(item.key)
+		collectRefKeys(item.key, val, refs[i])
+	}
+
+	//前后两个节点任意一方引用了另一方时，后面的等待前面的完成，与顺序查询的结果一致
+	waits := make([][]int, len(items))
+	for i := range items {
+		for j := 0; j < i; j++ {
+			if refs[i][items[j].node.Key] || refs[j][items[i].node.Key] {
+				waits[i] = append(waits[i], j)
+			}
+		}
+	}
+
+	//Access 表在并发前加载，查询时只读
+	_, err := p.loadAccess(ctx)
+	if err != nil {
+		return err
+	}
+
+	sem := make(chan struct{}, p.Concurrency)
+	done := make([]chan struct{}, len(items))
+	errs := make([]error, len(items))
+	cancels := make([]context.CancelFunc, len(items))
+	ctxs := make([]context.Context, len(items))
+	for i := range items {
+		done[i] = make(chan struct{})
+		ctxs[i], cancels[i] = context.WithCancel(ctx)
+	}
+
+	//出错时取消后面的节点，前面的节点继续查询，保证返回的是按请求顺序的第一个错误，与顺序查询一致
+	var mu sync.Mutex
+	failed := len(items)
+	fail := func(i int, err error) {
+		errs[i] = errorWithPath(err, items[i].key)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if i < failed {
+			failed = i
+			for j := i + 1; j < len(items); j++ {
+				cancels[j]()
+			}
+		}
+	}
+
+	isSkipped := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed < i
+	}
+
+	var wg sync.WaitGroup
+	for i, item := range items {
+		wg.Add(1)
+
+		go func(i int, item *parseItem) {
+			defer wg.Done()
+			defer close(done[i])
+			defer cancels[i]()
+
+			for _, j := range waits[i] {
+				<-done[j]
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if isSkipped(i) {
+				return
+			}
+
+			defer func() {
+				if r := recover(); r != nil {
+					fail(i, &Error{Code: CodeServerError, Msg: fmt.Sprint(r)})
+				}
+			}()
+
+			err := p.parseKey(ctxs[i], req, item.kind, item.key, 0, head, item.node)
+			if err != nil {
+				fail(i, err)
+			}
+		}(i, item)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//收集 val 中引用的路径的第一段，如 "id@": "/Moment/userId" 中的 Moment，包括远程函数中的路径参数
+func collectRefKeys(key string, val interface{}, refs map[string]bool) {
+	switch v := val.(type) {
+	case string:
+		if isRef, _ := isAssociated(key); isRef {
+			addRefKey(v, refs)
+		} else if isFunctionKey(key) {
+			start := strings.Index(v, "(")
+			end := strings.LastIndex(v, ")")
+			if start == -1 || end < start {
+				return
+			}
+
+			for _, arg := range strings.Split(v[start+1:end], ",") {
+				if strings.Contains(arg, "/") {
+					addRefKey(strings.TrimSpace(arg), refs)
+				}
+			}
+		}
+	case orderedmap.OrderedMap:
+		for _, k := range v.Keys() {
+			child, _ := v.Get(k)
+			collectRefKeys(k, child, refs)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectRefKeys("", item, refs)
+		}
+	}
+}
+
+//路径的第一段
+func addRefKey(path string, refs map[string]bool) {
+	for _, segment := range strings.Split(path, "/") {
+		if segment != "" {
+			refs[segment] = true
+			return
+		}
+	}
+}
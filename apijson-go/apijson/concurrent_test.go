@@ -0,0 +1,141 @@
+package apijson
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestCollectRefKeys(t *testing.T) {
+	req := orderedmap.New()
+	err := json.Unmarshal([]byte(`{"id@": "/Moment/userId", "Comment": {"momentId@": "Moment/id", "f()": "isContain(list, /apijson_user/id)"},
+		"[]": [{"toId@": "Comment/id"}], "name": "User/name", "g()": "plus(id,userId)"}`), &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := map[string]bool{}
+	collectRefKeys("", *req, refs)
+
+	if len(refs) != 3 || !refs["Moment"] || !refs["apijson_user"] || !refs["Comment"] {
+		t.Errorf("refs = %v, want Moment, apijson_user, Comment", refs)
+	}
+}
+
+//记录同时执行的节点数，每个节点等其它节点进入或超时后返回
+type barrier struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+	wait     time.Duration
+}
+
+func (b *barrier) enter(_ context.Context, _ ...interface{}) (interface{}, error) {
+	b.mu.Lock()
+	b.inFlight++
+	if b.inFlight > b.max {
+		b.max = b.inFlight
+	}
+	b.mu.Unlock()
+
+	deadline := time.Now().Add(b.wait)
+	for time.Now().Before(deadline) {
+		b.mu.Lock()
+		n := b.inFlight
+		b.mu.Unlock()
+
+		if n > 1 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+
+	return true, nil
+}
+
+func TestConcurrentNodes(t *testing.T) {
+	independent := `{"Moment": {"id": 12, "f()": "enter(id)"}, "Comment": {"id": 4, "f()": "enter(id)"},
+		"apijson_user": {"id": 82001, "f()": "enter(id)"}, "[]": {"count": 1, "Moment": {"f()": "enter(id)"}}`
+
+	tests := []struct {
+		concurrency int
+		body        string
+		want        int
+	}{
+		{1, independent + `}`, 1},
+		{2, independent + `}`, 2},
+		{8, independent + `}`, 4},
+		//事务中顺序查询
+		{8, independent + `, "@transaction": true}`, 1},
+		//有引用的节点等被引用的节点完成
+		{8, `{"Moment": {"id": 12, "f()": "enter(id)"}, "apijson_user": {"id@": "Moment/userId", "f()": "enter(id)"}}`, 1},
+	}
+
+	for _, test := range tests {
+		b := &barrier{wait: 100 * time.Millisecond}
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.Concurrency = test.concurrency
+			config.Functions = map[string]*Function{"enter": {Args: []ArgType{ArgAny}, Call: b.enter}}
+		})
+
+		mustParseSQLite(t, e, nil, MethodGet, test.body)
+		if b.max != test.want {
+			t.Errorf("concurrency %d %s: max in flight = %d, want %d", test.concurrency, test.body, b.max, test.want)
+		}
+	}
+}
+
+func TestConcurrentResult(t *testing.T) {
+	body := `{"Moment": {"id": 12}, "apijson_user": {"id@": "Moment/userId", "@column": "id,name"},
+		"[]": {"count": 3, "Comment": {"@order": "id+"}, "apijson_user": {"id@": "/Comment/userId"}},
+		"Comment": {"momentId@": "Moment/id"}}`
+
+	var want []byte
+	for _, concurrency := range []int{1, 8} {
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.Concurrency = concurrency
+		})
+
+		out, err := e.Parse(context.Background(), MethodGet, []byte(body))
+		if err != nil {
+			t.Fatalf("concurrency %d: %v", concurrency, err)
+		}
+
+		//结果的 key 顺序与请求一致
+		if want == nil {
+			want = out
+		} else if !bytes.Equal(out, want) {
+			t.Errorf("concurrency %d:\n got: %s\nwant: %s", concurrency, out, want)
+		}
+	}
+
+	//多个节点出错时返回按请求顺序的第一个错误
+	errBody := `{"Moment": {"id": 12}, "Comment": {"idx": 1}, "apijson_user": {"namex": 1}, "[]": {"Moment": {"sleep()": "sleep(id)"}}}`
+	for _, concurrency := range []int{1, 8} {
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.Concurrency = concurrency
+		})
+
+		_, err := e.Parse(context.Background(), MethodGet, []byte(errBody))
+		if err == nil {
+			t.Fatalf("concurrency %d: want error", concurrency)
+		}
+
+		if !strings.HasPrefix(err.Error(), "Comment: ") {
+			t.Errorf("concurrency %d: error = %v, want Comment's error", concurrency, err)
+		}
+	}
+}
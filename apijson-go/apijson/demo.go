@@ -0,0 +1,115 @@
+package apijson
+
+import (
+	"context"
+	"database/sql"
+)
+
+//SQLiteDemoSchema APIJSON 示例的表结构和数据，包括 apijson_user、Moment、Comment 和权限表 Access、校验规则表 Request，
+//用于本地运行和测试，如 SQLite 内存数据库
+var SQLiteDemoSchema = []string{
+	`CREATE TABLE IF NOT EXISTS apijson_user (
+		id INTEGER PRIMARY KEY,
+		sex TINYINT NOT NULL DEFAULT 0,
+		name VARCHAR(20) NOT NULL,
+		tag VARCHAR(45),
+		head VARCHAR(300),
+		contactIdList VARCHAR(500),
+		pictureList VARCHAR(1000),
+		date DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS Moment (
+		id INTEGER PRIMARY KEY,
+		userId BIGINT NOT NULL,
+		date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		content VARCHAR(300),
+		praiseUserIdList VARCHAR(3000) NOT NULL DEFAULT '[]',
+		pictureList VARCHAR(3000)
+	)`,
+	`CREATE TABLE IF NOT EXISTS Comment (
+		id INTEGER PRIMARY KEY,
+		toId BIGINT NOT NULL DEFAULT 0,
+		userId BIGINT NOT NULL,
+		momentId BIGINT NOT NULL,
+		date DATETIME DEFAULT CURRENT_TIMESTAMP,
+		content VARCHAR(1000) NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS Access (
+		id INTEGER PRIMARY KEY,
+		debug TINYINT NOT NULL DEFAULT 0,
+		name VARCHAR(50) NOT NULL UNIQUE,
+		alias VARCHAR(20),
+		get VARCHAR(100) NOT NULL DEFAULT '["UNKNOWN", "LOGIN", "CONTACT", "CIRCLE", "OWNER", "ADMIN"]',
+		head VARCHAR(100) NOT NULL DEFAULT '["UNKNOWN", "LOGIN", "CONTACT", "CIRCLE", "OWNER", "ADMIN"]',
+		gets VARCHAR(100) NOT NULL DEFAULT '["LOGIN", "CONTACT", "CIRCLE", "OWNER", "ADMIN"]',
+		heads VARCHAR(100) NOT NULL DEFAULT '["LOGIN", "CONTACT", "CIRCLE", "OWNER", "ADMIN"]',
+		post VARCHAR(100) NOT NULL DEFAULT '["OWNER", "ADMIN"]',
+		put VARCHAR(100) NOT NULL DEFAULT '["OWNER", "ADMIN"]',
+		"delete" VARCHAR(100) NOT NULL DEFAULT '["OWNER", "ADMIN"]',
+		date DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS Request (
+		id INTEGER PRIMARY KEY,
+		version TINYINT NOT NULL DEFAULT 1,
+		method VARCHAR(10) DEFAULT 'GETS',
+		tag VARCHAR(20) NOT NULL,
+		structure TEXT NOT NULL,
+		detail VARCHAR(10000),
+		date DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+
+	`INSERT OR IGNORE INTO apijson_user (id, sex, name, tag, head, contactIdList, pictureList, date) VALUES
+		(38710, 0, 'TommyLemon', 'Android&Java', 'http://static.oschina.net/uploads/user/1218/2437072_100.jpg?t=1461076033000', '[82003, 82005, 90814, 82004, 82009, 82002, 82044, 93793, 70793]', '["http://static.oschina.net/uploads/user/1218/2437072_100.jpg?t=1461076033000"]', '2017-02-01 19:21:50'),
+		(70793, 0, 'Strong', 'djdj', 'http://static.oschina.net/uploads/user/585/1170143_50.jpg?t=1390226446000', '[38710, 82002]', '[]', '2017-02-01 19:21:50'),
+		(82001, 0, 'Test', 'Test Account', 'https://static.oschina.net/uploads/user/19/39085_50.jpg', '[82002, 38710, 70793]', '[]', '2017-02-01 19:21:50'),
+		(82002, 1, 'Happy~', 'iOS&Android&Java', 'http://common.cnblogs.com/images/icon_weibo_24.png', '[82001, 38710, 70793]', '[]', '2017-02-01 19:21:50'),
+		(82003, 1, 'Wechat', 'Android', 'http://static.oschina.net/uploads/user/48/96331_50.jpg', '[82001, 82002, 38710]', '[]', '2017-02-01 19:21:50')`,
+	`INSERT OR IGNORE INTO Moment (id, userId, date, content, praiseUserIdList, pictureList) VALUES
+		(12, 70793, '2017-02-08 16:06:11', 'APIJSON, let interfaces and documents go to hell !', '[70793, 82003, 82002, 82001]', '["http://static.oschina.net/uploads/img/201604/22172508_eGDi.jpg"]'),
+		(15, 70793, '2017-02-08 16:06:11', 'APIJSON is a JSON transmission structure protocol', '[82002, 70793, 38710]', '[]'),
+		(32, 82002, '2017-02-08 16:06:11', 'tst', '[38710, 82001]', '[]'),
+		(58, 38710, '2017-02-08 16:06:11', 'This is a Content...-435', '[82001, 82002]', '[]'),
+		(170, 70793, '2017-02-01 19:14:31', 'This is a Content...73', '[82044, 82002, 82001]', '[]')`,
+	`INSERT OR IGNORE INTO Comment (id, toId, userId, momentId, date, content) VALUES
+		(4, 0, 38710, 470, '2017-02-01 19:20:50', 'This is a Content...-4'),
+		(13, 0, 82005, 58, '2017-02-01 19:20:50', 'This is a Content...-13'),
+		(22, 221, 82001, 470, '2017-02-01 19:20:50', '测试修改评论'),
+		(44, 0, 82003, 170, '2017-02-01 19:20:50', 'This is a Content...-44'),
+		(45, 0, 93793, 301, '2017-02-01 19:20:50', 'This is a Content...-45'),
+		(47, 4, 70793, 470, '2017-02-01 19:20:50', 'This is a Content...-47'),
+		(51, 45, 82003, 301, '2017-02-01 19:20:50', 'This is a Content...-51'),
+		(54, 0, 82004, 170, '2017-02-01 19:20:50', 'This is a Content...-54'),
+		(68, 0, 82005, 15, '2017-02-01 19:20:50', 'This is a Content...-68'),
+		(76, 45, 93793, 58, '2017-02-01 19:20:50', 'This is a Content...-76'),
+		(77, 13, 93793, 15, '2017-02-01 19:20:50', 'This is a Content...-77'),
+		(97, 13, 82006, 12, '2017-02-01 19:20:50', 'This is a Content...-97')`,
+	`INSERT OR IGNORE INTO Access (id, name, alias, post, "delete") VALUES
+		(1, 'apijson_user', 'User', '["ADMIN"]', '["ADMIN"]'),
+		(2, 'Moment', NULL, '["OWNER", "ADMIN"]', '["OWNER", "ADMIN"]'),
+		(3, 'Comment', NULL, '["OWNER", "ADMIN"]', '["OWNER", "ADMIN"]')`,
+	`INSERT OR IGNORE INTO Request (id, version, method, tag, structure, detail) VALUES
+		(1, 1, 'POST', 'Moment', '{"MUST": "content", "REFUSE": "id", "INSERT": {"praiseUserIdList": "[]"}}', '发布动态'),
+		(2, 1, 'PUT', 'Moment', '{"MUST": "id", "REFUSE": "userId,date"}', '修改动态'),
+		(3, 1, 'DELETE', 'Moment', '{"MUST": "id", "REFUSE": "!"}', '删除动态'),
+		(4, 1, 'POST', 'Comment', '{"MUST": "momentId,content", "REFUSE": "id"}', '发布评论'),
+		(5, 1, 'PUT', 'Comment', '{"MUST": "id", "REFUSE": "userId,momentId,date"}', '修改评论'),
+		(6, 1, 'DELETE', 'Comment', '{"MUST": "id", "REFUSE": "!"}', '删除评论'),
+		(7, 1, 'PUT', 'apijson_user', '{"MUST": "id", "REFUSE": "sex,date"}', '修改用户信息')`,
+}
+
+//SeedDemo 在事务中执行 schema，建表并插入示例数据，已存在的表和数据不会被覆盖
+func SeedDemo(ctx context.Context, db *sql.DB, schema []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range schema {
+		if _, err = tx.ExecContext(ctx, query); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,464 @@
+package apijson
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//Dialect SQL 方言，Statement 按 MySQL 的语法组装，标识符用反引号、参数用 ?、正则用 REGEXP，
+//生成 SQL 时再按方言转换标识符、占位符和正则操作符，分页和插入冲突处理由方言直接生成
+type Dialect interface {
+	Name() string                     //方言名称，如 mysql、postgres
+	Quote(identifier string) string   //标识符加引号，如 `id`、"id"
+	Placeholder(index int) string     //第 index 个参数的占位符，从 1 开始，如 ?、$1
+	Regexp(not bool) string           //正则匹配操作符，如 REGEXP、NOT REGEXP、~、!~
+	Limit(limit, offset int32) string //分页子句，小于 0 为不限制，如 " LIMIT 10 OFFSET 20"
+	LimitUpdate() bool                //UPDATE、DELETE 是否支持 ORDER BY、LIMIT
+	AnyAll() bool                     //比较子查询是否支持 ANY、ALL
+	ForUpdate() string                //事务中锁定查询到的行的子句，如 FOR UPDATE，不支持行锁时为空
+	ColumnsSQL() string               //查询当前库所有字段的语句，结果为表名、字段名、类型、是否可为空、是否唯一（YES、NO）
+
+	//以下方法返回的语句仍为 MySQL 的语法，由 convertSQL 统一转换
+
+	Concat(args ...string) string                        //字符串拼接，如 CONCAT(a, b)、a || b
+	InsertIgnore(table, cset string) string              //插入，主键或唯一索引冲突时忽略
+	Replace(table, cset string, columns []string) string //插入，冲突时用新数据替换 columns
+	Upsert(table, cset string, updates []string) string  //插入，冲突时执行 updates，如 "`num` = ?"
+	Excluded(column string) string                       //冲突时新数据的字段，用于 updates，如 VALUES(`num`)
+	ReturnID() string                                    //插入后返回自增主键的子句，为空时使用 LastInsertId
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{
+		"mysql":    MySQLDialect{},
+		"postgres": PostgresDialect{},
+		"pgx":      PostgresDialect{},
+		"sqlite3":  SQLiteDialect{},
+		"sqlite":   SQLiteDialect{},
+	}
+)
+
+//RegisterDialect 注册数据库驱动对应的方言，同名的会被替换，
+//引擎在 New 时确定方言，已创建的引擎不受影响，需要单独指定时用 Config.Dialect
+func RegisterDialect(driverName string, dialect Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+
+	dialects[driverName] = dialect
+}
+
+//GetDialect 获取数据库驱动对应的方言，未注册时为 MySQL
+func GetDialect(driverName string) Dialect {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+
+	if dialect, ok := dialects[driverName]; ok {
+		return dialect
+	}
+
+	return MySQLDialect{}
+}
+
+//MySQLDialect MySQL 方言
+type MySQLDialect struct{}
+
+//Name 方言名称
+func (MySQLDialect) Name() string {
+	return "mysql"
+}
+
+//Quote 标识符加反引号
+func (MySQLDialect) Quote(identifier string) string {
+	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+}
+
+//Placeholder 占位符 ?
+func (MySQLDialect) Placeholder(int) string {
+	return "?"
+}
+
+//Regexp REGEXP 、NOT REGEXP
+func (MySQLDialect) Regexp(not bool) string {
+	if not {
+		return "NOT REGEXP"
+	}
+
+	return "REGEXP"
+}
+
+//Limit 只有 OFFSET 时 LIMIT 为最大值，MySQL 不支持单独的 OFFSET
+func (MySQLDialect) Limit(limit, offset int32) string {
+	return limitOffset(limit, offset, "18446744073709551615")
+}
+
+//LimitUpdate UPDATE、DELETE 支持 ORDER BY、LIMIT
+func (MySQLDialect) LimitUpdate() bool {
+	return true
+}
+
+//AnyAll 支持 ANY、ALL
+func (MySQLDialect) AnyAll() bool {
+	return true
+}
+
+//Concat CONCAT(a, b)
+func (MySQLDialect) Concat(args ...string) string {
+	return concat(args)
+}
+
+//InsertIgnore INSERT IGNORE INTO
+func (MySQLDialect) InsertIgnore(table, cset string) string {
+	return fmt.Sprint("INSERT IGNORE INTO `", table, "` ", cset)
+}
+
+//Replace REPLACE INTO
+func (MySQLDialect) Replace(table, cset string, _ []string) string {
+	return fmt.Sprint("REPLACE INTO `", table, "` ", cset)
+}
+
+//Upsert INSERT INTO ... ON DUPLICATE KEY UPDATE
+func (MySQLDialect) Upsert(table, cset string, updates []string) string {
+	return fmt.Sprint("INSERT INTO `", table, "` ", cset, " ON DUPLICATE KEY UPDATE ", strings.Join(updates, ", "))
+}
+
+//Excluded VALUES(`column`)
+func (MySQLDialect) Excluded(column string) string {
+	return fmt.Sprint("VALUES(`", column, "`)")
+}
+
+//ReturnID 使用 LastInsertId
+func (MySQLDialect) ReturnID() string {
+	return ""
+}
+
+//ForUpdate FOR UPDATE
+func (MySQLDialect) ForUpdate() string {
+	return "FOR UPDATE"
+}
+
+//ColumnsSQL 从 information_schema 查询当前库的字段，单独的主键或唯一索引为唯一，联合主键的字段不是
+func (MySQLDialect) ColumnsSQL() string {
+	return "SELECT c.TABLE_NAME, c.COLUMN_NAME, c.DATA_TYPE, c.IS_NULLABLE," +
+		" CASE WHEN c.COLUMN_KEY = 'UNI' OR (c.COLUMN_KEY = 'PRI' AND (SELECT COUNT(*) FROM information_schema.COLUMNS k" +
+		" WHERE k.TABLE_SCHEMA = c.TABLE_SCHEMA AND k.TABLE_NAME = c.TABLE_NAME AND k.COLUMN_KEY = 'PRI') = 1)" +
+		" THEN 'YES' ELSE 'NO' END FROM information_schema.COLUMNS c" +
+		" WHERE c.TABLE_SCHEMA = DATABASE() ORDER BY c.TABLE_NAME, c.ORDINAL_POSITION"
+}
+
+//PostgresDialect PostgreSQL 方言，没有 REPLACE 和 INSERT IGNORE，用 ON CONFLICT 代替
+type PostgresDialect struct {
+	PrimaryKey string //主键，ON CONFLICT 的冲突字段和 RETURNING 返回的字段，默认为 id
+}
+
+func (d PostgresDialect) primaryKey() string {
+	if d.PrimaryKey == "" {
+		return "id"
+	}
+
+	return d.PrimaryKey
+}
+
+//Name 方言名称
+func (PostgresDialect) Name() string {
+	return "postgres"
+}
+
+//Quote 标识符加双引号，区分大小写，如 "Moment"
+func (PostgresDialect) Quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+//Placeholder 占位符 $1、$2
+func (PostgresDialect) Placeholder(index int) string {
+	return "$" + strconv.Itoa(index)
+}
+
+//Regexp ~ 、!~
+func (PostgresDialect) Regexp(not bool) string {
+	if not {
+		return "!~"
+	}
+
+	return "~"
+}
+
+//Limit 支持单独的 OFFSET
+func (PostgresDialect) Limit(limit, offset int32) string {
+	return limitOffset(limit, offset, "")
+}
+
+//LimitUpdate UPDATE、DELETE 不支持 ORDER BY、LIMIT
+func (PostgresDialect) LimitUpdate() bool {
+	return false
+}
+
+//AnyAll 支持 ANY、ALL
+func (PostgresDialect) AnyAll() bool {
+	return true
+}
+
+//Concat CONCAT(a, b)
+func (PostgresDialect) Concat(args ...string) string {
+	return concat(args)
+}
+
+//InsertIgnore ON CONFLICT DO NOTHING
+func (d PostgresDialect) InsertIgnore(table, cset string) string {
+	return fmt.Sprint("INSERT INTO `", table, "` ", cset, " ON CONFLICT DO NOTHING", d.ReturnID())
+}
+
+//Replace 主键冲突时更新除主键外的 columns
+func (d PostgresDialect) Replace(table, cset string, columns []string) string {
+	var updates []string
+	for _, column := range columns {
+		if column != d.primaryKey() {
+			updates = append(updates, fmt.Sprint("`", column, "` = ", d.Excluded(column)))
+		}
+	}
+
+	if len(updates) == 0 {
+		return d.InsertIgnore(table, cset)
+	}
+
+	return d.Upsert(table, cset, updates)
+}
+
+//Upsert 主键冲突时执行 updates
+func (d PostgresDialect) Upsert(table, cset string, updates []string) string {
+	return d.upsert(table, cset, updates) + d.ReturnID()
+}
+
+func (d PostgresDialect) upsert(table, cset string, updates []string) string {
+	return fmt.Sprint("INSERT INTO `", table, "` ", cset, " ON CONFLICT (`", d.primaryKey(), "`) DO UPDATE SET ",
+		strings.Join(updates, ", "))
+}
+
+//Excluded EXCLUDED.`column`
+func (PostgresDialect) Excluded(column string) string {
+	return fmt.Sprint("EXCLUDED.`", column, "`")
+}
+
+//ReturnID RETURNING 主键，lib/pq 等驱动不支持 LastInsertId
+func (d PostgresDialect) ReturnID() string {
+	return fmt.Sprint(" RETURNING `", d.primaryKey(), "`")
+}
+
+//ForUpdate FOR UPDATE
+func (PostgresDialect) ForUpdate() string {
+	return "FOR UPDATE"
+}
+
+//ColumnsSQL 从 information_schema 查询当前 schema 的字段，只有一个字段的主键或唯一约束的字段为唯一
+func (PostgresDialect) ColumnsSQL() string {
+	return "SELECT c.table_name, c.column_name, c.data_type, c.is_nullable," +
+		" CASE WHEN EXISTS (SELECT 1 FROM information_schema.table_constraints t" +
+		" JOIN information_schema.key_column_usage u ON u.constraint_schema = t.constraint_schema AND u.constraint_name = t.constraint_name" +
+		" WHERE t.table_schema = c.table_schema AND t.table_name = c.table_name AND t.constraint_type IN ('PRIMARY KEY', 'UNIQUE')" +
+		" AND u.column_name = c.column_name AND (SELECT COUNT(*) FROM information_schema.key_column_usage k" +
+		" WHERE k.constraint_schema = t.constraint_schema AND k.constraint_name = t.constraint_name) = 1)" +
+		" THEN 'YES' ELSE 'NO' END FROM information_schema.columns c" +
+		" WHERE c.table_schema = current_schema() ORDER BY c.table_name, c.ordinal_position"
+}
+
+//SQLiteDialect SQLite 方言，需要 3.24 以上的版本支持 ON CONFLICT DO UPDATE，
+//REGEXP 需要驱动注册 regexp 函数，如 go-sqlite3 的 ConnectHook 中 RegisterFunc("regexp", ...)
+type SQLiteDialect struct {
+	PrimaryKey string //主键，ON CONFLICT 的冲突字段，默认为 id
+}
+
+//Name 方言名称
+func (SQLiteDialect) Name() string {
+	return "sqlite3"
+}
+
+//Quote 标识符加双引号
+func (SQLiteDialect) Quote(identifier string) string {
+	return PostgresDialect{}.Quote(identifier)
+}
+
+//Placeholder 占位符 ?
+func (SQLiteDialect) Placeholder(int) string {
+	return "?"
+}
+
+//Regexp REGEXP 、NOT REGEXP
+func (SQLiteDialect) Regexp(not bool) string {
+	return MySQLDialect{}.Regexp(not)
+}
+
+//Limit 只有 OFFSET 时 LIMIT 为 -1，SQLite 不支持单独的 OFFSET
+func (SQLiteDialect) Limit(limit, offset int32) string {
+	return limitOffset(limit, offset, "-1")
+}
+
+//LimitUpdate 默认编译的 SQLite 不支持 UPDATE、DELETE 的 ORDER BY、LIMIT
+func (SQLiteDialect) LimitUpdate() bool {
+	return false
+}
+
+//AnyAll 不支持 ANY、ALL
+func (SQLiteDialect) AnyAll() bool {
+	return false
+}
+
+//Concat a || b，SQLite 3.44 以前没有 CONCAT 函数
+func (SQLiteDialect) Concat(args ...string) string {
+	return strings.Join(args, " || ")
+}
+
+//InsertIgnore INSERT OR IGNORE INTO
+func (SQLiteDialect) InsertIgnore(table, cset string) string {
+	return fmt.Sprint("INSERT OR IGNORE INTO `", table, "` ", cset)
+}
+
+//Replace REPLACE INTO
+func (SQLiteDialect) Replace(table, cset string, _ []string) string {
+	return fmt.Sprint("REPLACE INTO `", table, "` ", cset)
+}
+
+//Upsert 主键冲突时执行 updates
+func (d SQLiteDialect) Upsert(table, cset string, updates []string) string {
+	return PostgresDialect{PrimaryKey: d.PrimaryKey}.upsert(table, cset, updates)
+}
+
+//Excluded excluded.`column`
+func (SQLiteDialect) Excluded(column string) string {
+	return fmt.Sprint("excluded.`", column, "`")
+}
+
+//ReturnID 使用 LastInsertId
+func (SQLiteDialect) ReturnID() string {
+	return ""
+}
+
+//ForUpdate 不支持行锁，写事务锁整个数据库，并发的写事务中后写入的返回 database is locked，
+//数据源加 _txlock=immediate 时写事务在开始时加锁，并发的写请求排队执行
+func (SQLiteDialect) ForUpdate() string {
+	return ""
+}
+
+//ColumnsSQL 从 sqlite_master 和 pragma_table_info 查询所有表的字段，需要 3.16 以上的版本，主键不可为空
+func (SQLiteDialect) ColumnsSQL() string {
+	return "SELECT m.name, p.name, p.type, CASE WHEN p.\"notnull\" = 0 AND p.pk = 0 THEN 'YES' ELSE 'NO' END," +
+		" CASE WHEN (p.pk > 0 AND (SELECT COUNT(*) FROM pragma_table_info(m.name) k WHERE k.pk > 0) = 1)" +
+		" OR EXISTS (SELECT 1 FROM pragma_index_list(m.name) i WHERE i.\"unique\" = 1" +
+		" AND (SELECT COUNT(*) FROM pragma_index_info(i.name)) = 1" +
+		" AND (SELECT name FROM pragma_index_info(i.name)) = p.name) THEN 'YES' ELSE 'NO' END" +
+		" FROM sqlite_master m, pragma_table_info(m.name) p" +
+		" WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite\\_%' ESCAPE '\\' ORDER BY m.name, p.cid"
+}
+
+func concat(args []string) string {
+	return fmt.Sprint("CONCAT(", strings.Join(args, ", "), ")")
+}
+
+//LIMIT、OFFSET 子句，maxLimit 为只有 OFFSET 时 LIMIT 的值，为空时不加 LIMIT
+func limitOffset(limit, offset int32, maxLimit string) string {
+	var sql string
+	if limit >= 0 {
+		sql = fmt.Sprint(" LIMIT ", limit)
+	} else if offset >= 0 && maxLimit != "" {
+		sql = fmt.Sprint(" LIMIT ", maxLimit)
+	}
+
+	if offset >= 0 {
+		sql = fmt.Sprint(sql, " OFFSET ", offset)
+	}
+
+	return sql
+}
+
+//按方言转换 MySQL 语法的语句，引号内的字符串不转换
+func convertSQL(dialect Dialect, query string) string {
+	var sql strings.Builder
+	index := 0
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"':
+			end := quoteEnd(query, i)
+			sql.WriteString(query[i:end])
+			i = end - 1
+		case c == '`':
+			end := backquoteEnd(query, i)
+			if end == -1 {
+				sql.WriteString(query[i:])
+				return sql.String()
+			}
+
+			sql.WriteString(dialect.Quote(strings.Replace(query[i+1:end-1], "``", "`", -1)))
+			i = end - 1
+		case c == '?':
+			index++
+			sql.WriteString(dialect.Placeholder(index))
+		case strings.HasPrefix(query[i:], "REGEXP") && isWordBoundary(query, i, i+len("REGEXP")):
+			//前面的 NOT 与 REGEXP 一起转换，如 NOT REGEXP 转为 !~
+			str := sql.String()
+			trimmed := strings.TrimRight(str, " ")
+			not := strings.HasSuffix(trimmed, "NOT") && isWordBoundary(trimmed, len(trimmed)-3, len(trimmed))
+			if not {
+				sql.Reset()
+				sql.WriteString(trimmed[:len(trimmed)-3])
+			}
+
+			sql.WriteString(dialect.Regexp(not))
+			i += len("REGEXP") - 1
+		default:
+			sql.WriteByte(c)
+		}
+	}
+
+	return sql.String()
+}
+
+//反引号标识符的结束位置，两个反引号为转义，没有结束的反引号时返回 -1
+func backquoteEnd(query string, start int) int {
+	for i := start + 1; i < len(query); i++ {
+		if query[i] != '`' {
+			continue
+		}
+
+		if i+1 < len(query) && query[i+1] == '`' {
+			i++
+			continue
+		}
+
+		return i + 1
+	}
+
+	return -1
+}
+
+//引号字符串的结束位置，支持 \ 转义和两个引号的转义
+func quoteEnd(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+//query[start:end] 前后不是标识符的字符
+func isWordBoundary(query string, start, end int) bool {
+	isIdent := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+
+	return (start == 0 || !isIdent(query[start-1])) && (end == len(query) || !isIdent(query[end]))
+}
@@ -0,0 +1,296 @@
+package apijson
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+type dialectMoment struct {
+	ID      int64  `orm:"id,int64"`
+	Content string `orm:"content,string"`
+}
+
+type dialectCase struct {
+	name   string
+	build  func(dialect Dialect) (string, []interface{}, error)
+	mysql  string
+	pg     string
+	params []interface{}
+}
+
+func newWhere(kvs ...interface{}) *orderedmap.OrderedMap {
+	where := orderedmap.New()
+	for i := 0; i+1 < len(kvs); i += 2 {
+		where.Set(kvs[i].(string), kvs[i+1])
+	}
+
+	return where
+}
+
+var dialectCases = []dialectCase{
+	{
+		name: "find",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.Where(newWhere("id{}", []interface{}{1, 2}, "content~", "^a", "content!~", "b$", "userId>", 10))
+			statement.Order("`id`", true)
+			statement.LimitOffset(10, 20)
+			sql, err := CreateFindSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "SELECT * FROM `Moment` WHERE `id`  IN (?, ?)  AND `content`  REGEXP  ?  AND `content`  NOT REGEXP  ?  AND `userId` > ?  ORDER BY `id` DESC LIMIT 10 OFFSET 20",
+		pg:     `SELECT * FROM "Moment" WHERE "id"  IN ($1, $2)  AND "content"  ~  $3  AND "content"  !~  $4  AND "userId" > $5  ORDER BY "id" DESC LIMIT 10 OFFSET 20`,
+		params: []interface{}{1, 2, "^a", "b$", 10},
+	},
+	{
+		name: "offset",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment").Offset(5)
+			sql, err := CreateFindSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql: "SELECT * FROM `Moment` LIMIT 18446744073709551615 OFFSET 5",
+		pg:    `SELECT * FROM "Moment" OFFSET 5`,
+	},
+	{
+		name: "subquery",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			sub := NewDbStatement().SetDialect(dialect).SetTableName("Comment").Select("`momentId`")
+			sub.Where(newWhere("userId", 2))
+			sub.Limit(3)
+
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.Where(newWhere("userId", 1, "id{}@", &Subquery{Statement: sub}))
+			sql, err := CreateFindSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "SELECT * FROM `Moment` WHERE  `userId` = ?  AND (`id` IN (SELECT `momentId` FROM `Comment` WHERE  `userId` = ?  LIMIT 3))",
+		pg:     `SELECT * FROM "Moment" WHERE  "userId" = $1  AND ("id" IN (SELECT "momentId" FROM "Comment" WHERE  "userId" = $2  LIMIT 3))`,
+		params: []interface{}{1, 2},
+	},
+	{
+		name: "count group",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.Where(newWhere("@group", "userId"))
+			sql, err := CreateCountSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql: "SELECT count(*) FROM (SELECT `userId` FROM `Moment` GROUP BY `userId`) AS `_group`",
+		pg:    `SELECT count(*) FROM (SELECT "userId" FROM "Moment" GROUP BY "userId") AS "_group"`,
+	},
+	{
+		name: "insert",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.InsertMap(SetMap{"content": "a"})
+			sql, err := CreateInsertSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "INSERT INTO `Moment`  (  `content`  ) values ( ? ) ",
+		pg:     `INSERT INTO "Moment"  (  "content"  ) values ( $1 )  RETURNING "id"`,
+		params: []interface{}{"a"},
+	},
+	{
+		name: "insert ignore",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.InsertStruct(dialectMoment{ID: 1, Content: "a"})
+			sql, err := CreateInsertIgnoreSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "INSERT IGNORE INTO `Moment`  (  `id` , `content`  ) values ( ?,? ) ",
+		pg:     `INSERT INTO "Moment"  (  "id" , "content"  ) values ( $1,$2 )  ON CONFLICT DO NOTHING RETURNING "id"`,
+		params: []interface{}{int64(1), "a"},
+	},
+	{
+		name: "replace",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.ReplaceStruct(dialectMoment{ID: 1, Content: "a"})
+			sql, err := CreateReplaceSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "REPLACE INTO `Moment`  (  `id` , `content`  ) values ( ?,? ) ",
+		pg:     `INSERT INTO "Moment"  (  "id" , "content"  ) values ( $1,$2 )  ON CONFLICT ("id") DO UPDATE SET "content" = EXCLUDED."content" RETURNING "id"`,
+		params: []interface{}{int64(1), "a"},
+	},
+	{
+		name: "upsert",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.InsertStruct(dialectMoment{ID: 1, Content: "a"})
+			sql, err := CreateInsertOnDuplicateKeyUpdateSQL(statement, map[string]string{"content": "VALUES(content)"})
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "INSERT INTO `Moment`  (  `id` , `content`  ) values ( ?,? )  ON DUPLICATE KEY UPDATE `content` = VALUES(`content`)",
+		pg:     `INSERT INTO "Moment"  (  "id" , "content"  ) values ( $1,$2 )  ON CONFLICT ("id") DO UPDATE SET "content" = EXCLUDED."content" RETURNING "id"`,
+		params: []interface{}{int64(1), "a"},
+	},
+	{
+		name: "upsert value",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.InsertMap(SetMap{"id": 1})
+			sql, err := CreateInsertOnDuplicateKeyUpdateSQL(statement, map[string]string{"content": "b"})
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "INSERT INTO `Moment`  (  `id`  ) values ( ? )  ON DUPLICATE KEY UPDATE `content` = ?",
+		pg:     `INSERT INTO "Moment"  (  "id"  ) values ( $1 )  ON CONFLICT ("id") DO UPDATE SET "content" = $2 RETURNING "id"`,
+		params: []interface{}{1, "b"},
+	},
+	{
+		name: "update",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.UpdateMap(SetMap{"content+": "!"})
+			statement.Where(newWhere("id", 1))
+			sql, err := CreateUpdateSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "UPDATE `Moment` SET  `content` = CONCAT( `content` , ?) WHERE  `id` = ? ",
+		pg:     `UPDATE "Moment" SET  "content" = CONCAT( "content" , $1) WHERE  "id" = $2 `,
+		params: []interface{}{"!", 1},
+	},
+	{
+		name: "delete",
+		build: func(dialect Dialect) (string, []interface{}, error) {
+			statement := NewDbStatement().SetDialect(dialect).SetTableName("Moment")
+			statement.Where(newWhere("id{}", []interface{}{1, 2}))
+			sql, err := CreateDeleteSQL(statement)
+			return sql, statement.GetParams(), err
+		},
+		mysql:  "DELETE FROM `Moment`  WHERE  `id`  IN (?, ?) ",
+		pg:     `DELETE FROM "Moment"  WHERE  "id"  IN ($1, $2) `,
+		params: []interface{}{1, 2},
+	},
+}
+
+func TestDialectSQL(t *testing.T) {
+	dialects := []struct {
+		dialect Dialect
+		want    func(c dialectCase) string
+	}{
+		{MySQLDialect{}, func(c dialectCase) string { return c.mysql }},
+		{PostgresDialect{}, func(c dialectCase) string { return c.pg }},
+	}
+
+	for _, d := range dialects {
+		for _, c := range dialectCases {
+			sql, params, err := c.build(d.dialect)
+			if err != nil {
+				t.Errorf("%s %s: %v", d.dialect.Name(), c.name, err)
+				continue
+			}
+
+			if want := d.want(c); sql != want {
+				t.Errorf("%s %s:\n got: %s\nwant: %s", d.dialect.Name(), c.name, sql, want)
+			}
+
+			if len(params) != 0 || len(c.params) != 0 {
+				if !reflect.DeepEqual(params, c.params) {
+					t.Errorf("%s %s: params %v, want %v", d.dialect.Name(), c.name, params, c.params)
+				}
+			}
+		}
+	}
+}
+
+func TestDialectUpdateLimit(t *testing.T) {
+	statement := NewDbStatement().SetDialect(PostgresDialect{}).SetTableName("Moment").Limit(1)
+	statement.UpdateMap(SetMap{"content": "a"})
+	if _, err := CreateUpdateSQL(statement); err == nil {
+		t.Errorf("postgres UPDATE with LIMIT should fail")
+	}
+
+	statement.SetDialect(MySQLDialect{})
+	sql, err := CreateUpdateSQL(statement)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "UPDATE `Moment` SET  `content` =? LIMIT 1"; sql != want {
+		t.Errorf("got: %s\nwant: %s", sql, want)
+	}
+}
+
+func TestDialectForUpdate(t *testing.T) {
+	tests := []struct {
+		dialect Dialect
+		want    string
+	}{
+		{MySQLDialect{}, "SELECT  `praiseUserIdList`  FROM `Moment` WHERE  `id` = ?  LIMIT 1 FOR UPDATE"},
+		{PostgresDialect{}, `SELECT  "praiseUserIdList"  FROM "Moment" WHERE  "id" = $1  LIMIT 1 FOR UPDATE`},
+		{SQLiteDialect{}, `SELECT  "praiseUserIdList"  FROM "Moment" WHERE  "id" = ?  LIMIT 1`},
+	}
+
+	for _, test := range tests {
+		statement := NewDbStatement().SetDialect(test.dialect).SetTableName("Moment")
+		statement.Select(columnQuote("praiseUserIdList")).Where(newWhere("id", 12)).Limit(1)
+		statement.ForUpdate(test.dialect.ForUpdate())
+
+		sql, err := CreateFindSQL(statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if sql != test.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", test.dialect.Name(), sql, test.want)
+		}
+	}
+}
+
+func TestConvertSQL(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{"SELECT `a` FROM `t` WHERE `a` = '?`' AND `b` = ?", `SELECT "a" FROM "t" WHERE "a" = '?` + "`" + `' AND "b" = $1`},
+		{"SELECT 'it''s ?' AS `x`, ?", `SELECT 'it''s ?' AS "x", $1`},
+		{"`a` NOT REGEXP ? OR `b` REGEXP ? OR `REGEXP_c` = ?", `"a" !~ $1 OR "b" ~ $2 OR "REGEXP_c" = $3`},
+		{"SELECT `a``b`,`c` FROM `t`", `SELECT "a` + "`" + `b","c" FROM "t"`},
+	}
+
+	for _, test := range tests {
+		if got := convertSQL(PostgresDialect{}, test.sql); got != test.want {
+			t.Errorf("convertSQL(%q)\n got: %s\nwant: %s", test.sql, got, test.want)
+		}
+	}
+}
+
+func TestSQLiteDialectSQL(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  func(statement *Statement) (string, error)
+		want string
+	}{
+		{"offset", func(statement *Statement) (string, error) {
+			return CreateFindSQL(statement.Offset(5))
+		}, `SELECT * FROM "Moment" LIMIT -1 OFFSET 5`},
+		{"insert ignore", func(statement *Statement) (string, error) {
+			return CreateInsertIgnoreSQL(statement.InsertMap(SetMap{"id": 1}))
+		}, `INSERT OR IGNORE INTO "Moment"  (  "id"  ) values ( ? ) `},
+		{"upsert", func(statement *Statement) (string, error) {
+			return CreateInsertOnDuplicateKeyUpdateSQL(statement.InsertMap(SetMap{"id": 1}),
+				map[string]string{"content": "VALUES(content)"})
+		}, `INSERT INTO "Moment"  (  "id"  ) values ( ? )  ON CONFLICT ("id") DO UPDATE SET "content" = excluded."content"`},
+		{"concat", func(statement *Statement) (string, error) {
+			return CreateUpdateSQL(statement.UpdateMap(SetMap{"content+": "!"}))
+		}, `UPDATE "Moment" SET  "content" =  "content"  || ?`},
+	}
+
+	for _, test := range tests {
+		statement := NewDbStatement().SetDialect(SQLiteDialect{}).SetTableName("Moment")
+		sql, err := test.sql(statement)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if sql != test.want {
+			t.Errorf("%s:\n got: %s\nwant: %s", test.name, sql, test.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package apijson
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+//PoolConfig 连接池配置
+type PoolConfig struct {
+	MaxOpenConns    int           //最大连接数，0 为不限制
+	MaxIdleConns    int           //最大空闲连接数，0 为不保留空闲连接
+	ConnMaxLifetime time.Duration //连接最长复用时间，0 为不限制
+}
+
+//DefaultPoolConfig 默认连接池配置，每次返回新的值
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxOpenConns:    50,
+		MaxIdleConns:    10,
+		ConnMaxLifetime: 30 * time.Minute,
+	}
+}
+
+//Limits 请求的数量限制，为 0 的限制按默认值
+type Limits struct {
+	DefaultCount   int //数组未指定 count 时每页的默认条数
+	MaxCount       int //数组每页最大条数，超过则报错
+	MaxPage        int //数组最大页码，从 0 开始，超过则报错
+	MaxUpdateCount int //id{} 及 "Table[]" 批量新增、修改的最大条数
+}
+
+//默认的数量限制
+var defaultLimits = Limits{
+	DefaultCount:   10,
+	MaxCount:       100,
+	MaxPage:        100,
+	MaxUpdateCount: 10,
+}
+
+//为 0 的限制取默认值，如 Config 字面量未设置 Limits，默认条数不超过最大条数
+func (l Limits) orDefault() Limits {
+	if l.DefaultCount <= 0 {
+		l.DefaultCount = defaultLimits.DefaultCount
+	}
+
+	if l.MaxCount <= 0 {
+		l.MaxCount = defaultLimits.MaxCount
+	}
+
+	if l.MaxPage <= 0 {
+		l.MaxPage = defaultLimits.MaxPage
+	}
+
+	if l.MaxUpdateCount <= 0 {
+		l.MaxUpdateCount = defaultLimits.MaxUpdateCount
+	}
+
+	if l.DefaultCount > l.MaxCount {
+		l.DefaultCount = l.MaxCount
+	}
+
+	return l
+}
+
+//Config 引擎配置，用 NewConfig 创建默认配置后按需修改，New 之后再修改不影响引擎
+type Config struct {
+	DriverName string     //数据库驱动名，如 mysql、postgres、sqlite3
+	DataSource string     //默认数据源名称，如 root:password@tcp(localhost:3306)/sys?parseTime=true
+	Dialect    Dialect    //SQL 方言，为空时为驱动名对应的方言
+	Pool       PoolConfig //连接池配置，每个数据源一个连接池
+
+	//其它数据源，key 为名称，value 为数据源名称，与默认数据源使用同一驱动和方言，
+	//请求最外层用 "@datasource": "名称" 指定，每个数据源各自的连接池和表结构
+	DataSources map[string]string
+
+	Concurrency int        //每个请求最外层节点并发查询的最大数量，不大于 1 时顺序查询
+	Introspect  bool       //是否加载表结构，校验请求中的表和字段并转换条件值的类型
+	KeepNull    bool       //查询结果保留 NULL，输出为 JSON null，关闭时兼容旧版本转为 ""、0、false
+	Time        TimeConfig //时间的时区和输出格式
+	Limits      Limits     //请求的数量限制
+	HTTP        HTTPConfig //Handler 的配置
+
+	AccessTable   string            //权限表，name 为表名，get、head、gets、heads、post、put、delete 为允许的角色 JSON 数组
+	RequestTable  string            //校验规则表，字段有 method、tag、version、structure
+	VisitorIDKey  string            //表中访问者 id 的字段，OWNER、CONTACT、CIRCLE 角色按它加条件
+	VisitorIDKeys map[string]string //访问者 id 字段不是 VisitorIDKey 的表，如用户表本身按 id
+
+	Functions     map[string]*Function      //远程函数，内置的 isContain、getFromArray 之外的函数，同名时替换内置函数
+	Codecs        map[string]Codec          //数据库类型的编解码，替换方言默认的编解码，key 为小写并去掉长度的类型，如 tinyint
+	RegexpAliases map[string]*regexp.Regexp //VERIFY 中 "key~" 正则的别名，如 "phone~": "PHONE"
+
+	ColumnFunctions    map[string]bool //@column 允许的 SQL 函数，大写，为 nil 时为默认的函数
+	AggregateFunctions map[string]bool //@having 允许的聚合函数，大写，为 nil 时为默认的函数
+}
+
+//NewConfig 创建默认配置，开启表结构校验和保留 NULL，时间为 UTC 和 RFC 3339
+func NewConfig(driverName, dataSource string) Config {
+	return Config{
+		DriverName:  driverName,
+		DataSource:  dataSource,
+		Pool:        DefaultPoolConfig(),
+		Concurrency: runtime.GOMAXPROCS(0),
+		Introspect:  true,
+		KeepNull:    true,
+		Limits:      defaultLimits,
+		HTTP: HTTPConfig{
+			MaxBodySize: 1 << 20,
+			Gzip:        true,
+			Timeout:     30 * time.Second,
+		},
+		AccessTable:  "Access",
+		RequestTable: "Request",
+		VisitorIDKey: "userId",
+		VisitorIDKeys: map[string]string{
+			"apijson_user":    "id",
+			"apijson_privacy": "id",
+		},
+		RegexpAliases: map[string]*regexp.Regexp{
+			"PHONE":   regexp.MustCompile(`^1[3-9]\d{9}$`),
+			"EMAIL":   regexp.MustCompile(`^[\w.+-]+@[\w-]+(\.[\w-]+)+$`),
+			"ID_CARD": regexp.MustCompile(`^\d{17}[\dXx]$`),
+		},
+		ColumnFunctions:    copyFunctions(columnFunctions),
+		AggregateFunctions: copyFunctions(aggregateFunctions),
+	}
+}
+
+//Parser 未设置 Config 时使用的默认配置，只读
+var defaultConfig = NewConfig("mysql", "")
+
+//复制 map，New 之后调用方修改原配置不影响引擎
+func (c Config) clone() Config {
+	visitorIDKeys := make(map[string]string, len(c.VisitorIDKeys))
+	for k, v := range c.VisitorIDKeys {
+		visitorIDKeys[k] = v
+	}
+
+	functions := make(map[string]*Function, len(c.Functions))
+	for k, v := range c.Functions {
+		functions[k] = v
+	}
+
+	codecs := make(map[string]Codec, len(c.Codecs))
+	for k, v := range c.Codecs {
+		codecs[normalizeDBType(k)] = v
+	}
+
+	aliases := make(map[string]*regexp.Regexp, len(c.RegexpAliases))
+	for k, v := range c.RegexpAliases {
+		aliases[k] = v
+	}
+
+	dataSources := make(map[string]string, len(c.DataSources))
+	for k, v := range c.DataSources {
+		dataSources[k] = v
+	}
+
+	c.DataSources, c.VisitorIDKeys, c.Functions, c.Codecs, c.RegexpAliases = dataSources, visitorIDKeys, functions, codecs, aliases
+	c.ColumnFunctions, c.AggregateFunctions = copyFunctions(c.ColumnFunctions), copyFunctions(c.AggregateFunctions)
+	c.HTTP.AllowOrigins = append([]string(nil), c.HTTP.AllowOrigins...)
+	return c
+}
+
+//复制允许的 SQL 函数，函数名转为大写，nil 仍为 nil 表示默认的函数
+func copyFunctions(functions map[string]bool) map[string]bool {
+	if functions == nil {
+		return nil
+	}
+
+	m := make(map[string]bool, len(functions))
+	for k, v := range functions {
+		m[strings.ToUpper(k)] = v
+	}
+
+	return m
+}
+
+//KeyDataSource 请求最外层指定数据源的 key，值为 Config.DataSources 中的名称，不指定时为默认数据源
+const KeyDataSource = "@datasource"
+
+//Engine 长期持有的解析引擎，一个数据源一个连接池，所有请求共用，并发安全，
+//同一进程可以创建多个不同配置的引擎，如开放接口和管理后台各一个
+type Engine struct {
+	config Config
+
+	mu      sync.Mutex
+	sources map[string]*dataSource //按名称的数据源，默认数据源的名称为空，New 之后不再增删
+	closed  bool
+}
+
+//数据源的连接池和表结构
+type dataSource struct {
+	dsn    string
+	db     *sql.DB
+	schema *Schema //表结构，第一次用到时加载
+}
+
+//New 按配置创建引擎，每个数据源一个连接池，连接在第一次查询时建立
+func New(config Config) (*Engine, error) {
+	if config.DriverName == "" {
+		return nil, fmt.Errorf("driver name is empty")
+	}
+
+	config = config.clone()
+	if config.Dialect == nil {
+		config.Dialect = GetDialect(config.DriverName)
+	}
+
+	dsns := map[string]string{"": config.DataSource}
+	for name, dsn := range config.DataSources {
+		if name == "" {
+			return nil, fmt.Errorf("data source name is empty")
+		}
+
+		dsns[name] = dsn
+	}
+
+	e := &Engine{config: config, sources: make(map[string]*dataSource, len(dsns))}
+	for name, dsn := range dsns {
+		db, err := sql.Open(config.DriverName, dsn)
+		if err != nil {
+			_ = e.Close()
+			return nil, err
+		}
+
+		applyPoolConfig(db, config.Pool)
+		e.sources[name] = &dataSource{dsn: dsn, db: db}
+	}
+
+	return e, nil
+}
+
+//Config 引擎的配置
+func (e *Engine) Config() Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.config.clone()
+}
+
+//获取数据源，名称不在 Config.DataSources 中时返回 404 错误
+func (e *Engine) source(name string) (*dataSource, error) {
+	s, ok := e.sources[name]
+	if !ok {
+		return nil, NewNotExistError("data source %s does not exist", name)
+	}
+
+	return s, nil
+}
+
+//DB 默认数据源的连接池
+func (e *Engine) DB() *sql.DB {
+	return e.sources[""].db
+}
+
+//NamedDB 按名称获取数据源的连接池，名称为空时为默认数据源
+func (e *Engine) NamedDB(name string) (*sql.DB, error) {
+	s, err := e.source(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.db, nil
+}
+
+//SetPoolConfig 修改所有数据源的连接池配置，立即生效
+func (e *Engine) SetPoolConfig(pool PoolConfig) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.config.Pool = pool
+	for _, s := range e.sources {
+		applyPoolConfig(s.db, pool)
+	}
+}
+
+func applyPoolConfig(db *sql.DB, pool PoolConfig) {
+	db.SetMaxOpenConns(pool.MaxOpenConns)
+	db.SetMaxIdleConns(pool.MaxIdleConns)
+	db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+}
+
+//Client 获取默认数据源的客户端
+func (e *Engine) Client() (*Client, error) {
+	return e.NamedClient("")
+}
+
+//NamedClient 按名称获取数据源的客户端，共用数据源的连接池
+func (e *Engine) NamedClient(name string) (*Client, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, fmt.Errorf("engine is closed")
+	}
+
+	s, err := e.source(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		NameSrv:  s.dsn,
+		Proxy:    s.db,
+		Dialect:  e.config.Dialect,
+		KeepNull: e.config.KeepNull,
+		Time:     e.config.Time,
+		Codecs:   e.config.Codecs,
+	}, nil
+}
+
+//Schema 获取默认数据源的表结构，第一次用到时加载并缓存，可以在启动时调用提前加载
+func (e *Engine) Schema(ctx context.Context) (*Schema, error) {
+	return e.NamedSchema(ctx, "")
+}
+
+//NamedSchema 按名称获取数据源的表结构，第一次用到时加载并缓存
+func (e *Engine) NamedSchema(ctx context.Context, name string) (*Schema, error) {
+	s, err := e.source(name)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	schema := s.schema
+	e.mu.Unlock()
+
+	if schema != nil {
+		return schema, nil
+	}
+
+	return e.RefreshNamedSchema(ctx, name)
+}
+
+//RefreshSchema 重新加载默认数据源的表结构，用于表结构变更后，正在处理的请求仍使用旧的表结构
+func (e *Engine) RefreshSchema(ctx context.Context) (*Schema, error) {
+	return e.RefreshNamedSchema(ctx, "")
+}
+
+//RefreshNamedSchema 按名称重新加载数据源的表结构
+func (e *Engine) RefreshNamedSchema(ctx context.Context, name string) (*Schema, error) {
+	db, err := e.NamedClient(name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := LoadSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, fmt.Errorf("engine is closed")
+	}
+
+	e.sources[name].schema = schema
+	return schema, nil
+}
+
+//Close 关闭所有数据源的连接池，之后的请求返回错误，返回第一个关闭出错的错误
+func (e *Engine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil
+	}
+
+	e.closed = true
+
+	var err error
+	for _, s := range e.sources {
+		s.schema = nil
+		if closeErr := s.db.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+//Parse 按请求方法解析请求，method 为 get、head、gets、heads、post、put、delete，可以并发调用，
+//请求最外层的 "@datasource" 指定数据源
+func (e *Engine) Parse(ctx context.Context, method RequestMethod, reqbody []byte) ([]byte, error) {
+	if !method.IsValid() {
+		return nil, fmt.Errorf("request method %s is invalid", method)
+	}
+
+	req, err := decodeRequest(reqbody)
+	if err != nil {
+		return nil, err
+	}
+
+	var name string
+	if tmp, ok := req.Get(KeyDataSource); ok {
+		if name, ok = tmp.(string); !ok || name == "" {
+			return nil, fmt.Errorf("%s must be a non-empty string", KeyDataSource)
+		}
+	}
+
+	db, err := e.NamedClient(name)
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	p := &Parser{Method: method, DB: db, Config: &e.config, Concurrency: e.config.Concurrency}
+	if e.config.Introspect {
+		p.Schema, err = e.NamedSchema(ctx, name)
+		if err != nil {
+			return nil, dbError(err)
+		}
+	}
+
+	return p.parseRequest(ctx, req)
+}
@@ -0,0 +1,166 @@
+package apijson
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEngineConfig(t *testing.T) {
+	for _, config := range []Config{NewConfig("", ""), NewConfig("unknown", "")} {
+		if _, err := New(config); err == nil {
+			t.Errorf("driver %q: want error", config.DriverName)
+		}
+	}
+
+	registerSQLite()
+
+	config := NewConfig(sqliteDriverName, "file::memory:")
+	config.Dialect = SQLiteDialect{}
+	e := newEngine(t, config)
+
+	//New 之后修改原配置或 Config 返回的配置不影响引擎
+	config.VisitorIDKeys["Moment"] = "id"
+	config.Limits.MaxCount = 1
+
+	got := e.Config()
+	got.VisitorIDKeys["Comment"] = "id"
+	got.ColumnFunctions["SLEEP"] = true
+
+	got = e.Config()
+	if len(got.VisitorIDKeys) != 2 || got.Limits.MaxCount != 100 || got.ColumnFunctions["SLEEP"] || got.Dialect.Name() != "sqlite3" {
+		t.Errorf("config = %+v", got)
+	}
+
+	if stats := e.DB().Stats(); stats.MaxOpenConnections != DefaultPoolConfig().MaxOpenConns {
+		t.Errorf("MaxOpenConnections = %d, want %d", stats.MaxOpenConnections, DefaultPoolConfig().MaxOpenConns)
+	}
+}
+
+func TestEnginePool(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.Concurrency = 4
+	})
+
+	pool := PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1, ConnMaxLifetime: time.Minute}
+	e.SetPoolConfig(pool)
+
+	if got := e.Config().Pool; got != pool {
+		t.Errorf("pool = %+v, want %+v", got, pool)
+	}
+
+	if stats := e.DB().Stats(); stats.MaxOpenConnections != 1 {
+		t.Errorf("MaxOpenConnections = %d, want 1", stats.MaxOpenConnections)
+	}
+
+	//只有一个连接时，并发的请求和请求内并发的节点排队使用
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			_, errs[i] = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}, "Comment": {"id": 4},
+				"[]": {"count": 3, "apijson_user": {}}, "apijson_user": {"id@": "Moment/userId"}}`)
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if stats := e.DB().Stats(); stats.OpenConnections > 1 {
+		t.Errorf("OpenConnections = %d, want at most 1", stats.OpenConnections)
+	}
+}
+
+func TestEngineClose(t *testing.T) {
+	e := newSQLiteEngine(t)
+	mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}}`)
+
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	//重复关闭不报错
+	if err := e.Close(); err != nil {
+		t.Errorf("close again: %v", err)
+	}
+
+	if _, err := parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}}`); err == nil {
+		t.Error("parse after close should fail")
+	}
+
+	if _, err := e.Client(); err == nil {
+		t.Error("client after close should fail")
+	}
+
+	if _, err := e.RefreshSchema(context.Background()); err == nil {
+		t.Error("refresh schema after close should fail")
+	}
+}
+
+func TestEngineDataSources(t *testing.T) {
+	archive := "file:" + filepath.Join(t.TempDir(), "archive.db") + "?_busy_timeout=5000"
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.DataSources = map[string]string{"archive": archive}
+	})
+
+	db, err := e.NamedDB("archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//归档库的动态多了 tag 字段，每个数据源各自加载表结构
+	ctx := context.Background()
+	if err = SeedDemo(ctx, db, SQLiteDemoSchema); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.ExecContext(ctx, `ALTER TABLE Moment ADD COLUMN tag TEXT DEFAULT 'archived'`); err != nil {
+		t.Fatal(err)
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, "@column": "id,tag"}, "@datasource": "archive"}`)
+	if tag := getObject(t, res, "Moment")["tag"]; tag != "archived" {
+		t.Errorf("archive Moment = %v, want tag archived", res["Moment"])
+	}
+
+	if _, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, "@column": "id,tag"}}`); err == nil {
+		t.Error("tag of default data source should not exist")
+	}
+
+	if _, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}, "@datasource": "backup"}`); !errors.Is(err, ErrNotExist) {
+		t.Errorf("error = %v, want not exist", err)
+	}
+
+	if _, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}, "@datasource": 1}`); err == nil {
+		t.Error("invalid @datasource should fail")
+	}
+
+	//连接池配置和关闭对所有数据源生效
+	e.SetPoolConfig(PoolConfig{MaxOpenConns: 2})
+	if stats := db.Stats(); stats.MaxOpenConnections != 2 {
+		t.Errorf("archive MaxOpenConnections = %d, want 2", stats.MaxOpenConnections)
+	}
+
+	if err = e.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.PingContext(ctx); err == nil {
+		t.Error("archive data source should be closed")
+	}
+
+	if _, err = New(Config{DriverName: sqliteDriverName, DataSources: map[string]string{"": archive}}); err == nil {
+		t.Error("empty data source name should fail")
+	}
+}
@@ -0,0 +1,190 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//错误码，与 APIJSON 的 JSONResponse 一致
+const (
+	CodeSuccess         = 200 //成功
+	CodeIllegalAccess   = 401 //权限错误
+	CodeNotFound        = 404 //未找到
+	CodeIllegalArgument = 406 //参数错误
+	CodeNotLoggedIn     = 407 //未登录
+	CodeConflict        = 409 //重复，已存在
+	CodeConditionError  = 412 //条件错误，如密码错误
+	CodeOutOfRange      = 416 //超出范围
+	CodeServerError     = 500 //服务器内部错误
+)
+
+//MsgSuccess 成功信息
+const MsgSuccess = "success"
+
+//Error 带错误码的错误，对应 APIJSON 的各种 Exception
+type Error struct {
+	Code int    //错误码
+	Msg  string //错误信息
+	Path string //出错节点的 JSON 路径，如 "[]/Moment"
+
+	err error //原始错误，用于 errors.Is 判断，如 context.Canceled
+}
+
+//各类错误，用于 errors.Is 判断，如 errors.Is(err, ErrConflict)
+var (
+	ErrIllegalAccess  = &Error{Code: CodeIllegalAccess}  //权限错误，对应 IllegalAccessException
+	ErrNotExist       = &Error{Code: CodeNotFound}       //不存在，对应 NotExistException
+	ErrNotLoggedIn    = &Error{Code: CodeNotLoggedIn}    //未登录，对应 NotLoggedInException
+	ErrConflict       = &Error{Code: CodeConflict}       //重复，已存在，对应 ConflictException
+	ErrConditionError = &Error{Code: CodeConditionError} //条件错误，对应 ConditionErrorException
+	ErrOutOfRange     = &Error{Code: CodeOutOfRange}     //超出范围，对应 OutOfRangeException
+)
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+
+	return e.Path + ": " + e.Msg
+}
+
+//Is 错误码相同即为同一类错误
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+//Unwrap 原始错误
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+//HTTPStatus 错误码对应的 HTTP 状态码，APIJSON 自定义的 401、406、407 转为标准的状态码
+func (e *Error) HTTPStatus() int {
+	switch e.Code {
+	case CodeIllegalAccess:
+		return http.StatusForbidden
+	case CodeIllegalArgument:
+		return http.StatusBadRequest
+	case CodeNotLoggedIn:
+		return http.StatusUnauthorized
+	}
+
+	if e.Code >= 400 && e.Code < 600 {
+		return e.Code
+	}
+
+	return http.StatusInternalServerError
+}
+
+func newError(code int, format string, args ...interface{}) error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+//NewIllegalAccessError 权限错误
+func NewIllegalAccessError(format string, args ...interface{}) error {
+	return newError(CodeIllegalAccess, format, args...)
+}
+
+//NewNotExistError 不存在，如引用的对象为空
+func NewNotExistError(format string, args ...interface{}) error {
+	return newError(CodeNotFound, format, args...)
+}
+
+//NewNotLoggedInError 未登录
+func NewNotLoggedInError(format string, args ...interface{}) error {
+	return newError(CodeNotLoggedIn, format, args...)
+}
+
+//NewConflictError 重复，已存在
+func NewConflictError(format string, args ...interface{}) error {
+	return newError(CodeConflict, format, args...)
+}
+
+//NewConditionError 条件错误，如不满足 Request 表的校验规则
+func NewConditionError(format string, args ...interface{}) error {
+	return newError(CodeConditionError, format, args...)
+}
+
+//NewOutOfRangeError 超出范围，如 count、page 过大
+func NewOutOfRangeError(format string, args ...interface{}) error {
+	return newError(CodeOutOfRange, format, args...)
+}
+
+//数据库错误的通用错误信息
+const msgDBError = "database error"
+
+//数据库错误，视为服务器内部错误，驱动的错误信息可能包含表名、SQL 和约束名，记录日志后返回通用的错误信息，
+//原始错误保留在 err 中用于 errors.Is 判断，取消和超时不是数据库的信息，原样返回
+func dbError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: CodeServerError, Msg: err.Error(), err: err}
+	}
+
+	log.Printf("apijson: %s: %v", msgDBError, err)
+	return &Error{Code: CodeServerError, Msg: msgDBError, err: err}
+}
+
+//ToError 转为带错误码的错误，未分类的错误视为参数错误
+func ToError(err error) *Error {
+	var e *Error
+	if !errors.As(err, &e) {
+		return &Error{Code: CodeIllegalArgument, Msg: err.Error(), err: err}
+	}
+
+	//被 %w 包装时保留内层的错误码和路径，错误信息为外层的，内层的路径只在 Path 中
+	if error(e) != err {
+		msg := strings.Replace(err.Error(), e.Error(), e.Msg, 1)
+		return &Error{Code: e.Code, Msg: msg, Path: e.Path, err: err}
+	}
+
+	return e
+}
+
+//错误加上节点的 key 作为路径前缀
+func errorWithPath(err error, key string) error {
+	e := *ToError(err)
+
+	if e.Path == "" {
+		e.Path = key
+	} else {
+		e.Path = key + "/" + e.Path
+	}
+
+	return &e
+}
+
+//ErrorResponse 错误响应 {"code": 412, "msg": "...", "ok": false, "path": "Moment"} 和 HTTP 状态码
+func ErrorResponse(err error) ([]byte, int) {
+	e := ToError(err)
+
+	resp := struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+		Ok   bool   `json:"ok"`
+		Path string `json:"path,omitempty"`
+	}{e.Code, e.Msg, false, e.Path}
+
+	body, _ := json.Marshal(resp)
+
+	return body, e.HTTPStatus()
+}
+
+//带路径的错误，path 为出错节点的 JSON 路径，如 "/Moment/content"，去掉开头的 "/" 与 ParseNode 的路径一致
+func pathError(code int, path, format string, args ...interface{}) error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...), Path: strings.TrimPrefix(path, "/")}
+}
@@ -0,0 +1,118 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestToError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		status int
+		path   string
+		msg    string
+	}{
+		{"plain", errors.New("bad"), CodeIllegalArgument, http.StatusBadRequest, "", "bad"},
+		{"typed", NewConflictError("dup"), CodeConflict, http.StatusConflict, "", "dup"},
+		{"wrapped", fmt.Errorf("x(): %w", NewNotLoggedInError("login")), CodeNotLoggedIn, http.StatusUnauthorized, "", "x(): login"},
+		{"nested path", errorWithPath(errorWithPath(NewNotExistError("none"), "Moment"), "[]"),
+			CodeNotFound, http.StatusNotFound, "[]/Moment", "none"},
+		//包装带路径的错误后再加外层路径，路径只出现一次
+		{"wrapped path", errorWithPath(fmt.Errorf("subquery id{}@: %w", errorWithPath(NewIllegalAccessError("denied"), "Comment")), "Moment"),
+			CodeIllegalAccess, http.StatusForbidden, "Moment/Comment", "subquery id{}@: denied"},
+		{"db", dbError(context.Canceled), CodeServerError, http.StatusInternalServerError, "", "context canceled"},
+		//驱动的错误信息不返回给客户端
+		{"db message", dbError(errors.New("no such table: secret")), CodeServerError, http.StatusInternalServerError, "", msgDBError},
+	}
+
+	for _, test := range tests {
+		e := ToError(test.err)
+		if e.Code != test.code || e.HTTPStatus() != test.status || e.Path != test.path || e.Msg != test.msg {
+			t.Errorf("%s: error = %+v", test.name, e)
+		}
+
+		if test.path != "" && e.Error() != test.path+": "+test.msg {
+			t.Errorf("%s: Error() = %s", test.name, e.Error())
+		}
+	}
+
+	if !errors.Is(ToError(fmt.Errorf("x: %w", NewConflictError("dup"))), ErrConflict) {
+		t.Error("wrapped conflict error should be ErrConflict")
+	}
+
+	if !errors.Is(dbError(fmt.Errorf("query: %w", context.Canceled)), context.Canceled) {
+		t.Error("db error should keep the original error")
+	}
+
+	driverErr := errors.New("UNIQUE constraint failed: apijson_user.name")
+	if !errors.Is(dbError(fmt.Errorf("exec: %w", driverErr)), driverErr) {
+		t.Error("db error should keep the driver error")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//成功时与 APIJSON 一致带上 code、msg、ok
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, "@column": "id"}}`)
+	if res["code"] != float64(CodeSuccess) || res["msg"] != MsgSuccess || res["ok"] != true {
+		t.Errorf("response = %v", res)
+	}
+
+	//数据库的错误信息不返回，如 SQLite 的正则错误
+	_, err := parseSQLite(t, e, nil, MethodGet, `{"Moment": {"content~": "("}}`)
+	if err == nil {
+		t.Fatal("invalid regexp should fail")
+	}
+
+	body, status := ErrorResponse(err)
+	if status != http.StatusInternalServerError || strings.Contains(string(body), "regexp") || !strings.Contains(string(body), msgDBError) {
+		t.Errorf("response = %d %s", status, body)
+	}
+}
+
+func TestErrorPath(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		body string
+		code int
+		path string
+	}{
+		{`{"Moment": {"id": 12}, "Request": {"id": 1}}`, CodeIllegalAccess, "Request"},
+		{`{"[]": {"Moment": {"id{}@": {"from": "Request", "Request": {"@column": "id"}}}}}`, CodeIllegalAccess, "[]/Moment"},
+		{`{"[]": {"Moment": {}, "Comment[]": {"Comment": {"momentId@": "[]/Moment/id", "x()": "isContain(content)"}}}}`,
+			CodeIllegalArgument, "[]/Comment[]/Comment"},
+		{`{"[]": {"count": 1000, "Moment": {}}}`, CodeOutOfRange, "[]"},
+	}
+
+	for _, test := range tests {
+		_, err := parseSQLite(t, e, nil, MethodGet, test.body)
+		if err == nil {
+			t.Errorf("%s: want error", test.body)
+			continue
+		}
+
+		body, _ := ErrorResponse(err)
+
+		var resp struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+			Path string `json:"path"`
+		}
+		if err = json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Code != test.code || resp.Path != test.path {
+			t.Errorf("%s: response = %s, want code %d path %s", test.body, body, test.code, test.path)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//ArgType 远程函数的参数类型，参数按类型转换后传入
+type ArgType int
+
+//远程函数参数类型
+const (
+	ArgAny    ArgType = iota //原值，数据库中的值会去掉指针
+	ArgBool                  //bool
+	ArgNumber                //float64
+	ArgString                //string
+	ArgObject                //map[string]interface{}，字符串按 JSON 解析
+	ArgArray                 //[]interface{}，字符串按 JSON 解析
+)
+
+//Function 远程函数，如 "isPraised()": "isContain(praiseUserIdList,/User/id)"
+//参数为当前行或当前对象的 key，以及引用路径，引用路径与 "key@" 的格式相同
+type Function struct {
+	Args []ArgType                                                         //参数类型
+	Call func(ctx context.Context, args ...interface{}) (interface{}, error) //函数实现
+}
+
+//内置的远程函数，其它函数由 Config.Functions 注册
+var builtinFunctions = map[string]*Function{
+	"isContain":    {Args: []ArgType{ArgArray, ArgAny}, Call: isContain},
+	"getFromArray": {Args: []ArgType{ArgArray, ArgNumber}, Call: getFromArray},
+}
+
+//按名称获取远程函数，Config.Functions 中的同名函数优先
+func (p *Parser) getFunction(name string) (*Function, bool) {
+	if fn, ok := p.config().Functions[name]; ok {
+		return fn, true
+	}
+
+	fn, ok := builtinFunctions[name]
+	return fn, ok
+}
+
+//是否远程函数的 key，如 "isPraised()"
+func isFunctionKey(key string) bool {
+	return len(key) > 2 && strings.HasSuffix(key, "()")
+}
+
+//对查询到的行执行远程函数，结果放入行中，key 去掉 "()"
+func (p *Parser) callFunctions(ctx context.Context, where *orderedmap.OrderedMap, row map[string]interface{},
+	index int, head, node *ParseTree) error {
+	if row == nil {
+		return nil
+	}
+
+	for _, key := range where.Keys() {
+		if !isFunctionKey(key) {
+			continue
+		}
+
+		tmp, _ := where.Get(key)
+		function, ok := tmp.(string)
+		if !ok {
+			return fmt.Errorf("remote function %s must be a string", key)
+		}
+
+		ret, err := p.callFunction(ctx, function, where, row, index, head, node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+
+		row[key[:len(key)-2]] = ret
+	}
+
+	return nil
+}
+
+//解析并调用函数 "name(arg0,arg1,...)"
+func (p *Parser) callFunction(ctx context.Context, function string, where *orderedmap.OrderedMap, row map[string]interface{},
+	index int, head, node *ParseTree) (interface{}, error) {
+	start := strings.Index(function, "(")
+	if start <= 0 || !strings.HasSuffix(function, ")") {
+		return nil, fmt.Errorf("remote function %s is invalid, must be name(key0,key1,...)", function)
+	}
+
+	name := strings.TrimSpace(function[:start])
+	fn, ok := p.getFunction(name)
+	if !ok {
+		return nil, fmt.Errorf("remote function %s is not registered", name)
+	}
+
+	var keys []string
+	if argStr := strings.TrimSpace(function[start+1 : len(function)-1]); argStr != "" {
+		keys = strings.Split(argStr, ",")
+	}
+
+	if len(keys) != len(fn.Args) {
+		return nil, fmt.Errorf("remote function %s needs %d arguments, got %d", name, len(fn.Args), len(keys))
+	}
+
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+
+		val, err := getFunctionArg(key, where, row, index, head, node)
+		if err != nil {
+			return nil, err
+		}
+
+		args[i], err = convertArg(val, fn.Args[i])
+		if err != nil {
+			return nil, fmt.Errorf("argument %s of %s: %w", key, name, err)
+		}
+	}
+
+	return fn.Call(ctx, args...)
+}
+
+//参数的值，包含 / 的为引用路径，否则先取当前行，再取当前对象
+func getFunctionArg(key string, where *orderedmap.OrderedMap, row map[string]interface{},
+	index int, head, node *ParseTree) (interface{}, error) {
+	if strings.Contains(key, "/") {
+		return associatedAssignment(key, index, head, node)
+	}
+
+	if val, ok := row[key]; ok {
+		return indirectValue(val), nil
+	}
+
+	if val, ok := where.Get(key); ok {
+		return val, nil
+	}
+
+	return nil, fmt.Errorf("argument %s is not found", key)
+}
+
+//按参数类型转换，数据库中的值多为字符串
+func convertArg(val interface{}, argType ArgType) (interface{}, error) {
+	val = indirectValue(val)
+	if val == nil || argType == ArgAny {
+		return val, nil
+	}
+
+	switch argType {
+	case ArgBool:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+
+		str, err := rowToString(val)
+		if err != nil {
+			return nil, err
+		}
+
+		return strconv.ParseBool(str)
+	case ArgNumber:
+		if f, ok := val.(float64); ok {
+			return f, nil
+		}
+
+		str, err := rowToString(val)
+		if err != nil {
+			return nil, err
+		}
+
+		return strconv.ParseFloat(str, 64)
+	case ArgString:
+		return rowToString(val)
+	case ArgObject:
+		switch v := val.(type) {
+		case map[string]interface{}:
+			return v, nil
+		case orderedmap.OrderedMap:
+			obj := map[string]interface{}{}
+			for _, k := range v.Keys() {
+				obj[k], _ = v.Get(k)
+			}
+			return obj, nil
+		}
+
+		str, err := rowToString(val)
+		if err != nil {
+			return nil, err
+		}
+
+		obj := map[string]interface{}{}
+		err = json.Unmarshal([]byte(str), &obj)
+		return obj, err
+	case ArgArray:
+		if arr, ok := val.([]interface{}); ok {
+			return arr, nil
+		}
+
+		return getJSONArray(val)
+	}
+
+	return nil, fmt.Errorf("argument type %d is invalid", argType)
+}
+
+//isContain(array,value) 数组是否包含值，数字按数值比较
+func isContain(_ context.Context, args ...interface{}) (interface{}, error) {
+	arr, _ := args[0].([]interface{})
+
+	for _, item := range arr {
+		if fmt.Sprint(item) == fmt.Sprint(args[1]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//getFromArray(array,position) 获取数组中指定位置的值，越界时为 nil
+func getFromArray(_ context.Context, args ...interface{}) (interface{}, error) {
+	arr, _ := args[0].([]interface{})
+	position, _ := args[1].(float64)
+
+	if i := int(position); i >= 0 && i < len(arr) {
+		return arr[i], nil
+	}
+
+	return nil, nil
+}
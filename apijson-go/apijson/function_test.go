@@ -0,0 +1,68 @@
+package apijson
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestFunctions(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.Functions = map[string]*Function{
+			"plus": {Args: []ArgType{ArgNumber, ArgNumber}, Call: func(_ context.Context, args ...interface{}) (interface{}, error) {
+				return args[0].(float64) + args[1].(float64), nil
+			}},
+			"quote": {Args: []ArgType{ArgString}, Call: func(_ context.Context, args ...interface{}) (interface{}, error) {
+				return "<" + args[0].(string) + ">", nil
+			}},
+			"fail": {Args: []ArgType{ArgAny}, Call: func(_ context.Context, args ...interface{}) (interface{}, error) {
+				return nil, errors.New("failed")
+			}},
+			//同名时替换内置函数
+			"getFromArray": {Args: []ArgType{ArgArray, ArgNumber}, Call: func(_ context.Context, args ...interface{}) (interface{}, error) {
+				return len(args[0].([]interface{})), nil
+			}},
+		}
+	})
+
+	//参数可以是当前行的字段、当前对象的值或引用路径
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"apijson_user": {"id": 82001}, "Moment": {"id": 12,
+		"isPraised()": "isContain(praiseUserIdList,apijson_user/id)", "sum()": "plus(id, userId)",
+		"name()": "quote(content)", "size()": "getFromArray(praiseUserIdList,id)"}}`)
+	moment := getObject(t, res, "Moment")
+	if moment["isPraised"] != true || moment["sum"] != float64(12+70793) || moment["size"] != float64(4) ||
+		moment["name"] != "<APIJSON, let interfaces and documents go to hell !>" {
+		t.Errorf("Moment = %v", moment)
+	}
+
+	//数组内每一行分别执行
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"Moment": {"@order": "id+", "@column": "id,userId,praiseUserIdList",
+		"praised()": "isContain(praiseUserIdList,userId)"}}}`)
+	want := []bool{true, true, false, false, false}
+
+	items := getList(t, res, "[]")
+	if len(items) != len(want) {
+		t.Fatalf("[] = %v", items)
+	}
+
+	for i, item := range items {
+		obj, _ := item.(map[string]interface{})
+		if moment := getObject(t, obj, "Moment"); moment["praised"] != want[i] {
+			t.Errorf("Moment %v praised = %v, want %v", moment["id"], moment["praised"], want[i])
+		}
+	}
+
+	for _, function := range []string{
+		`"f()": "sleep(id)"`,
+		`"f()": "plus(id)"`,
+		`"f()": "plus"`,
+		`"f()": 1`,
+		`"f()": "fail(id)"`,
+		`"f()": "plus(id,name)"`,
+		`"f()": "isContain(praiseUserIdList,/apijson_user/id)"`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, `+function+`}}`); err == nil {
+			t.Errorf("%s: want error", function)
+		}
+	}
+}
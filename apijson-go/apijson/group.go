@@ -0,0 +1,162 @@
+package apijson
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//分组
+const (
+	KeyGroup  = "@group"  //分组字段，如 "@group": "userId,id"
+	KeyHaving = "@having" //分组条件，如 "@having": {"count(id)>": 1} 或 "@having": "count(id)>1;max(id)>=100"
+)
+
+//@having 默认允许的聚合函数，只读，各引擎用 Config.AggregateFunctions 替换
+var aggregateFunctions = map[string]bool{
+	"COUNT": true,
+	"SUM":   true,
+	"AVG":   true,
+	"MAX":   true,
+	"MIN":   true,
+}
+
+//字段名，可以带表名，如 id、Moment.id
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+//函数或字段，如 count(*)、max(id)、count(distinct userId)、userId，函数必须在 functions 中，key 用于错误信息
+func functionQuote(key string, functions map[string]bool, expr string) (string, error) {
+	fn, distinct, arg, isFunc := splitAggregate(expr)
+	if !isFunc {
+		if !identifierRegexp.MatchString(arg) {
+			return "", fmt.Errorf("%s field %s is invalid", key, expr)
+		}
+
+		return columnQuote(arg), nil
+	}
+
+	if !aliasRegexp.MatchString(fn) {
+		return "", fmt.Errorf("%s field %s is invalid", key, expr)
+	}
+
+	if !functions[fn] {
+		return "", fmt.Errorf("%s function %s is not allowed", key, fn)
+	}
+
+	if arg == "*" && fn == "COUNT" && distinct == "" {
+		return " COUNT(*) ", nil
+	}
+
+	if !identifierRegexp.MatchString(arg) {
+		return "", fmt.Errorf("%s argument %s of %s is invalid", key, arg, fn)
+	}
+
+	return fmt.Sprint(" ", fn, "(", distinct, columnQuote(arg), ") "), nil
+}
+
+//拆分聚合函数，返回大写的函数名、DISTINCT 和参数，不是函数时 arg 为字段本身
+func splitAggregate(expr string) (fn, distinct, arg string, isFunc bool) {
+	expr = strings.TrimSpace(expr)
+
+	start := strings.Index(expr, "(")
+	if start == -1 || !strings.HasSuffix(expr, ")") {
+		return "", "", expr, false
+	}
+
+	fn = strings.ToUpper(strings.TrimSpace(expr[:start]))
+	arg = strings.TrimSpace(expr[start+1 : len(expr)-1])
+
+	if len(arg) > 9 && strings.EqualFold(arg[:9], "DISTINCT ") {
+		distinct = "DISTINCT"
+		arg = strings.TrimSpace(arg[9:])
+	}
+
+	return fn, distinct, arg, true
+}
+
+//join 时函数的参数或字段加上表名前缀
+func scopeAggregate(table, expr string) string {
+	fn, distinct, arg, isFunc := splitAggregate(expr)
+	if !isFunc {
+		return table + "." + arg
+	}
+
+	if arg != "*" {
+		arg = table + "." + arg
+	}
+
+	if distinct != "" {
+		arg = distinct + " " + arg
+	}
+
+	return fn + "(" + arg + ")"
+}
+
+//@having 转为有序的条件，字符串格式 "count(id)>1;max(id)>=100" 的操作符转为 whereImplode 的后缀
+func getHavingMap(value interface{}) (*orderedmap.OrderedMap, error) {
+	switch v := value.(type) {
+	case orderedmap.OrderedMap:
+		return &v, nil
+	case *orderedmap.OrderedMap:
+		return v, nil
+	case string:
+		having := orderedmap.New()
+
+		for _, item := range strings.Split(v, ";") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+
+			//操作符在函数的右括号之后
+			index := strings.LastIndex(item, ")") + 1
+			opIndex := strings.IndexAny(item[index:], "<>!=")
+			if opIndex == -1 {
+				return nil, fmt.Errorf("%s %s has no operator", KeyHaving, item)
+			}
+
+			opIndex += index
+			expr := strings.TrimSpace(item[:opIndex])
+
+			var op, suffix string
+			for _, o := range []string{">=", "<=", "!=", ">", "<", "="} {
+				if strings.HasPrefix(item[opIndex:], o) {
+					op = o
+					break
+				}
+			}
+
+			switch op {
+			case "":
+				return nil, fmt.Errorf("%s %s has no operator", KeyHaving, item)
+			case "=":
+			case "!=":
+				suffix = "!"
+			default:
+				suffix = op
+			}
+
+			having.Set(expr+suffix, parseHavingValue(strings.TrimSpace(item[opIndex+len(op):])))
+		}
+
+		return having, nil
+	}
+
+	return nil, fmt.Errorf("%s must be an object or string", KeyHaving)
+}
+
+//字符串格式的值，数字转为数字，以免与聚合结果按字符串比较
+func parseHavingValue(value string) interface{} {
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+
+	return strings.Trim(value, `'"`)
+}
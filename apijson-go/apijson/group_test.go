@@ -0,0 +1,76 @@
+package apijson
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetHavingMap(t *testing.T) {
+	having, err := getHavingMap(" count(id)>1; max(id) >= 100;min(id)<=2.5;sum(id)!=0;avg(id)='a' ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		key   string
+		value interface{}
+	}{{"count(id)>", int64(1)}, {"max(id)>=", int64(100)}, {"min(id)<=", 2.5}, {"sum(id)!", int64(0)}, {"avg(id)", "a"}}
+
+	if keys := having.Keys(); len(keys) != len(want) {
+		t.Fatalf("keys = %v", keys)
+	}
+
+	for i, key := range having.Keys() {
+		value, _ := having.Get(key)
+		if key != want[i].key || value != want[i].value {
+			t.Errorf("%d: %s = %v, want %s = %v", i, key, value, want[i].key, want[i].value)
+		}
+	}
+
+	for _, value := range []interface{}{"count(id)", "count(id)~1", 1} {
+		if _, err := getHavingMap(value); err == nil {
+			t.Errorf("%v: want error", value)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//每个动态的评论数：470 有 3 条，12 有 1 条，其余 15、58、170、301 各 2 条
+	tests := []struct {
+		having string
+		want   []float64
+	}{
+		{`"count(id)>1"`, []float64{15, 58, 170, 301, 470}},
+		{`{"count(id)>": 2}`, []float64{470}},
+		{`"max(id)>=70;count(*)<3"`, []float64{12, 15, 58}},
+		{`{"count(distinct userId)": 3}`, []float64{470}},
+	}
+
+	for _, test := range tests {
+		res := mustParseSQLite(t, e, nil, MethodGet, fmt.Sprintf(`{"[]": {"count": 20, "Comment": {
+			"@column": "momentId:id,count(id):total", "@group": "momentId", "@having": %s, "@order": "momentId+"}}}`, test.having))
+
+		items := getList(t, res, "[]")
+		if ids := getIDs(t, items, "Comment"); !equalIDs(ids, test.want...) {
+			t.Errorf("%s: momentIds = %v, want %v", test.having, ids, test.want)
+		}
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Comment": {"momentId": 470, "@column": "momentId,count(id):total", "@group": "momentId"}}`)
+	if total := getObject(t, res, "Comment")["total"]; total != float64(3) {
+		t.Errorf("total = %v, want 3", total)
+	}
+
+	for _, where := range []string{
+		`"@group": "momentId", "@having": "sleep(id)>1"`,
+		`"@group": "momentId", "@having": {"count(id) OR 1=1": 1}`,
+		`"@group": "momentId;DROP TABLE Comment"`,
+		`"@group": "momentId", "@having": "count(id)"`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, `{"Comment": {"@column": "momentId", `+where+`}}`); err == nil {
+			t.Errorf("%s: want error", where)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package apijson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//HTTPConfig Handler 的配置
+type HTTPConfig struct {
+	MaxBodySize  int64         //请求体最大字节数，超过返回 413，0 为不限制
+	AllowOrigins []string      //允许跨域请求的 Origin，"*" 为所有来源，为空时不允许跨域
+	Gzip         bool          //客户端支持时用 gzip 压缩响应
+	Timeout      time.Duration //每个请求的处理超时，超时返回 504，0 为不限制
+
+	//Visitor 按请求获取访问者，如解析 Cookie 或 Authorization 中的登录信息，为空时都是未登录的访问者，
+	//返回错误时不处理请求，如 NewNotLoggedInError 返回 401
+	Visitor func(r *http.Request) (*Visitor, error)
+}
+
+//响应小于该字节数时不压缩
+const gzipMinSize = 1024
+
+//Handler 返回处理 APIJSON 请求的 http.Handler，可以挂载到任意路由下，如
+//http.Handle("/api/", http.StripPrefix("/api", engine.Handler()))
+//请求方法由路径指定，如 POST /get、/head、/gets、/heads、/post、/put、/delete，根路径为 get，
+//请求体为 JSON，响应为 JSON，出错时状态码由错误码决定
+func (e *Engine) Handler() http.Handler {
+	return &handler{engine: e, config: e.Config().HTTP}
+}
+
+type handler struct {
+	engine *Engine
+	config HTTPConfig
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.cors(w, r)
+
+	//跨域的预检请求
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	method := RequestMethod(strings.ToLower(strings.Trim(r.URL.Path, "/")))
+	if method == "" {
+		method = MethodGet
+	}
+
+	if !method.IsValid() {
+		h.writeError(w, r, NewNotExistError("request method %s does not exist", method))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST, OPTIONS")
+		h.writeError(w, r, newError(http.StatusMethodNotAllowed, "http method %s is not allowed, use POST", r.Method))
+		return
+	}
+
+	reqbody, err := h.readBody(r)
+	if err != nil {
+		h.writeError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	if h.config.Visitor != nil {
+		visitor, err := h.config.Visitor(r)
+		if err != nil {
+			h.writeError(w, r, err)
+			return
+		}
+
+		ctx = WithVisitor(ctx, visitor)
+	}
+
+	if h.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.config.Timeout)
+		defer cancel()
+	}
+
+	out, err := h.engine.Parse(ctx, method, reqbody)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = newError(http.StatusGatewayTimeout, "request timeout after %s", h.config.Timeout)
+		}
+
+		h.writeError(w, r, err)
+		return
+	}
+
+	h.write(w, r, http.StatusOK, out)
+}
+
+//读取请求体，超过 MaxBodySize 时返回 413 错误
+func (h *handler) readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	if h.config.MaxBodySize > 0 {
+		body = io.LimitReader(r.Body, h.config.MaxBodySize+1)
+	}
+
+	reqbody, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, newError(http.StatusBadRequest, "read body error: %v", err)
+	}
+
+	if h.config.MaxBodySize > 0 && int64(len(reqbody)) > h.config.MaxBodySize {
+		return nil, newError(http.StatusRequestEntityTooLarge, "request body is larger than %d bytes", h.config.MaxBodySize)
+	}
+
+	return reqbody, nil
+}
+
+//Origin 在 AllowOrigins 中时设置跨域响应头，否则不设置，由浏览器拦截跨域请求，同源请求不受影响
+func (h *handler) cors(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+
+	allowed := false
+	for _, o := range h.config.AllowOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Max-Age", "86400")
+	}
+}
+
+func (h *handler) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	body, status := ErrorResponse(err)
+	h.write(w, r, status, body)
+}
+
+//写入 JSON 响应，客户端支持且响应足够大时 gzip 压缩
+func (h *handler) write(w http.ResponseWriter, r *http.Request, status int, body []byte) {
+	header := w.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if h.config.Gzip {
+		header.Add("Vary", "Accept-Encoding")
+
+		if len(body) >= gzipMinSize && acceptGzip(r) {
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write(body)
+			if zw.Close() == nil {
+				header.Set("Content-Encoding", "gzip")
+				body = buf.Bytes()
+			}
+		}
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+//Accept-Encoding 中是否有 gzip 且没有 q=0
+func acceptGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(encoding, ";")
+		if strings.TrimSpace(params[0]) != "gzip" {
+			continue
+		}
+
+		for _, param := range params[1:] {
+			param = strings.ReplaceAll(param, " ", "")
+			if param == "q=0" || param == "q=0.0" || param == "q=0.00" || param == "q=0.000" {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,149 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//按数组请求体生成 join 语句，第一个表为主表
+func joinSQL(t *testing.T, e *Engine, dialect Dialect, body string) (string, error) {
+	db, err := e.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := orderedmap.New()
+	if err = json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+
+	joinTmp, _ := req.Get("join")
+	joins, err := getJoins(joinTmp)
+	if err != nil {
+		return "", err
+	}
+
+	req.Delete("join")
+	table := req.Keys()[0]
+	where, _ := getSubMap(req, table)
+
+	head := ParseTree{}
+	node := &ParseTree{Key: table, Parent: &ParseTree{Key: "[]", IsArray: true, Joins: joins}}
+
+	p := &Parser{Method: MethodGet, DB: db, Config: &e.config}
+	statement, err := p.genJoinStatement(context.Background(), req, table, where, 0, &head, node)
+	if err != nil {
+		return "", err
+	}
+
+	return CreateFindSQL(statement.SetDialect(dialect))
+}
+
+func TestJoinSQL(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		name  string
+		body  string
+		mysql string
+		pg    string
+	}{
+		{"inner", `{"join": "&/apijson_user/id@", "Moment": {"@column": "id"}, "apijson_user": {"id@": "/Moment/userId", "@column": "name"}}`,
+			"SELECT `Moment`.`id` AS `Moment.id`,`apijson_user`.`name` AS `apijson_user.name` FROM `Moment` INNER JOIN `apijson_user` ON  `Moment`.`userId` =`apijson_user`.`id`  ",
+			`SELECT "Moment"."id" AS "Moment.id","apijson_user"."name" AS "apijson_user.name" FROM "Moment" INNER JOIN "apijson_user" ON  "Moment"."userId" ="apijson_user"."id"  `},
+		{"left", `{"join": "</Comment/momentId@", "Moment": {"id": 12, "@column": "id"}, "Comment": {"momentId@": "/Moment/id", "@column": "id"}}`,
+			"SELECT `Moment`.`id` AS `Moment.id`,`Comment`.`id` AS `Comment.id` FROM `Moment` LEFT JOIN `Comment` ON  `Moment`.`id` =`Comment`.`momentId`   WHERE  `Moment`.`id` = ? ",
+			`SELECT "Moment"."id" AS "Moment.id","Comment"."id" AS "Comment.id" FROM "Moment" LEFT JOIN "Comment" ON  "Moment"."id" ="Comment"."momentId"   WHERE  "Moment"."id" = $1 `},
+		//未指定 @column 时查询副表的所有字段
+		{"all columns", `{"join": "&/Comment/momentId@", "Moment": {"@column": "id"}, "Comment": {"momentId@": "/Moment/id"}}`,
+			"SELECT `Moment`.`id` AS `Moment.id`,`Comment`.`id` AS `Comment.id`,`Comment`.`toId` AS `Comment.toId`,`Comment`.`userId` AS `Comment.userId`," +
+				"`Comment`.`momentId` AS `Comment.momentId`,`Comment`.`date` AS `Comment.date`,`Comment`.`content` AS `Comment.content` " +
+				"FROM `Moment` INNER JOIN `Comment` ON  `Moment`.`id` =`Comment`.`momentId`  ",
+			`SELECT "Moment"."id" AS "Moment.id","Comment"."id" AS "Comment.id","Comment"."toId" AS "Comment.toId","Comment"."userId" AS "Comment.userId",` +
+				`"Comment"."momentId" AS "Comment.momentId","Comment"."date" AS "Comment.date","Comment"."content" AS "Comment.content" ` +
+				`FROM "Moment" INNER JOIN "Comment" ON  "Moment"."id" ="Comment"."momentId"  `},
+		//"!" 为主表中没有关联副表的行
+		{"anti", `{"join": "!/Comment/momentId@", "Moment": {"@column": "id"}, "Comment": {"momentId@": "/Moment/id", "@column": "id"}}`,
+			"SELECT `Moment`.`id` AS `Moment.id`,`Comment`.`id` AS `Comment.id` FROM `Moment` LEFT JOIN `Comment` ON  `Moment`.`id` =`Comment`.`momentId`   WHERE  `Comment`.`momentId` IS  NULL ",
+			`SELECT "Moment"."id" AS "Moment.id","Comment"."id" AS "Comment.id" FROM "Moment" LEFT JOIN "Comment" ON  "Moment"."id" ="Comment"."momentId"   WHERE  "Comment"."momentId" IS  NULL `},
+	}
+
+	for _, test := range tests {
+		for _, d := range []struct {
+			dialect Dialect
+			want    string
+		}{{MySQLDialect{}, test.mysql}, {PostgresDialect{}, test.pg}} {
+			sql, err := joinSQL(t, e, d.dialect, test.body)
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+
+			if sql != d.want {
+				t.Errorf("%s %s:\n got: %s\nwant: %s", test.name, d.dialect.Name(), sql, d.want)
+			}
+		}
+	}
+
+	for _, body := range []string{
+		`{"join": "?/Comment/momentId@", "Moment": {}, "Comment": {"momentId@": "/Moment/id"}}`,
+		`{"join": "!/Comment/momentId@", "Moment": {}, "Comment": {"momentId@": "/Moment/id", "userId": 82001}}`,
+		`{"join": "&/Comment/momentId@", "Moment": {}, "Comment": {"momentId@": "/apijson_user/id"}}`,
+	} {
+		if _, err := joinSQL(t, e, MySQLDialect{}, body); err == nil {
+			t.Errorf("%s: want error", body)
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"join": "&/apijson_user/id@",
+		"Moment": {"@order": "id+"}, "apijson_user": {"id@": "/Moment/userId", "@column": "id,name"}}}`)
+
+	items := getList(t, res, "[]")
+	if ids := getIDs(t, items, "Moment"); !equalIDs(ids, 12, 15, 32, 58, 170) {
+		t.Errorf("Moment ids = %v", ids)
+	}
+
+	for _, item := range items {
+		obj, _ := item.(map[string]interface{})
+		if moment, user := getObject(t, obj, "Moment"), getObject(t, obj, "apijson_user"); moment["userId"] != user["id"] {
+			t.Errorf("Moment %v joined apijson_user %v", moment["id"], user["id"])
+		}
+	}
+
+	//没有评论的 Moment 32 副表字段为 null
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"count": 20, "join": "</Comment/momentId@",
+		"Moment": {"@column": "id", "@order": "id+"}, "Comment": {"momentId@": "/Moment/id", "@column": "id", "@order": "id+"}}}`)
+
+	items = getList(t, res, "[]")
+	if ids := getIDs(t, items, "Moment"); !equalIDs(ids, 12, 15, 15, 32, 58, 58, 170, 170) {
+		t.Errorf("Moment ids = %v", ids)
+	}
+
+	if ids := getIDs(t, items, "Comment"); ids[3] != nil || !equalIDs(append(ids[:3:3], ids[4:]...), 97, 68, 77, 13, 76, 44, 54) {
+		t.Errorf("Comment ids = %v", ids)
+	}
+
+	//只有 Moment 32 没有评论
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"join": "!/Comment/momentId@",
+		"Moment": {"@column": "id"}, "Comment": {"momentId@": "/Moment/id"}}}`)
+
+	if ids := getIDs(t, getList(t, res, "[]"), "Moment"); !equalIDs(ids, 32) {
+		t.Errorf("Moment ids = %v, want [32]", ids)
+	}
+
+	for _, body := range []string{
+		`{"[]": {"join": "?/Comment/momentId@", "Moment": {}, "Comment": {"momentId@": "/Moment/id"}}}`,
+		`{"[]": {"join": "!/Comment/momentId@", "Moment": {}, "Comment": {"momentId@": "/Moment/id", "userId": 82001}}}`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, body); err == nil {
+			t.Errorf("%s: want error", body)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package apijson
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRequestMethods(t *testing.T) {
+	e := newSQLiteEngine(t)
+	login := &Visitor{ID: int64(82001)}
+
+	//GETS、HEADS 需要 Request 表中的校验规则，tag 不是表名时为完整的请求结构
+	_, err := e.DB().ExecContext(context.Background(), `INSERT INTO Request (id, version, method, tag, structure) VALUES
+		(8, 1, 'GETS', 'Moment', '{"MUST": "userId"}'), (9, 1, 'HEADS', 'Moment', '{"MUST": "userId"}'),
+		(10, 1, 'GETS', 'moments', '{"[]": {"Moment": {"MUST": "userId"}}}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodHead, `{"Moment": {"userId": 70793}}`)
+	if count := getObject(t, res, "Moment")["count"]; count != float64(3) {
+		t.Errorf("head count = %v, want 3", count)
+	}
+
+	res = mustParseSQLite(t, e, login, MethodHeads, `{"Moment": {"userId": 70793}, "tag": "Moment", "@role": "LOGIN"}`)
+	if count := getObject(t, res, "Moment")["count"]; count != float64(3) {
+		t.Errorf("heads count = %v, want 3", count)
+	}
+
+	res = mustParseSQLite(t, e, login, MethodGets, `{"Moment": {"userId": 82002}, "tag": "Moment", "@role": "LOGIN"}`)
+	if id := getObject(t, res, "Moment")["id"]; id != float64(32) {
+		t.Errorf("gets id = %v, want 32", id)
+	}
+
+	res = mustParseSQLite(t, e, login, MethodGets, `{"[]": {"Moment": {"userId": 70793, "@order": "id+"}}, "tag": "moments", "@role": "LOGIN"}`)
+	if ids := getIDs(t, getList(t, res, "[]"), "Moment"); !equalIDs(ids, 12, 15, 170) {
+		t.Errorf("gets ids = %v, want [12, 15, 170]", ids)
+	}
+
+	for _, test := range []struct {
+		method  RequestMethod
+		visitor *Visitor
+		body    string
+	}{
+		{MethodHead, nil, `{"[]": {"Moment": {}}}`},
+		{MethodGets, login, `{"Moment": {"userId": 70793}}`},
+		{MethodHeads, login, `{"Moment": {"userId": 70793}}`},
+		{MethodGets, login, `{"Moment": {"id": 12}, "tag": "Moment", "@role": "LOGIN"}`},
+		{MethodGets, login, `{"[]": {"Moment": {"id": 12}}, "tag": "moments", "@role": "LOGIN"}`},
+		{MethodGets, nil, `{"Moment": {"userId": 70793}, "tag": "Moment", "@role": "LOGIN"}`},
+		{MethodGets, login, `{"Comment": {"id": 4}, "tag": "Comment", "@role": "LOGIN"}`},
+		{"patch", nil, `{"Moment": {"id": 12}}`},
+	} {
+		if _, err := parseSQLite(t, e, test.visitor, test.method, test.body); err == nil {
+			t.Errorf("%s %s: want error", test.method, test.body)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package apijson
+
+//RequestMethod 请求方法，对应 APIJSON 的 RequestMethod
+type RequestMethod string
+
+//请求方法
+const (
+	MethodGet    RequestMethod = "get"    //查询，可用浏览器调试
+	MethodHead   RequestMethod = "head"   //统计，返回 count
+	MethodGets   RequestMethod = "gets"   //安全/私密查询，需要 tag 校验
+	MethodHeads  RequestMethod = "heads"  //安全/私密统计，需要 tag 校验
+	MethodPost   RequestMethod = "post"   //新增
+	MethodPut    RequestMethod = "put"    //修改，只修改传入的字段
+	MethodDelete RequestMethod = "delete" //删除
+)
+
+//IsValid 是否合法的请求方法
+func (m RequestMethod) IsValid() bool {
+	switch m {
+	case MethodGet, MethodHead, MethodGets, MethodHeads, MethodPost, MethodPut, MethodDelete:
+		return true
+	}
+
+	return false
+}
+
+//IsGet 是否查询数据的方法 GET、GETS，只有这两个方法支持数组
+func (m RequestMethod) IsGet() bool {
+	return m == MethodGet || m == MethodGets
+}
+
+//IsHead 是否统计的方法 HEAD、HEADS
+func (m RequestMethod) IsHead() bool {
+	return m == MethodHead || m == MethodHeads
+}
+
+//IsBatch 是否支持批量操作 "Table[]": [{...}, {...}] 的方法 POST、PUT
+func (m RequestMethod) IsBatch() bool {
+	return m == MethodPost || m == MethodPut
+}
+
+//IsPublic 是否开放的请求方法 GET、HEAD，不需要 tag 校验请求结构
+func (m RequestMethod) IsPublic() bool {
+	return m == MethodGet || m == MethodHead
+}
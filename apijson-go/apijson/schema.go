@@ -0,0 +1,299 @@
+package apijson
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//Schema 数据库的表结构，从 information_schema 或方言对应的系统表加载，加载后只读，并发安全
+type Schema struct {
+	tables map[string]*TableSchema
+}
+
+//TableSchema 表结构
+type TableSchema struct {
+	Name    string
+	Columns []*ColumnSchema //按表中的顺序
+
+	columns map[string]*ColumnSchema
+}
+
+//ColumnSchema 字段结构
+type ColumnSchema struct {
+	Name     string
+	Type     string //数据库类型，小写，如 varchar、bigint
+	Nullable bool
+	Unique   bool  //单独的主键或唯一索引，值不重复
+	Codec    Codec //类型的编解码，Codec.Kind 用于转换请求中的值，未注册的类型为空，值不做转换
+}
+
+//LoadSchema 加载数据源所有表的结构
+func LoadSchema(ctx context.Context, c *Client) (*Schema, error) {
+	dialect := c.GetDialect()
+
+	var rows *sql.Rows
+	var err error
+
+	if c.Tx != nil {
+		rows, err = c.Tx.QueryContext(ctx, dialect.ColumnsSQL())
+	} else {
+		rows, err = c.Proxy.QueryContext(ctx, dialect.ColumnsSQL())
+	}
+
+	if err != nil {
+		return nil, dbError(err)
+	}
+
+	defer rows.Close()
+
+	s := &Schema{tables: map[string]*TableSchema{}}
+	for rows.Next() {
+		var table, column, dbType, nullable, unique string
+		if err = rows.Scan(&table, &column, &dbType, &nullable, &unique); err != nil {
+			return nil, dbError(err)
+		}
+
+		t := s.tables[table]
+		if t == nil {
+			t = &TableSchema{Name: table, columns: map[string]*ColumnSchema{}}
+			s.tables[table] = t
+		}
+
+		col := &ColumnSchema{
+			Name:     column,
+			Type:     normalizeDBType(dbType),
+			Nullable: strings.EqualFold(nullable, "YES"),
+			Unique:   strings.EqualFold(unique, "YES"),
+		}
+		col.Codec, _ = c.lookupCodec(col.Type)
+
+		t.Columns = append(t.Columns, col)
+		t.columns[column] = col
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	return s, nil
+}
+
+//Table 获取表结构，表不存在时返回 nil
+func (s *Schema) Table(name string) *TableSchema {
+	if s == nil {
+		return nil
+	}
+
+	return s.tables[name]
+}
+
+//Column 获取字段结构，字段不存在时返回 nil
+func (t *TableSchema) Column(name string) *ColumnSchema {
+	if t == nil {
+		return nil
+	}
+
+	return t.columns[name]
+}
+
+//校验请求中的表和字段是否存在，并把条件、新增或修改的值转为字段的类型，返回新的 where，请求中的 where 不会被修改
+//isValues 为 true 时 where 为新增或修改的值，数组会转为 JSON 字符串保存，不转换元素
+func (p *Parser) verifySchema(table string, where *orderedmap.OrderedMap, isValues bool) (*orderedmap.OrderedMap, error) {
+	if p.Schema == nil {
+		return where, nil
+	}
+
+	t := p.Schema.Table(table)
+	if t == nil {
+		return nil, fmt.Errorf("table %s does not exist", table)
+	}
+
+	newWhere := orderedmap.New()
+	for _, key := range where.Keys() {
+		val, _ := where.Get(key)
+
+		newVal, err := verifySchemaKey(t, where, key, val, isValues, p.DB.Time, p.config().ColumnFunctions)
+		if err != nil {
+			return nil, err
+		}
+
+		newWhere.Set(key, newVal)
+	}
+
+	return newWhere, nil
+}
+
+func verifySchemaKey(t *TableSchema, where *orderedmap.OrderedMap, key string,
+	val interface{}, isValues bool, tc TimeConfig, functions map[string]bool) (interface{}, error) {
+	switch key {
+	case KeyColumn:
+		column, _ := val.(string)
+		columns, err := ParseColumns(column, functions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range columns {
+			//函数只校验参数，如 count(*)、max(id)
+			expr := c.Expr
+			if _, _, arg, isFunc := splitAggregate(expr); isFunc {
+				if arg == "*" {
+					continue
+				}
+
+				expr = arg
+			}
+
+			if err = verifySchemaColumn(t, expr); err != nil {
+				return nil, err
+			}
+		}
+
+		return val, nil
+	case "@order", KeyGroup:
+		fields, _ := val.(string)
+		aliases := columnAliases(where, functions)
+
+		//每一项为 "字段+"、"字段-" 或字段，字段必须在表中或是 @column 中的别名
+		for _, field := range strings.Split(fields, ",") {
+			if strings.TrimSpace(field) == "" {
+				continue
+			}
+
+			column := strings.TrimSpace(field)
+			if key == "@order" {
+				column, _ = splitOrderField(field)
+			}
+
+			if aliases[column] {
+				continue
+			}
+
+			if err := verifySchemaColumn(t, column); err != nil {
+				return nil, err
+			}
+		}
+
+		return val, nil
+	}
+
+	//其它关键词和远程函数不是字段
+	if key == "" || key[0] == '@' || isFunctionKey(key) {
+		return val, nil
+	}
+
+	//EXISTS 子查询 "}{@"、"!}{@" 没有字段，子查询的表和字段单独校验
+	if key == "}{@" || key == "!}{@" {
+		return val, nil
+	}
+
+	//引用和子查询在赋值后已是数据库中的值，只校验字段
+	name := strings.TrimSuffix(key, "@")
+	column, operator, _, _ := pregOperatorMatch(name)
+	if err := verifySchemaColumn(t, column); err != nil {
+		return nil, err
+	}
+
+	col := t.Column(column)
+	if col == nil || name != key {
+		return val, nil
+	}
+
+	switch operator {
+	case "", "=", ">", ">=", "<", "<=":
+		if _, isArray := val.([]interface{}); isArray {
+			if isValues {
+				return val, nil
+			}
+
+			return convertSchemaValues(t, key, col, val, tc)
+		}
+
+		return convertSchemaValue(t, key, col, val, tc)
+	case "{}":
+		if _, isArray := val.([]interface{}); isArray {
+			return convertSchemaValues(t, key, col, val, tc)
+		}
+	}
+
+	return val, nil
+}
+
+//字段必须在表中，带表名前缀时表名必须是当前表，不是字段名时报错，防止 SQL 注入，如 "id` = 1 OR `id"
+func verifySchemaColumn(t *TableSchema, column string) error {
+	if !identifierRegexp.MatchString(column) {
+		return fmt.Errorf("column %s is invalid", column)
+	}
+
+	if index := strings.Index(column, "."); index != -1 {
+		if column[:index] != t.Name {
+			return fmt.Errorf("column %s is not in table %s", column, t.Name)
+		}
+
+		column = column[index+1:]
+	}
+
+	if t.Column(column) == nil {
+		return fmt.Errorf("column %s does not exist in table %s", column, t.Name)
+	}
+
+	return nil
+}
+
+//@column 中的别名，可以用于 @order
+func columnAliases(where *orderedmap.OrderedMap, functions map[string]bool) map[string]bool {
+	aliases := map[string]bool{}
+
+	tmp, _ := where.Get(KeyColumn)
+	column, _ := tmp.(string)
+	if column == "" {
+		return aliases
+	}
+
+	columns, _ := ParseColumns(column, functions)
+	for _, c := range columns {
+		if c.Alias != "" {
+			aliases[c.Alias] = true
+		}
+	}
+
+	return aliases
+}
+
+func convertSchemaValues(t *TableSchema, key string, col *ColumnSchema, val interface{}, tc TimeConfig) (interface{}, error) {
+	list := val.([]interface{})
+	newList := make([]interface{}, len(list))
+
+	for i, v := range list {
+		newVal, err := convertSchemaValue(t, key, col, v, tc)
+		if err != nil {
+			return nil, err
+		}
+
+		newList[i] = newVal
+	}
+
+	return newList, nil
+}
+
+//用字段的 Codec.Encode 把 JSON 中的值转为字段的类型，如 "12" 转为 int64 的 12，时间按 TimeConfig 解析，null 不转换
+func convertSchemaValue(t *TableSchema, key string, col *ColumnSchema, val interface{}, tc TimeConfig) (interface{}, error) {
+	if val == nil || col.Codec.Encode == nil {
+		return val, nil
+	}
+
+	if _, isObject := val.(orderedmap.OrderedMap); isObject {
+		return val, nil
+	}
+
+	newVal, err := col.Codec.Encode(val, tc)
+	if err != nil {
+		return nil, NewConditionError("%s of %s must be %s, got %v", key, t.Name, col.Type, val)
+	}
+
+	return newVal, nil
+}
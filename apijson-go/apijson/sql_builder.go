@@ -33,25 +33,33 @@ func findSQL(statement *Statement) (sql string) {
 	return
 }
 
-//CreateFindSQL 组装mysql 语句
+//CreateFindSQL 组装查询语句，按 statement 的方言转换
 func CreateFindSQL(statement *Statement) (sql string, err error) {
+	sql, err = buildFindSQL(statement)
+	if err != nil {
+		return "", err
+	}
+
+	return convertSQL(statement.GetDialect(), sql), nil
+}
+
+//组装 MySQL 语法的查询语句，子查询嵌入外层语句后再统一转换
+func buildFindSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
 
+	if statement.err != nil {
+		return "", statement.err
+	}
+
 	sql = findSQL(statement)
 
 	if len(statement.orders) > 0 {
 		sql = fmt.Sprint(sql, " ORDER BY ", statement.GetOrder())
 	}
 
-	if statement.limit >= 0 {
-		sql = fmt.Sprint(sql, " LIMIT ", statement.limit)
-	}
-
-	if statement.offset >= 0 {
-		sql = fmt.Sprint(sql, " OFFSET ", statement.offset)
-	}
+	sql = fmt.Sprint(sql, statement.GetDialect().Limit(statement.limit, statement.offset))
 
 	if statement.forupdate != "" {
 		sql = fmt.Sprint(sql, " ", statement.forupdate)
@@ -68,6 +76,19 @@ func CreateCountSQL(statement *Statement) (sql string, err error) {
 		return "", fmt.Errorf("orm: table empty")
 	}
 
+	if statement.err != nil {
+		return "", statement.err
+	}
+
+	//分组后统计分组的个数
+	if statement.groupby != "" {
+		group := *statement
+		if group.cselect == "*" {
+			group.cselect = statement.groupby
+		}
+		return convertSQL(statement.GetDialect(), fmt.Sprint("SELECT count(*) FROM (", findSQL(&group), ") AS `_group`")), nil
+	}
+
 	if statement.cselect == "*" {
 		statement.cselect = "count(*)"
 	} else {
@@ -86,7 +107,7 @@ func CreateCountSQL(statement *Statement) (sql string, err error) {
 		}
 	}
 
-	sql = findSQL(statement)
+	sql = convertSQL(statement.GetDialect(), findSQL(statement))
 	return
 }
 
@@ -95,26 +116,40 @@ func CreateInsertSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
-	sql = fmt.Sprint("INSERT INTO `", statement.tablename, "` ", statement.cset)
-	return sql, nil
+
+	if statement.err != nil {
+		return "", statement.err
+	}
+	sql = fmt.Sprint("INSERT INTO `", statement.tablename, "` ", statement.cset, statement.GetDialect().ReturnID())
+	return convertSQL(statement.GetDialect(), sql), nil
 }
 
-//CreateReplaceSQL 创建 replace 语句
+//CreateReplaceSQL 创建 replace 语句，PostgreSQL 为 ON CONFLICT DO UPDATE
 func CreateReplaceSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
-	sql = fmt.Sprint("REPLACE INTO `", statement.tablename, "` ", statement.cset)
-	return sql, nil
+
+	if statement.err != nil {
+		return "", statement.err
+	}
+	dialect := statement.GetDialect()
+	sql = dialect.Replace(statement.tablename, statement.cset, statement.columns)
+	return convertSQL(dialect, sql), nil
 }
 
-//CreateInsertIgnoreSQL 创建 insert ignore 语句
+//CreateInsertIgnoreSQL 创建 insert ignore 语句，PostgreSQL 为 ON CONFLICT DO NOTHING
 func CreateInsertIgnoreSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
-	sql = fmt.Sprint("INSERT IGNORE INTO `", statement.tablename, "` ", statement.cset)
-	return sql, nil
+
+	if statement.err != nil {
+		return "", statement.err
+	}
+	dialect := statement.GetDialect()
+	sql = dialect.InsertIgnore(statement.tablename, statement.cset)
+	return convertSQL(dialect, sql), nil
 }
 
 //CreateInsertOnDuplicateKeyUpdateSQL 创建 INSERT INTO **** ON DUPLICATE KEY UPDATE 语句
@@ -122,26 +157,29 @@ func CreateInsertOnDuplicateKeyUpdateSQL(statement *Statement, updateKeys map[st
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
-	sql = fmt.Sprint("INSERT INTO `", statement.tablename, "` ", statement.cset, " ON DUPLICATE KEY UPDATE ")
 
-	if len(updateKeys) != 0 {
-		i := 0
-		for k, v := range updateKeys {
-			if !strings.HasPrefix(v, "VALUES") && !strings.HasPrefix(v, "values") {
-				statement.params = append(statement.params, v)
-				v = "?"
-			}
+	if statement.err != nil {
+		return "", statement.err
+	}
+	dialect := statement.GetDialect()
 
-			if i == 0 {
-				sql = fmt.Sprint(sql, " `", k, "` = ", v)
-			} else {
-				sql = fmt.Sprint(sql, ", `", k, "` = ", v)
+	var updates []string
+	for k, v := range updateKeys {
+		if strings.HasPrefix(v, "VALUES") || strings.HasPrefix(v, "values") {
+			//VALUES(`column`) 为冲突时新数据的字段，按方言转换，其他表达式原样使用
+			if column := strings.Trim(v[len("VALUES"):], " ()`"); identifierRegexp.MatchString(column) {
+				v = dialect.Excluded(column)
 			}
-			i++
+		} else {
+			statement.params = append(statement.params, v)
+			v = "?"
 		}
+
+		updates = append(updates, fmt.Sprint("`", k, "` = ", v))
 	}
 
-	return sql, nil
+	sql = dialect.Upsert(statement.tablename, statement.cset, updates)
+	return convertSQL(dialect, sql), nil
 }
 
 //CreateUpdateSQL 创建 update 语句
@@ -149,20 +187,25 @@ func CreateUpdateSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
+
+	if statement.err != nil {
+		return "", statement.err
+	}
+	dialect := statement.GetDialect()
 	sql = fmt.Sprint("UPDATE `", statement.tablename, "` SET ", statement.cset)
 	if statement.condition != "" {
 		sql = fmt.Sprint(sql, " WHERE ", statement.condition)
 	}
+	if len(statement.orders) > 0 || statement.limit >= 0 || statement.offset >= 0 {
+		if !dialect.LimitUpdate() {
+			return "", fmt.Errorf("orm: %s does not support ORDER BY or LIMIT in UPDATE", dialect.Name())
+		}
+	}
 	if len(statement.orders) > 0 {
 		sql = fmt.Sprint(sql, " ORDER BY ", statement.GetOrder())
 	}
-	if statement.limit >= 0 {
-		sql = fmt.Sprint(sql, " LIMIT ", statement.limit)
-	}
-	if statement.offset >= 0 {
-		sql = fmt.Sprint(sql, " OFFSET ", statement.offset)
-	}
-	return sql, nil
+	sql = fmt.Sprint(sql, dialect.Limit(statement.limit, statement.offset))
+	return convertSQL(dialect, sql), nil
 }
 
 //CreateDeleteSQL 创建 delete 语句
@@ -170,18 +213,23 @@ func CreateDeleteSQL(statement *Statement) (sql string, err error) {
 	if statement.tablename == "" {
 		return "", fmt.Errorf("orm: table empty")
 	}
+
+	if statement.err != nil {
+		return "", statement.err
+	}
+	dialect := statement.GetDialect()
 	sql = fmt.Sprint("DELETE FROM `", statement.tablename, "` ")
 	if statement.condition != "" {
 		sql = fmt.Sprint(sql, " WHERE ", statement.condition)
 	}
+	if len(statement.orders) > 0 || statement.limit >= 0 || statement.offset >= 0 {
+		if !dialect.LimitUpdate() {
+			return "", fmt.Errorf("orm: %s does not support ORDER BY or LIMIT in DELETE", dialect.Name())
+		}
+	}
 	if len(statement.orders) > 0 {
 		sql = fmt.Sprint(sql, " ORDER BY ", statement.GetOrder())
 	}
-	if statement.limit >= 0 {
-		sql = fmt.Sprint(sql, " LIMIT ", statement.limit)
-	}
-	if statement.offset >= 0 {
-		sql = fmt.Sprint(sql, " OFFSET ", statement.offset)
-	}
-	return sql, nil
+	sql = fmt.Sprint(sql, dialect.Limit(statement.limit, statement.offset))
+	return convertSQL(dialect, sql), nil
 }
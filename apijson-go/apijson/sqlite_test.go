@@ -0,0 +1,714 @@
+package apijson
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+//带 regexp 函数的 SQLite 驱动，SQLite 的 REGEXP 操作符需要注册 regexp 函数
+const sqliteDriverName = "sqlite3_apijson"
+
+var registerSQLiteOnce sync.Once
+
+func registerSQLite() {
+	registerSQLiteOnce.Do(func() {
+		sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+			ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+				return conn.RegisterFunc("regexp", regexp.MatchString, true)
+			},
+		})
+	})
+}
+
+//创建临时文件数据库的引擎，并写入示例数据，configure 修改默认配置
+func newSQLiteEngine(t *testing.T, configure ...func(config *Config)) *Engine {
+	registerSQLite()
+
+	config := NewConfig(sqliteDriverName, "file:"+filepath.Join(t.TempDir(), "apijson.db")+"?_busy_timeout=5000")
+	config.Dialect = SQLiteDialect{}
+	for _, fn := range configure {
+		fn(&config)
+	}
+
+	e := newEngine(t, config)
+
+	err := SeedDemo(context.Background(), e.DB(), SQLiteDemoSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func newEngine(t *testing.T, config Config) *Engine {
+	e, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = e.Close()
+	})
+
+	return e
+}
+
+func parseSQLite(t *testing.T, e *Engine, visitor *Visitor,
+	method RequestMethod, body string) (map[string]interface{}, error) {
+	ctx := WithVisitor(context.Background(), visitor)
+	out, err := e.Parse(ctx, method, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var res map[string]interface{}
+	err = json.Unmarshal(out, &res)
+	if err != nil {
+		t.Fatalf("%s %s: invalid response %s: %v", method, body, out, err)
+	}
+
+	return res, nil
+}
+
+func mustParseSQLite(t *testing.T, e *Engine, visitor *Visitor,
+	method RequestMethod, body string) map[string]interface{} {
+	res, err := parseSQLite(t, e, visitor, method, body)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, body, err)
+	}
+
+	return res
+}
+
+func getObject(t *testing.T, res map[string]interface{}, key string) map[string]interface{} {
+	obj, ok := res[key].(map[string]interface{})
+	if !ok {
+		t.Fatalf("%s is not an object: %v", key, res)
+	}
+
+	return obj
+}
+
+func TestSQLiteGet(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{
+		"Moment": {"id": 12},
+		"apijson_user": {"id@": "Moment/userId", "@column": "id,name"},
+		"Comment[]": {"count": 10, "Comment": {"momentId@": "Moment/id"}}
+	}`)
+
+	if content := getObject(t, res, "Moment")["content"]; content != "APIJSON, let interfaces and documents go to hell !" {
+		t.Errorf("Moment content = %v", content)
+	}
+
+	if name := getObject(t, res, "apijson_user")["name"]; name != "Strong" {
+		t.Errorf("apijson_user name = %v", name)
+	}
+
+	comments, _ := res["Comment[]"].([]interface{})
+	if len(comments) != 1 {
+		t.Errorf("Comment[] = %v, want 1 comment", res["Comment[]"])
+	}
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{
+		"[]": {
+			"count": 3,
+			"Moment": {"content~": "^APIJSON", "@order": "id-"},
+			"apijson_user": {"id@": "/Moment/userId", "@column": "name"}
+		}
+	}`)
+
+	items, _ := res["[]"].([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("[] = %v, want 2 items", res["[]"])
+	}
+
+	first, _ := items[0].(map[string]interface{})
+	if id := getObject(t, first, "Moment")["id"]; id != float64(15) {
+		t.Errorf("first Moment id = %v, want 15", id)
+	}
+
+	if name := getObject(t, first, "apijson_user")["name"]; name != "Strong" {
+		t.Errorf("first apijson_user name = %v", name)
+	}
+}
+
+func TestSQLiteSubqueryRange(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	_, err := parseSQLite(t, e, nil, MethodGet, `{
+		"Moment": {"id>@": {"range": "ANY", "from": "Comment", "Comment": {"@column": "momentId"}}}
+	}`)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("error = %v, range ANY should not be supported by sqlite", err)
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{
+		"Moment": {"id@": {"from": "Comment", "Comment": {"@column": "momentId", "id": 97}}}
+	}`)
+
+	if id := getObject(t, res, "Moment")["id"]; id != float64(12) {
+		t.Errorf("Moment id = %v, want 12", id)
+	}
+}
+
+func TestSQLiteWrite(t *testing.T) {
+	e := newSQLiteEngine(t)
+	owner := &Visitor{ID: int64(38710)}
+
+	res := mustParseSQLite(t, e, owner, MethodPost, `{
+		"Moment": {"content": "local moment"}, "tag": "Moment", "@role": "OWNER"
+	}`)
+
+	id := getObject(t, res, "Moment")["id"]
+	if id == nil || id == float64(0) {
+		t.Fatalf("POST Moment returned no id: %v", res)
+	}
+
+	idJSON, _ := json.Marshal(id)
+
+	mustParseSQLite(t, e, owner, MethodPut, `{
+		"Moment": {"id": `+string(idJSON)+`, "content+": " updated"}, "tag": "Moment", "@role": "OWNER"
+	}`)
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": `+string(idJSON)+`}}`)
+	moment := getObject(t, res, "Moment")
+	if moment["content"] != "local moment updated" || moment["userId"] != float64(38710) {
+		t.Errorf("Moment = %v", moment)
+	}
+
+	//不是拥有者时 OWNER 的 userId 条件不匹配，不会删除
+	_, _ = parseSQLite(t, e, &Visitor{ID: int64(70793)}, MethodDelete, `{
+		"Moment": {"id": `+string(idJSON)+`}, "tag": "Moment", "@role": "OWNER"
+	}`)
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": `+string(idJSON)+`}}`)
+	if res["Moment"] == nil {
+		t.Fatal("Moment deleted by another user")
+	}
+
+	mustParseSQLite(t, e, owner, MethodDelete, `{
+		"Moment": {"id": `+string(idJSON)+`}, "tag": "Moment", "@role": "OWNER"
+	}`)
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": `+string(idJSON)+`}}`)
+	if res["Moment"] != nil {
+		t.Errorf("Moment = %v, want deleted", res["Moment"])
+	}
+}
+
+func TestSQLiteVerifyError(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	_, err := parseSQLite(t, e, &Visitor{ID: int64(38710)}, MethodPost, `{
+		"Moment": {"userId": 1}, "tag": "Moment", "@role": "OWNER"
+	}`)
+
+	var e2 *Error
+	if !errors.As(err, &e2) || e2.Code != CodeConditionError {
+		t.Errorf("error = %v, want condition error", err)
+	}
+}
+
+func TestSQLiteSchema(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	s, err := e.Schema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c := s.Table("Moment").Column("userId"); c == nil || c.Type != "bigint" || c.Nullable || c.Codec.Kind != "int" {
+		t.Errorf("Moment.userId = %+v", c)
+	}
+
+	for _, body := range []string{
+		`{"Moment_x": {"id": 12}}`,
+		`{"Moment": {"idx": 12}}`,
+		`{"Moment": {"id": 12, "@column": "id,contents"}}`,
+		`{"Moment[]": {"Moment": {"@order": "dates-"}}}`,
+	} {
+		_, err = parseSQLite(t, e, nil, MethodGet, body)
+		if err == nil || !strings.Contains(err.Error(), "does not exist") {
+			t.Errorf("%s: error = %v, want does not exist", body, err)
+		}
+	}
+
+	//字符串转为字段的类型
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": "12", "@column": "id,content:c", "@order": "c+"}}`)
+	if id := getObject(t, res, "Moment")["id"]; id != float64(12) {
+		t.Errorf("Moment id = %v, want 12", id)
+	}
+
+	//EXISTS 子查询没有字段，子查询中的字段同样校验
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 32, "!}{@": {"from": "Comment", "Comment": {"momentId": 32}}}}`)
+	if id := getObject(t, res, "Moment")["id"]; id != float64(32) {
+		t.Errorf("Moment id = %v, want 32", id)
+	}
+
+	_, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"}{@": {"from": "Comment", "Comment": {"momentIds": 32}}}}`)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("error = %v, want does not exist", err)
+	}
+
+	_, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": "abc"}}`)
+	var e2 *Error
+	if !errors.As(err, &e2) || e2.Code != CodeConditionError {
+		t.Errorf("error = %v, want condition error", err)
+	}
+
+	//表结构变更后刷新
+	_, err = e.DB().Exec("ALTER TABLE Moment ADD COLUMN title VARCHAR(50)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = parseSQLite(t, e, nil, MethodGet, `{"Moment": {"title": "t"}}`)
+	if err == nil {
+		t.Error("title should not exist before refresh")
+	}
+
+	_, err = e.RefreshSchema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"title": null, "id": 15}}`)
+	if getObject(t, res, "Moment")["id"] != float64(15) {
+		t.Errorf("Moment = %v", res["Moment"])
+	}
+}
+
+func TestSQLiteInjection(t *testing.T) {
+	for _, introspect := range []bool{true, false} {
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.Introspect = introspect
+		})
+
+		for _, body := range []string{
+			"{\"Moment\": {\"id` = 999 OR 1=1 OR `id\": 1}}",
+			"{\"[]\": {\"Moment\": {\"id` = 999 OR 1=1 OR `id\": 1}}}",
+			`{"[]": {"Moment": {"@order": "(CASE WHEN 1=1 THEN id END)"}}}`,
+			`{"[]": {"Moment": {"@order": "id-,userId DESC"}}}`,
+			"{\"[]\": {\"Moment\": {\"@order\": \"id` DESC, `userId\"}}}",
+			`{"[]": {"Moment": {"@group": "userId) OR (1=1", "@column": "userId"}}}`,
+			"{\"Moment\": {\"id>\": 1, \"x` OR 1=1 OR `y{}\": [1], \"@combine\": \"id>\"}}",
+		} {
+			_, err := parseSQLite(t, e, nil, MethodGet, body)
+			if err == nil || ToError(err).HTTPStatus() != http.StatusBadRequest {
+				t.Errorf("introspect %v, %s: error = %v, want 400", introspect, body, err)
+			}
+		}
+
+		res := mustParseSQLite(t, e, nil, MethodGet, `{"[]": {"Moment": {"@order": "userId+,id-", "@column": "id,userId"}}}`)
+		if list, _ := res["[]"].([]interface{}); len(list) != 5 {
+			t.Errorf("introspect %v: [] = %v", introspect, res["[]"])
+		}
+	}
+}
+
+func TestSQLiteKeepNull(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	_, err := e.DB().Exec("INSERT INTO apijson_user (id, sex, name, tag) VALUES (90001, 1, 'Null', NULL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"apijson_user": {"id": 90001, "@column": "id,sex,name,tag"}}`
+	user := getObject(t, mustParseSQLite(t, e, nil, MethodGet, body), "apijson_user")
+	if tag, ok := user["tag"]; !ok || tag != nil {
+		t.Errorf("tag = %v, want null", user["tag"])
+	}
+
+	if user["sex"] != float64(1) || user["name"] != "Null" {
+		t.Errorf("apijson_user = %v", user)
+	}
+
+	//同一数据源的另一个引擎，关闭后 NULL 转为空字符串
+	config := e.Config()
+	config.KeepNull = false
+	legacy := newEngine(t, config)
+
+	user = getObject(t, mustParseSQLite(t, legacy, nil, MethodGet, body), "apijson_user")
+	if user["tag"] != "" || user["sex"] != float64(1) {
+		t.Errorf("apijson_user = %v, want empty tag", user)
+	}
+}
+
+func TestSQLiteCodecs(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	var err error
+	for _, query := range []string{
+		`CREATE TABLE Media (id INTEGER PRIMARY KEY, data BLOB, meta JSON, day DATE, at DATETIME,
+			price DECIMAL(10,2), flag BOOLEAN)`,
+		`INSERT INTO Media VALUES (1, x'0102ff', '{"size": [1, 2]}', '2020-01-02', '2020-01-02 03:04:05', 12.5, 1)`,
+		`INSERT INTO Access (id, name) VALUES (10, 'Media')`,
+	} {
+		if _, err = e.DB().Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = e.RefreshSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Media": {"id": 1}}`)
+	media := getObject(t, res, "Media")
+
+	want := map[string]interface{}{
+		"data":  "AQL/",
+		"day":   "2020-01-02",
+		"at":    "2020-01-02T03:04:05Z",
+		"price": 12.5,
+		"flag":  true,
+	}
+	for k, v := range want {
+		if media[k] != v {
+			t.Errorf("%s = %#v, want %#v", k, media[k], v)
+		}
+	}
+
+	//json 字段直接嵌入
+	meta := getObject(t, media, "meta")
+	if size, _ := meta["size"].([]interface{}); len(size) != 2 {
+		t.Errorf("meta = %v", media["meta"])
+	}
+
+	//定点数的条件可以用字符串，SQLite 按 NUMERIC 亲和性比较
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Media": {"price": "12.50", "@column": "id"}}`)
+	if id := getObject(t, res, "Media")["id"]; id != float64(1) {
+		t.Errorf("Media id = %v, want 1", id)
+	}
+
+	if _, err = parseSQLite(t, e, nil, MethodGet, `{"Media": {"price": "12.5x"}}`); err == nil {
+		t.Error("invalid decimal should fail")
+	}
+}
+
+//SQLite 中表达式没有声明类型，按驱动返回的值输出
+func TestSQLiteDriverValues(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"userId": 70793,
+		"@column": "count(*):total,max(id):maxId,avg(id):avgId,upper(content):content", "@group": "userId"}}`)
+
+	moment := getObject(t, res, "Moment")
+	want := map[string]interface{}{"total": float64(3), "maxId": float64(170), "avgId": float64(197) / 3}
+	for k, v := range want {
+		if moment[k] != v {
+			t.Errorf("%s = %#v, want %v", k, moment[k], v)
+		}
+	}
+
+	if _, ok := moment["content"].(string); !ok {
+		t.Errorf("content = %#v, want string", moment["content"])
+	}
+}
+
+func TestSQLiteTimeConfig(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.Time = TimeConfig{OutLocation: time.FixedZone("UTC+8", 8*3600)}
+	})
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 170, "@column": "id,date"}}`)
+	if date := getObject(t, res, "Moment")["date"]; date != "2017-02-02T03:14:31+08:00" {
+		t.Errorf("date = %v", date)
+	}
+
+	//请求中不带时区的时间按 OutLocation 解析，转为数据库的时区比较
+	for body, want := range map[string]interface{}{
+		`{"Moment": {"date<": "2017-02-02T03:15:00+08:00", "@column": "id"}}`: float64(170),
+		`{"Moment": {"date<": "2017-02-01T19:15:00Z", "@column": "id"}}`:      float64(170),
+		`{"Moment": {"date<": "2017-02-02 03:14:00", "@column": "id"}}`:       nil,
+	} {
+		res = mustParseSQLite(t, e, nil, MethodGet, body)
+		moment, _ := res["Moment"].(map[string]interface{})
+		if (moment == nil && want != nil) || (moment != nil && moment["id"] != want) {
+			t.Errorf("%s: Moment = %v, want id %v", body, res["Moment"], want)
+		}
+	}
+
+	config := e.Config()
+	config.Time.Format = TimeFormatEpochMillis
+
+	res = mustParseSQLite(t, newEngine(t, config), nil, MethodGet, `{"Moment": {"id": 170, "@column": "id,date"}}`)
+	want := float64(time.Date(2017, 2, 1, 19, 14, 31, 0, time.UTC).UnixNano() / int64(time.Millisecond))
+	if date := getObject(t, res, "Moment")["date"]; date != want {
+		t.Errorf("date = %v, want %v", date, want)
+	}
+
+	//只有 JSON 数字是毫秒时间戳，全是数字的字符串按 Format 解析
+	config.Time = TimeConfig{Format: "20060102"}
+	e = newEngine(t, config)
+	for _, body := range []string{
+		`{"Moment": {"date<": "20170202", "@column": "id"}}`,
+		`{"Moment": {"date<": 1486000000000, "@column": "id"}}`,
+	} {
+		if id := getObject(t, mustParseSQLite(t, e, nil, MethodGet, body), "Moment")["id"]; id != float64(170) {
+			t.Errorf("%s: id = %v, want 170", body, id)
+		}
+	}
+}
+
+func TestSQLiteConfig(t *testing.T) {
+	admin := newSQLiteEngine(t, func(config *Config) {
+		config.Functions = map[string]*Function{
+			"double": {Args: []ArgType{ArgNumber}, Call: func(_ context.Context, args ...interface{}) (interface{}, error) {
+				return args[0].(float64) * 2, nil
+			}},
+		}
+	})
+
+	//同一数据源的开放接口引擎，数量限制更小，没有 double 函数
+	config := admin.Config()
+	config.Functions = nil
+	config.Limits.MaxCount = 2
+	public := newEngine(t, config)
+
+	body := `{"Moment[]": {"count": 3, "Moment": {"@column": "id"}}}`
+	if _, err := parseSQLite(t, public, nil, MethodGet, body); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("error = %v, want out of range", err)
+	}
+
+	if moments, _ := mustParseSQLite(t, admin, nil, MethodGet, body)["Moment[]"].([]interface{}); len(moments) != 3 {
+		t.Errorf("Moment[] = %v, want 3 moments", moments)
+	}
+
+	body = `{"Moment": {"id": 12, "@column": "id", "twice()": "double(id)"}}`
+	if twice := getObject(t, mustParseSQLite(t, admin, nil, MethodGet, body), "Moment")["twice"]; twice != float64(24) {
+		t.Errorf("twice = %v, want 24", twice)
+	}
+
+	if _, err := parseSQLite(t, public, nil, MethodGet, body); err == nil || !strings.Contains(err.Error(), "not registered") {
+		t.Errorf("error = %v, want not registered", err)
+	}
+}
+
+func TestSQLiteSQLFunctions(t *testing.T) {
+	admin := newSQLiteEngine(t)
+
+	//开放接口引擎只允许 count，不影响同一进程中的其它引擎
+	config := admin.Config()
+	config.ColumnFunctions = map[string]bool{"count": true}
+	config.AggregateFunctions = map[string]bool{"COUNT": true}
+	public := newEngine(t, config)
+
+	//New 之后修改配置不影响引擎
+	config.ColumnFunctions["MAX"] = true
+	config.AggregateFunctions["MAX"] = true
+
+	for _, body := range []string{
+		`{"Moment": {"id": 12, "@column": "max(id):maxId"}}`,
+		`{"[]": {"Moment": {"@column": "userId", "@group": "userId", "@having": "max(id)>20"}}}`,
+	} {
+		mustParseSQLite(t, admin, nil, MethodGet, body)
+
+		if _, err := parseSQLite(t, public, nil, MethodGet, body); err == nil || !strings.Contains(err.Error(), "not allowed") {
+			t.Errorf("%s: error = %v, want not allowed", body, err)
+		}
+	}
+
+	body := `{"Moment": {"userId": 70793, "@column": "count(*):total", "@having": "count(id)>1", "@group": "userId"}}`
+	if total := getObject(t, mustParseSQLite(t, public, nil, MethodGet, body), "Moment")["total"]; total != float64(3) {
+		t.Errorf("total = %v, want 3", total)
+	}
+}
+
+func TestSQLiteConcurrentParse(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, err := parseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12}, "apijson_user": {"id@": "Moment/userId"}}`)
+			if err == nil && res["apijson_user"] == nil {
+				err = errors.New("apijson_user is empty")
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestSQLiteHandler(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.HTTP.MaxBodySize = 256
+		config.HTTP.AllowOrigins = []string{"https://apijson.cn"}
+	})
+	h := e.Handler()
+
+	cases := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+		check   func(t *testing.T, res *http.Response, body []byte)
+	}{
+		{"get", http.MethodPost, "/get", `{"Moment": {"id": 12}}`, nil, http.StatusOK,
+			func(t *testing.T, res *http.Response, body []byte) {
+				if !strings.Contains(string(body), `"id":12`) {
+					t.Errorf("body = %s", body)
+				}
+			}},
+		{"root is get", http.MethodPost, "/", `{"Moment": {"id": 12}}`, nil, http.StatusOK, nil},
+		{"not post", http.MethodGet, "/get", "", nil, http.StatusMethodNotAllowed,
+			func(t *testing.T, res *http.Response, body []byte) {
+				if res.Header.Get("Allow") != "POST, OPTIONS" {
+					t.Errorf("Allow = %s", res.Header.Get("Allow"))
+				}
+			}},
+		{"unknown method", http.MethodPost, "/select", `{}`, nil, http.StatusNotFound, nil},
+		{"invalid json", http.MethodPost, "/get", `{`, nil, http.StatusBadRequest, nil},
+		{"body too large", http.MethodPost, "/get", `{"Moment": {"content": "` + strings.Repeat("a", 256) + `"}}`, nil,
+			http.StatusRequestEntityTooLarge, nil},
+		{"illegal access", http.MethodPost, "/delete", `{"Moment": {"id": 12}, "tag": "Moment"}`, nil,
+			http.StatusForbidden, nil},
+		{"preflight", http.MethodOptions, "/get", "", map[string]string{"Origin": "https://apijson.cn"}, http.StatusNoContent,
+			func(t *testing.T, res *http.Response, body []byte) {
+				if res.Header.Get("Access-Control-Allow-Origin") != "https://apijson.cn" ||
+					res.Header.Get("Access-Control-Allow-Methods") == "" {
+					t.Errorf("header = %v", res.Header)
+				}
+			}},
+		{"origin not allowed", http.MethodPost, "/get", `{"Moment": {"id": 12}}`,
+			map[string]string{"Origin": "https://example.com"}, http.StatusOK,
+			func(t *testing.T, res *http.Response, body []byte) {
+				if res.Header.Get("Access-Control-Allow-Origin") != "" {
+					t.Errorf("header = %v", res.Header)
+				}
+			}},
+		{"gzip", http.MethodPost, "/get", `{"[]": {"count": 100, "Comment": {}}}`,
+			map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK,
+			func(t *testing.T, res *http.Response, body []byte) {
+				if res.Header.Get("Content-Encoding") != "gzip" {
+					t.Fatalf("header = %v", res.Header)
+				}
+
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				out, err := ioutil.ReadAll(zr)
+				if err != nil || !strings.Contains(string(out), `"momentId":470`) {
+					t.Errorf("body = %s, err = %v", out, err)
+				}
+			}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			for k, v := range c.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			res := w.Result()
+			if res.StatusCode != c.status {
+				t.Fatalf("status = %d, want %d, body = %s", res.StatusCode, c.status, w.Body.Bytes())
+			}
+
+			if c.status != http.StatusNoContent && !strings.HasPrefix(res.Header.Get("Content-Type"), "application/json") {
+				t.Errorf("Content-Type = %s", res.Header.Get("Content-Type"))
+			}
+
+			if c.check != nil {
+				c.check(t, res, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestSQLiteHandlerTimeout(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		config.HTTP.Timeout = time.Nanosecond
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/get", strings.NewReader(`{"Moment": {"id": 12}}`))
+	w := httptest.NewRecorder()
+	e.Handler().ServeHTTP(w, req)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.Bytes())
+	}
+}
+
+func TestSQLiteHandlerVisitor(t *testing.T) {
+	e := newSQLiteEngine(t, func(config *Config) {
+		//示例中用请求头传用户 id，实际应校验登录凭证
+		config.HTTP.Visitor = func(r *http.Request) (*Visitor, error) {
+			id, err := strconv.ParseInt(r.Header.Get("X-User-Id"), 10, 64)
+			if err != nil {
+				return nil, NewNotLoggedInError("not logged in")
+			}
+
+			return &Visitor{ID: id}, nil
+		}
+	})
+	h := e.Handler()
+
+	post := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/post",
+			strings.NewReader(`{"Moment": {"content": "handler moment"}, "tag": "Moment", "@role": "OWNER"}`))
+		if userID != "" {
+			req.Header.Set("X-User-Id", userID)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := post(""); w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.Bytes())
+	}
+
+	w := post("38710")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.Bytes())
+	}
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"content": "handler moment"}}`)
+	if userID := getObject(t, res, "Moment")["userId"]; userID != float64(38710) {
+		t.Errorf("userId = %v, want 38710", userID)
+	}
+}
@@ -4,6 +4,7 @@ package apijson
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -24,6 +25,8 @@ const (
 	OPLike     = "$"  // OPLike like语句
 	OPREG      = "~"  // OPREG 正则表达式
 	OPBetween  = "%"  // OPBetween 在某个区间
+	OPAdd      = "+"  // OPAdd 自增，仅用于 PUT
+	OPSub      = "-"  // OPSub 自减，仅用于 PUT
 )
 
 //WhereCond where 语句 map 声明
@@ -55,8 +58,15 @@ type Statement struct {
 	params    []interface{}
 	groupby   string
 	having    string
+	hparams   []interface{} //having 的参数，在 where 参数之后
 	distinct  bool
 	forupdate string
+	err       error    //组装语句时的错误，生成 SQL 时返回
+	columns   []string //插入的字段，用于 PostgreSQL 等方言的 REPLACE
+	dialect   Dialect  //SQL 方言，为空时为 MySQL
+
+	columnFunctions    map[string]bool //@column 允许的函数，为空时为默认的函数
+	aggregateFunctions map[string]bool //@having 允许的聚合函数，为空时为默认的函数
 }
 
 //NewDbStatement 创建一个数据库语句 Statement
@@ -64,9 +74,37 @@ func NewDbStatement() *Statement {
 	return &Statement{cselect: "*", distinct: false, limit: -1, offset: -1}
 }
 
-//GetParams 获取查询参数
+//SetDialect 设置 SQL 方言
+func (statement *Statement) SetDialect(dialect Dialect) *Statement {
+	statement.dialect = dialect
+	return statement
+}
+
+//SetFunctions 设置 @column 允许的函数和 @having 允许的聚合函数，为 nil 时为默认的函数
+func (statement *Statement) SetFunctions(column, aggregate map[string]bool) *Statement {
+	statement.columnFunctions = column
+	statement.aggregateFunctions = aggregate
+	return statement
+}
+
+//GetDialect 获取 SQL 方言，未设置时为 MySQL
+func (statement *Statement) GetDialect() Dialect {
+	if statement.dialect == nil {
+		return MySQLDialect{}
+	}
+
+	return statement.dialect
+}
+
+//GetParams 获取查询参数，having 的参数在最后
 func (statement *Statement) GetParams() []interface{} {
-	return statement.params
+	if len(statement.hparams) == 0 {
+		return statement.params
+	}
+
+	params := make([]interface{}, 0, len(statement.params)+len(statement.hparams))
+	params = append(params, statement.params...)
+	return append(params, statement.hparams...)
 }
 
 //GetSelect 获取select 字段
@@ -161,6 +199,7 @@ func (statement *Statement) setMap(attributes SetMap) *Statement {
 		} else {
 			fields = fmt.Sprint(fields, ",", columnQuote(key))
 		}
+		statement.columns = append(statement.columns, key)
 		if values == "" {
 			values = "?"
 		} else {
@@ -228,6 +267,7 @@ func (statement *Statement) setStruct(attributes interface{}, isInsert bool) *St
 			} else {
 				fields = fmt.Sprint(fields, ",", columnQuote(fs.tablecolumn))
 			}
+			statement.columns = append(statement.columns, fs.tablecolumn)
 
 			if values == "" {
 				values = "?"
@@ -323,6 +363,7 @@ func (statement *Statement) realInsertStructs(arrv reflect.Value, arrLen int) *S
 			} else {
 				fields = fmt.Sprint(fields, ",", columnQuote(fs.tablecolumn))
 			}
+			statement.columns = append(statement.columns, fs.tablecolumn)
 		}
 	}
 
@@ -391,7 +432,8 @@ func (statement *Statement) InsertStructs(attrs interface{}) *Statement {
 	return statement.realInsertStructs(arrv, arrLen)
 }
 
-//UpdateMap Update Map
+//UpdateMap Update Map，key 以 + 或 - 结尾时为自增、自减，如 "balance+": 10 为 `balance` = `balance` + 10，
+//"name+": "abc" 为 `name` = CONCAT(`name`, 'abc')，字符串拼接按方言生成，需要先 SetDialect
 func (statement *Statement) UpdateMap(attributes SetMap) *Statement {
 	if len(attributes) == 0 {
 		return statement
@@ -401,10 +443,21 @@ func (statement *Statement) UpdateMap(attributes SetMap) *Statement {
 	var params []interface{}
 	//update users set name=? where id=?
 	for key, value := range attributes {
-		if str == "" {
-			str = fmt.Sprint(columnQuote(key), "=?")
-		} else {
-			str = fmt.Sprint(str, ",", columnQuote(key), "=?")
+		if str != "" {
+			str = fmt.Sprint(str, ",")
+		}
+
+		column, operator, _, _ := pregOperatorMatch(key)
+		switch operator {
+		case OPAdd, OPSub:
+			column = columnQuote(column)
+			if _, isString := value.(string); isString && operator == OPAdd {
+				str = fmt.Sprint(str, column, "= ", statement.GetDialect().Concat(column, "?"))
+			} else {
+				str = fmt.Sprint(str, column, "=", column, operator, " ?")
+			}
+		default:
+			str = fmt.Sprint(str, columnQuote(key), "=?")
 		}
 		params = append(params, value)
 	}
@@ -475,19 +528,74 @@ func nowTime(_type string) interface{} {
 	return time.Now().Format("2006-01-02 15:04:05")
 }
 
-//Where 快捷 where 查询条件组装
+//Err 组装语句时的错误
+func (statement *Statement) Err() error {
+	return statement.err
+}
+
+//Where 快捷 where 查询条件组装，@combine 中的条件按其组合，其余条件 AND 连接
 func (statement *Statement) Where(where *orderedmap.OrderedMap) *Statement {
+	var combine *Combine
+	combined := map[string]bool{}
+
+	if tmp, ok := where.Get(KeyCombine); ok {
+		expr, _ := tmp.(string)
+
+		var err error
+		combine, err = ParseCombine(expr)
+		if err != nil {
+			statement.err = err
+			return statement
+		}
+
+		for _, key := range combine.Keys() {
+			combined[key] = true
+		}
+	}
+
 	for _, k := range where.Keys() {
 		value, ok := where.Get(k)
-		if k == "@column" {
+		if combined[k] || isFunctionKey(k) { //远程函数在查询后执行，不是条件
+			continue
+		}
+
+		if k == KeyCombine {
+			statement.whereCombine(combine, where)
+		} else if k == KeyColumn {
 			if ok {
-				statement.Select(value.(string))
+				column, _ := value.(string)
+				statement.selectColumns(column)
 			}
 		} else if k == "@order" {
-			if ok {
-				statement.Order(strings.Replace(strings.Replace(value.(string), "+", " ASC ", -1), "-", " DESC ", -1))
+			order, _ := value.(string)
+			statement.orderBy(order)
+		} else if k == KeyGroup {
+			group, _ := value.(string)
+			statement.groupBy(group)
+		} else if k == KeyHaving {
+			having, err := getHavingMap(value)
+			if err != nil {
+				statement.err = err
+				return statement
 			}
+
+			statement.havingMap(having)
+		} else if sub, isSub := value.(*Subquery); isSub {
+			cond, params, err := subqueryCondition(k, sub)
+			if err != nil {
+				statement.err = err
+				return statement
+			}
+
+			statement.whereCondition(cond, params)
+		} else if strings.HasPrefix(k, "@") { //其它自定义关键词，如远程函数的参数 "@position"，不是条件
+			continue
 		} else {
+			if err := verifyConditionKey(k); err != nil {
+				statement.err = err
+				return statement
+			}
+
 			whereImplode(k, value, &statement.condition, &statement.params, "AND")
 		}
 	}
@@ -495,6 +603,88 @@ func (statement *Statement) Where(where *orderedmap.OrderedMap) *Statement {
 	return statement
 }
 
+//AccessWhere 角色的条件，如 OWNER 的 userId = 访问者 id，不参与 @combine，整体加括号后 AND 连接到已有条件
+func (statement *Statement) AccessWhere(where *orderedmap.OrderedMap) *Statement {
+	if where == nil || len(where.Keys()) == 0 {
+		return statement
+	}
+
+	var cond string
+	var params []interface{}
+
+	for _, k := range where.Keys() {
+		if err := verifyConditionKey(k); err != nil {
+			statement.err = err
+			return statement
+		}
+
+		value, _ := where.Get(k)
+		whereImplode(k, value, &cond, &params, "AND")
+	}
+
+	statement.whereCondition(strings.TrimSpace(cond), params)
+	return statement
+}
+
+//条件的字段必须是字段名，可以带表名，防止 key 中的 SQL 注入，如 "id` = 1 OR `id"
+func verifyConditionKey(key string) error {
+	column, _, _, _ := pregOperatorMatch(key)
+	if !identifierRegexp.MatchString(column) {
+		return fmt.Errorf("condition key %s is invalid", key)
+	}
+
+	return nil
+}
+
+//解析 @column 作为查询字段
+func (statement *Statement) selectColumns(column string) {
+	columns, err := ParseColumns(column, statement.columnFunctions)
+	if err != nil {
+		statement.err = err
+		return
+	}
+
+	fields := make([]string, len(columns))
+	for i, c := range columns {
+		//没有别名的函数以原样的表达式作为字段名，如 length(name)，与 join 时一致
+		alias := c.Alias
+		if alias == "" && !identifierRegexp.MatchString(c.Expr) {
+			alias = c.Expr
+		}
+
+		fields[i], err = c.SQL("", alias)
+		if err != nil {
+			statement.err = err
+			return
+		}
+	}
+
+	statement.Select(strings.Join(fields, ","))
+}
+
+//按条件树组装，整体加括号后 AND 连接到已有条件
+func (statement *Statement) whereCombine(combine *Combine, where *orderedmap.OrderedMap) {
+	var params []interface{}
+	cond, err := combine.condition(where, &params)
+	if err != nil {
+		statement.err = err
+		return
+	}
+
+	statement.whereCondition(cond, params)
+}
+
+//条件加括号后 AND 连接到已有条件，参数按顺序加入
+func (statement *Statement) whereCondition(cond string, params []interface{}) {
+	if statement.condition == "" {
+		statement.condition = "(" + cond + ")"
+	} else {
+		statement.condition = statement.condition + " AND (" + cond + ")"
+	}
+
+	statement.params = append(statement.params, params...)
+}
+
 //Limit 组装mysql limit 条件，可以使用分页配合使用=
 func (statement *Statement) Limit(limit int32) *Statement {
 	if limit > 0 {
@@ -535,6 +725,59 @@ func (statement *Statement) Order(field string, desc ...bool) *Statement {
 	return statement
 }
 
+//排序，"@order": "date-,id+"，+ 为升序，- 为降序，字段只能是字段名或 @column 中的别名
+func (statement *Statement) orderBy(order string) {
+	for _, field := range strings.Split(order, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+
+		column, desc := splitOrderField(field)
+		if !identifierRegexp.MatchString(column) {
+			statement.err = fmt.Errorf("@order field %s is invalid", strings.TrimSpace(field))
+			return
+		}
+
+		statement.Order(strings.TrimSpace(columnQuote(column)), desc)
+	}
+}
+
+//拆分排序字段，如 "date-" 为 date 和降序
+func splitOrderField(field string) (column string, desc bool) {
+	field = strings.TrimSpace(field)
+
+	switch {
+	case strings.HasSuffix(field, "-"):
+		return strings.TrimSpace(field[:len(field)-1]), true
+	case strings.HasSuffix(field, "+"):
+		return strings.TrimSpace(field[:len(field)-1]), false
+	}
+
+	return field, false
+}
+
+//分组，"@group": "userId,id"
+func (statement *Statement) groupBy(group string) {
+	var columns []string
+
+	for _, field := range strings.Split(group, ",") {
+		field = strings.TrimSpace(field)
+		if !identifierRegexp.MatchString(field) {
+			statement.err = fmt.Errorf("%s field %s is invalid", KeyGroup, field)
+			return
+		}
+
+		columns = append(columns, strings.TrimSpace(columnQuote(field)))
+	}
+
+	//join 时各表的分组字段合并
+	if statement.groupby != "" {
+		columns = append([]string{statement.groupby}, columns...)
+	}
+
+	statement.groupby = strings.Join(columns, ",")
+}
+
 //GroupBy GROUP BY 分组 group by
 func (statement *Statement) GroupBy(group ...string) *Statement {
 	if len(group) == 1 {
@@ -546,20 +789,61 @@ func (statement *Statement) GroupBy(group ...string) *Statement {
 	return statement
 }
 
-//Having having语句
+//Having having语句，key 为聚合函数或字段加上 whereImplode 的操作符，如 "count(id)>": 1，按 key 排序组装
 func (statement *Statement) Having(having WhereCond) *Statement {
-	replyCondition := ""
-	var replyMap []interface{}
+	keys := make([]string, 0, len(having))
+	for key := range having {
+		keys = append(keys, key)
+	}
 
-	for key, value := range having {
-		whereImplode(key, value, &replyCondition, &replyMap, "AND")
+	sort.Strings(keys)
+
+	ordered := orderedmap.New()
+	for _, key := range keys {
+		ordered.Set(key, having[key])
+	}
+
+	return statement.havingMap(ordered)
+}
+
+//按顺序组装 having 条件，已有条件时 AND 连接
+func (statement *Statement) havingMap(having *orderedmap.OrderedMap) *Statement {
+	condition := ""
+	var params []interface{}
+
+	functions := statement.aggregateFunctions
+	if functions == nil {
+		functions = aggregateFunctions
+	}
+
+	for _, key := range having.Keys() {
+		value, _ := having.Get(key)
+
+		column, operator, orAnd, not := pregOperatorMatch(key)
+
+		expr, err := functionQuote(KeyHaving, functions, column)
+		if err != nil {
+			statement.err = err
+			return statement
+		}
+
+		l := len(condition)
+		implodeCondition(expr, operator, orAnd, not, value, &condition, &params, "AND")
+
+		if len(condition) == l {
+			statement.err = fmt.Errorf("%s key %s is invalid", KeyHaving, key)
+			return statement
+		}
 	}
 
-	replyCondition = strings.Replace(replyCondition, "( AND", "(", -1)
-	replyCondition = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(replyCondition), "AND"))
+	//join 时各表的 having 条件 AND 连接
+	if statement.having == "" {
+		statement.having = strings.TrimSpace(condition)
+	} else {
+		statement.having = statement.having + " AND " + strings.TrimSpace(condition)
+	}
 
-	statement.having = replyCondition
-	statement.params = append(statement.params, replyMap...)
+	statement.hparams = append(statement.hparams, params...)
 	return statement
 }
 
@@ -657,13 +941,21 @@ func (statement *Statement) realjoin(table string, joinDirect string, relation .
 //where条件
 func whereImplode(key string, value interface{}, replyCondition *string,
 	replyMap *[]interface{}, connector string) {
-	v := reflect.ValueOf(value)
-
 	column, operator, orAnd, not := pregOperatorMatch(key)
 
 	if column != "" {
 		column = columnQuote(column)
+	}
+
+	implodeCondition(column, operator, orAnd, not, value, replyCondition, replyMap, connector)
+}
+
+//按操作符组装条件，column 为已处理过的字段或表达式
+func implodeCondition(column, operator, orAnd, not string, value interface{},
+	replyCondition *string, replyMap *[]interface{}, connector string) {
+	v := reflect.ValueOf(value)
 
+	if column != "" {
 		switch operator {
 		case "", OPEqual:
 			if value == nil {
@@ -737,17 +1029,21 @@ func whereImplode(key string, value interface{}, replyCondition *string,
 	*replyCondition = strings.TrimLeft(*replyCondition, connector)
 }
 
-//列处理
+//列处理，字段中的反引号转义为两个反引号
 func columnQuote(str string) (tableColumn string) {
 	dotIndex := strings.Index(str, ".")
 	if dotIndex != -1 {
-		tableColumn = " `" + strings.TrimSpace(str[0:dotIndex]) + "`.`" + strings.TrimSpace(str[dotIndex+1:]) + "` "
+		tableColumn = " `" + escapeQuote(str[0:dotIndex]) + "`.`" + escapeQuote(str[dotIndex+1:]) + "` "
 	} else {
-		tableColumn = " `" + strings.TrimSpace(str) + "` "
+		tableColumn = " `" + escapeQuote(str) + "` "
 	}
 	return
 }
 
+func escapeQuote(identifier string) string {
+	return strings.Replace(strings.TrimSpace(identifier), "`", "``", -1)
+}
+
 //表别名
 func alias(src string) (table, alias string) {
 	start := strings.Index(src, "(")
@@ -856,12 +1152,12 @@ func pregOperatorMatch(key string) (column, operator, orAnd, not string) {
 	case "~":
 		switch last2 {
 		case "!~":
-			operator = "!~"
+			not = " NOT "
 			index = l - 2
 		default:
-			operator = "~"
 			index = l - 1
 		}
+		operator = "~"
 	case "%":
 		switch last2 {
 		case "|%":
@@ -1052,30 +1348,20 @@ type structSpec struct {
 	l  []*fieldSpec
 }
 
-var (
-	structSpecMutex sync.RWMutex
-	structSpecCache = make(map[reflect.Type]*structSpec)
-)
+//结构体字段的解析结果，按类型缓存，所有引擎共用，类型不变所以结果不变
+var structSpecCache sync.Map //reflect.Type -> *structSpec
 
 func structSpecForType(t reflect.Type) *structSpec {
-	structSpecMutex.RLock()
-	ss, found := structSpecCache[t]
-	structSpecMutex.RUnlock()
-	if found {
-		return ss
-	}
-
-	structSpecMutex.Lock()
-	defer structSpecMutex.Unlock()
-	ss, found = structSpecCache[t]
-	if found {
-		return ss
+	if ss, found := structSpecCache.Load(t); found {
+		return ss.(*structSpec)
 	}
 
-	ss = &structSpec{m: make(map[string]*fieldSpec), cm: make(map[string]*fieldSpec)}
+	ss := &structSpec{m: make(map[string]*fieldSpec), cm: make(map[string]*fieldSpec)}
 	compileStructSpec(t, make(map[string]int), nil, ss)
-	structSpecCache[t] = ss
-	return ss
+
+	//并发解析同一类型时只保留第一个结果
+	actual, _ := structSpecCache.LoadOrStore(t, ss)
+	return actual.(*structSpec)
 }
 
 func compileStructSpec(t reflect.Type, depth map[string]int,
@@ -0,0 +1,195 @@
+package apijson
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//子查询，如 "id{}@": {"from": "Comment", "Comment": {"@column": "momentId", "userId": 82001}}
+const (
+	KeySubqueryFrom  = "from"  //子查询的主表
+	KeySubqueryRange = "range" //比较子查询的范围 ANY、ALL
+	KeySubqueryCount = "count" //子查询的条数
+)
+
+//子查询范围
+const (
+	SubqueryRangeAny = "ANY"
+	SubqueryRangeAll = "ALL"
+)
+
+//是否子查询，"key@" 的值为对象
+func isSubquery(key string, val interface{}) bool {
+	if ok, _ := isAssociated(key); !ok {
+		return false
+	}
+
+	_, isObject := val.(orderedmap.OrderedMap)
+	return isObject
+}
+
+//将条件中的子查询编译为 *Statement，没有子查询时返回原条件
+func (p *Parser) subqueries(ctx context.Context, where *orderedmap.OrderedMap,
+	index int, head, node *ParseTree) (*orderedmap.OrderedMap, error) {
+	var newWhere *orderedmap.OrderedMap
+
+	for _, key := range where.Keys() {
+		if val, _ := where.Get(key); isSubquery(key, val) {
+			newWhere = orderedmap.New()
+			break
+		}
+	}
+
+	if newWhere == nil {
+		return where, nil
+	}
+
+	for _, key := range where.Keys() {
+		val, _ := where.Get(key)
+
+		if isSubquery(key, val) {
+			obj := val.(orderedmap.OrderedMap)
+
+			sub, err := p.genSubquery(ctx, &obj, index, head, node)
+			if err != nil {
+				return nil, fmt.Errorf("subquery %s: %w", key, err)
+			}
+
+			val = sub
+		}
+
+		newWhere.Set(key, val)
+	}
+
+	return newWhere, nil
+}
+
+//编译子查询，子查询只读，按 GET 校验主表的访问权限
+func (p *Parser) genSubquery(ctx context.Context, obj *orderedmap.OrderedMap,
+	index int, head, node *ParseTree) (*Subquery, error) {
+	tmp, _ := obj.Get(KeySubqueryFrom)
+	from, _ := tmp.(string)
+	if from == "" {
+		return nil, fmt.Errorf("%s is required", KeySubqueryFrom)
+	}
+
+	where, ok := getSubMap(obj, from)
+	if !ok {
+		return nil, fmt.Errorf("table %s in %s is not found", from, KeySubqueryFrom)
+	}
+
+	var subRange string
+	if tmp, ok := obj.Get(KeySubqueryRange); ok {
+		str, _ := tmp.(string)
+		subRange = strings.ToUpper(str)
+		if subRange != SubqueryRangeAny && subRange != SubqueryRangeAll {
+			return nil, fmt.Errorf("%s must be %s or %s", KeySubqueryRange, SubqueryRangeAny, SubqueryRangeAll)
+		}
+	}
+
+	//复制的 Parser 与外层共用 Access 表权限
+	if p.access == nil {
+		p.access = &accessRules{}
+	}
+
+	getter := *p
+	getter.Method = MethodGet
+
+	where, access, err := getter.verifyAccess(ctx, from, where)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := getter.genStatement(ctx, from, where, access, index, head, node)
+	if err != nil {
+		return nil, err
+	}
+
+	//引用的对象不存在，由外层查询置为 nil
+	if statement == nil {
+		return nil, NewNotExistError("associated data in %s is empty", from)
+	}
+
+	sub := &Subquery{Statement: statement, Range: subRange}
+	if tmp, ok := obj.Get(KeySubqueryCount); ok {
+		count, err := getNonNegativeInt(tmp)
+		if err != nil {
+			return nil, fmt.Errorf("%s %v", KeySubqueryCount, err)
+		}
+
+		if count > 0 {
+			sub.Count = count
+			statement.Limit(int32(count))
+		}
+	}
+
+	return sub, nil
+}
+
+//Subquery 编译后的子查询
+type Subquery struct {
+	Statement *Statement
+	Range     string //ANY、ALL，只用于比较
+	Count     int    //LIMIT 的条数，MySQL 的 IN 子查询不支持 LIMIT，只用于比较
+}
+
+//组装子查询条件，key 为 "id{}@"、"id!{}@"、"id@"、"id>@"、"id!@"、"}{@"、"!}{@" 等，返回条件和按顺序的参数
+func subqueryCondition(key string, sub *Subquery) (string, []interface{}, error) {
+	query, err := buildFindSQL(sub.Statement)
+	if err != nil {
+		return "", nil, err
+	}
+
+	params := sub.Statement.GetParams()
+	key = strings.TrimSuffix(key, "@")
+
+	//EXISTS，"}{" 前只能有 !
+	if strings.HasSuffix(key, "}{") {
+		switch strings.TrimSpace(key[:len(key)-2]) {
+		case "":
+			return fmt.Sprint("EXISTS (", query, ")"), params, nil
+		case OPNot:
+			return fmt.Sprint("NOT EXISTS (", query, ")"), params, nil
+		}
+
+		return "", nil, fmt.Errorf("subquery key %s@ is invalid", key)
+	}
+
+	column, operator, _, not := pregOperatorMatch(key)
+	if !identifierRegexp.MatchString(column) {
+		return "", nil, fmt.Errorf("subquery key %s@ is invalid", key)
+	}
+
+	column = strings.TrimSpace(columnQuote(column))
+
+	switch operator {
+	case OPIn:
+		if sub.Range != "" {
+			return "", nil, fmt.Errorf("subquery key %s@ does not support %s", key, KeySubqueryRange)
+		}
+
+		if sub.Count > 0 {
+			return "", nil, fmt.Errorf("subquery key %s@ does not support %s", key, KeySubqueryCount)
+		}
+
+		return fmt.Sprint(column, not, " IN (", query, ")"), params, nil
+	case "", OPEqual:
+		operator = OPEqual
+		if not != "" {
+			operator = "!="
+		}
+	case OPGt, OPGte, OPLt, OPLte:
+	default:
+		return "", nil, fmt.Errorf("subquery key %s@ is not supported", key)
+	}
+
+	if sub.Range != "" && !sub.Statement.GetDialect().AnyAll() {
+		return "", nil, fmt.Errorf("%s %s is not supported by %s", KeySubqueryRange, sub.Range,
+			sub.Statement.GetDialect().Name())
+	}
+
+	return fmt.Sprint(column, " ", operator, " ", sub.Range, "(", query, ")"), params, nil
+}
@@ -0,0 +1,159 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//按对象请求体生成带子查询的语句
+func subquerySQL(t *testing.T, e *Engine, dialect Dialect, table, body string) (string, error) {
+	db, err := e.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db.Dialect = dialect
+
+	where := orderedmap.New()
+	if err = json.Unmarshal([]byte(body), &where); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Parser{Method: MethodGet, DB: db, Config: &e.config}
+	where, err = p.subqueries(context.Background(), where, 0, &ParseTree{}, &ParseTree{})
+	if err != nil {
+		return "", err
+	}
+
+	statement := NewDbStatement().SetDialect(dialect)
+	statement.SetTableName(table)
+	statement.Where(where)
+
+	return CreateFindSQL(statement)
+}
+
+func TestSubquerySQL(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	tests := []struct {
+		name  string
+		body  string
+		mysql string
+		pg    string
+	}{
+		{"in", `{"id{}@": {"from": "Comment", "Comment": {"@column": "momentId", "userId": 93793}}}`,
+			"SELECT * FROM `Moment` WHERE (`id` IN (SELECT `momentId` FROM `Comment` WHERE  `userId` = ? ))",
+			`SELECT * FROM "Moment" WHERE ("id" IN (SELECT "momentId" FROM "Comment" WHERE  "userId" = $1 ))`},
+		//子查询的参数在外层条件之前
+		{"not exists", `{"!}{@": {"from": "Comment", "Comment": {"userId": 1}}, "userId": 70793}`,
+			"SELECT * FROM `Moment` WHERE (NOT EXISTS (SELECT * FROM `Comment` WHERE  `userId` = ? )) AND `userId` = ? ",
+			`SELECT * FROM "Moment" WHERE (NOT EXISTS (SELECT * FROM "Comment" WHERE  "userId" = $1 )) AND "userId" = $2 `},
+		{"range", `{"id>@": {"range": "ALL", "count": 2, "from": "Comment", "Comment": {"@column": "momentId"}}}`,
+			"SELECT * FROM `Moment` WHERE (`id` > ALL(SELECT `momentId` FROM `Comment` LIMIT 2))",
+			`SELECT * FROM "Moment" WHERE ("id" > ALL(SELECT "momentId" FROM "Comment" LIMIT 2))`},
+	}
+
+	for _, test := range tests {
+		for _, d := range []struct {
+			dialect Dialect
+			want    string
+		}{{MySQLDialect{}, test.mysql}, {PostgresDialect{}, test.pg}} {
+			sql, err := subquerySQL(t, e, d.dialect, "Moment", test.body)
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+
+			if sql != d.want {
+				t.Errorf("%s %s:\n got: %s\nwant: %s", test.name, d.dialect.Name(), sql, d.want)
+			}
+		}
+	}
+}
+
+func TestSubquery(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	//93793 评论了动态 15、58、301，82003 评论了 170、301
+	tests := []struct {
+		where string
+		want  []float64
+	}{
+		{`"id{}@": {"from": "Comment", "Comment": {"@column": "momentId", "userId": 93793}}`, []float64{15, 58}},
+		{`"id!{}@": {"from": "Comment", "Comment": {"@column": "momentId", "userId": 93793}}`, []float64{12, 32, 170}},
+		{`"id@": {"from": "Comment", "Comment": {"@column": "momentId", "id": 44}}`, []float64{170}},
+		{`"id>@": {"from": "Comment", "Comment": {"@column": "max(momentId)", "momentId<": 100}}`, []float64{170}},
+		{`"}{@": {"from": "Comment", "Comment": {"userId": 82006}}, "userId": 82002`, []float64{32}},
+		{`"!}{@": {"from": "Comment", "Comment": {"userId": 82006}}`, nil},
+		//子查询中引用其它对象的值
+		{`"id{}@": {"from": "Comment", "Comment": {"@column": "momentId", "userId@": "apijson_user/id"}}`, []float64{170}},
+	}
+
+	for _, test := range tests {
+		res, err := parseSQLite(t, e, nil, MethodGet, `{"apijson_user": {"id": 82003, "@column": "id"},
+			"[]": {"Moment": {`+test.where+`, "@order": "id+", "@column": "id"}}}`)
+		if err != nil {
+			t.Errorf("%s: %v", test.where, err)
+			continue
+		}
+
+		var ids []interface{}
+		if items, ok := res["[]"].([]interface{}); ok {
+			ids = getIDs(t, items, "Moment")
+		}
+
+		if !equalIDs(ids, test.want...) {
+			t.Errorf("%s: ids = %v, want %v", test.where, ids, test.want)
+		}
+	}
+
+	for _, where := range []string{
+		`"id{}@": {"Comment": {"@column": "momentId"}}`,
+		`"id{}@": {"from": "Comment", "Moment": {}}`,
+		`"id{}@": {"range": "ANY", "from": "Comment", "Comment": {"@column": "momentId"}}`,
+		`"id<>@": {"from": "Comment", "Comment": {"@column": "momentId"}}`,
+		`"id}{@": {"from": "Comment", "Comment": {}}`,
+		`"id{}@": {"from": "Comment", "count": -1, "Comment": {"@column": "momentId"}}`,
+		//MySQL 的 IN 子查询不支持 LIMIT
+		`"id{}@": {"from": "Comment", "count": 2, "Comment": {"@column": "momentId"}}`,
+		`"id!{}@": {"from": "Comment", "count": 1, "Comment": {"@column": "momentId", "userId": 93793}}`,
+	} {
+		if _, err := parseSQLite(t, e, nil, MethodGet, `{"Moment": {`+where+`}}`); err == nil {
+			t.Errorf("%s: want error", where)
+		}
+	}
+}
+
+//子查询与外层共用 Access 表权限，每次请求只加载一次
+func TestSubqueryAccess(t *testing.T) {
+	e := newSQLiteEngine(t)
+
+	db, err := e.Client()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	where := orderedmap.New()
+	if err = json.Unmarshal([]byte(`{"id{}@": {"from": "Comment", "Comment": {"@column": "momentId"}}}`), &where); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Parser{Method: MethodGet, DB: db, Config: &e.config}
+	if _, err = p.subqueries(context.Background(), where, 0, &ParseTree{}, &ParseTree{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.access == nil || p.access.roles["Comment"] == nil {
+		t.Fatalf("access = %+v, want loaded by subquery", p.access)
+	}
+
+	//已加载的权限不再查询 Access 表
+	p.access.roles["Comment"][MethodGet] = []RequestRole{RoleAdmin}
+	if _, err = p.subqueries(context.Background(), where, 0, &ParseTree{}, &ParseTree{}); !errors.Is(err, ErrIllegalAccess) {
+		t.Errorf("error = %v, want illegal access", err)
+	}
+}
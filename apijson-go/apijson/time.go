@@ -0,0 +1,113 @@
+package apijson
+
+import (
+	"fmt"
+	"time"
+)
+
+//时间的输出格式，其它值为 time 包的 layout，如 "2006-01-02 15:04:05"
+const (
+	TimeFormatRFC3339     = "RFC3339"     //RFC 3339，如 2006-01-02T15:04:05+08:00，默认格式
+	TimeFormatEpochMillis = "EpochMillis" //毫秒时间戳，输出为数字
+)
+
+const dateLayout = "2006-01-02"
+
+//数据库中不带时区的时间格式
+var dbTimeLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999 -0700 MST",
+	dateLayout,
+}
+
+//TimeConfig 时间的时区和输出格式，每个 Engine 一份，零值为 UTC 和 RFC 3339
+type TimeConfig struct {
+	DBLocation  *time.Location //数据库中不带时区的时间所在的时区，请求中的时间也转为此时区查询和保存，默认 UTC
+	OutLocation *time.Location //输出和解析请求中不带时区的时间使用的时区，默认为 DBLocation
+	Format      string         //输出格式，TimeFormatRFC3339、TimeFormatEpochMillis 或 time 包的 layout，请求中的时间也按此格式解析
+}
+
+func (tc TimeConfig) dbLocation() *time.Location {
+	if tc.DBLocation == nil {
+		return time.UTC
+	}
+
+	return tc.DBLocation
+}
+
+func (tc TimeConfig) outLocation() *time.Location {
+	if tc.OutLocation == nil {
+		return tc.dbLocation()
+	}
+
+	return tc.OutLocation
+}
+
+//驱动返回的时间，驱动按 UTC 解析的不带时区的时间视为数据库时区的时间，如 go-sqlite3、MySQL 的 loc=UTC
+func (tc TimeConfig) fromDB(t time.Time) time.Time {
+	if t.Location() != time.UTC {
+		return t
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), tc.dbLocation())
+}
+
+//按数据库时区解析数据库返回的时间字符串
+func (tc TimeConfig) parseDB(str string) (t time.Time, err error) {
+	for _, layout := range dbTimeLayouts {
+		if t, err = time.ParseInLocation(layout, str, tc.dbLocation()); err == nil {
+			return t, nil
+		}
+	}
+
+	return t, err
+}
+
+//转为输出的时区和格式
+func (tc TimeConfig) format(t time.Time) interface{} {
+	t = t.In(tc.outLocation())
+
+	switch tc.Format {
+	case "", TimeFormatRFC3339:
+		return t.Format(time.RFC3339Nano)
+	case TimeFormatEpochMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	default:
+		return t.Format(tc.Format)
+	}
+}
+
+//解析请求中的时间，JSON 数字为毫秒时间戳，字符串依次按 Format、RFC 3339 和数据库的格式解析，不带时区时为 OutLocation
+func (tc TimeConfig) parse(value interface{}) (time.Time, error) {
+	var str string
+
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), nil
+	case string:
+		str = v
+	default:
+		return time.Time{}, fmt.Errorf("%T is not a time", value)
+	}
+
+	layouts := []string{time.RFC3339Nano}
+	if tc.Format != "" && tc.Format != TimeFormatRFC3339 && tc.Format != TimeFormatEpochMillis {
+		layouts = append([]string{tc.Format}, layouts...)
+	}
+
+	for _, layout := range append(layouts, dbTimeLayouts...) {
+		if t, err := time.ParseInLocation(layout, str, tc.outLocation()); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%s is not a valid time", str)
+}
+
+//转为数据库时区不带时区的字符串
+func (tc TimeConfig) toDB(t time.Time) string {
+	return t.In(tc.dbLocation()).Format("2006-01-02 15:04:05.999999999")
+}
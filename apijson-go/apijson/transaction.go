@@ -0,0 +1,57 @@
+package apijson
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//KeyTransaction 事务，写请求默认在事务中执行，可指定隔离级别，如 "@transaction": "SERIALIZABLE"；
+//读请求指定时在只读事务中执行，"@transaction": false 关闭事务
+const KeyTransaction = "@transaction"
+
+//隔离级别，空格也可以写成下划线，如 READ_COMMITTED
+var isolationLevels = map[string]sql.IsolationLevel{
+	"DEFAULT":          sql.LevelDefault,
+	"READ UNCOMMITTED": sql.LevelReadUncommitted,
+	"READ COMMITTED":   sql.LevelReadCommitted,
+	"WRITE COMMITTED":  sql.LevelWriteCommitted,
+	"REPEATABLE READ":  sql.LevelRepeatableRead,
+	"SNAPSHOT":         sql.LevelSnapshot,
+	"SERIALIZABLE":     sql.LevelSerializable,
+	"LINEARIZABLE":     sql.LevelLinearizable,
+}
+
+//获取事务选项，不需要事务时返回 nil
+func getTxOptions(req *orderedmap.OrderedMap, method RequestMethod) (*sql.TxOptions, error) {
+	tmp, ok := req.Get(KeyTransaction)
+	if !ok {
+		if method.IsPublic() {
+			return nil, nil
+		}
+
+		return &sql.TxOptions{}, nil
+	}
+
+	opts := &sql.TxOptions{ReadOnly: method.IsPublic()}
+
+	switch v := tmp.(type) {
+	case bool:
+		if !v {
+			return nil, nil
+		}
+	case string:
+		level, ok := isolationLevels[strings.ToUpper(strings.Replace(strings.TrimSpace(v), "_", " ", -1))]
+		if !ok {
+			return nil, fmt.Errorf("%s isolation level %s is invalid", KeyTransaction, v)
+		}
+
+		opts.Isolation = level
+	default:
+		return nil, fmt.Errorf("%s must be a boolean or an isolation level", KeyTransaction)
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,108 @@
+package apijson
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+func TestGetTxOptions(t *testing.T) {
+	tests := []struct {
+		method RequestMethod
+		body   string
+		want   *sql.TxOptions
+	}{
+		{MethodGet, `{}`, nil},
+		{MethodGet, `{"@transaction": true}`, &sql.TxOptions{ReadOnly: true}},
+		{MethodHead, `{"@transaction": "serializable"}`, &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}},
+		{MethodPost, `{}`, &sql.TxOptions{}},
+		{MethodPut, `{"@transaction": false}`, nil},
+		{MethodDelete, `{"@transaction": " READ_COMMITTED"}`, &sql.TxOptions{Isolation: sql.LevelReadCommitted}},
+		{MethodGets, `{"@transaction": true}`, &sql.TxOptions{}},
+	}
+
+	for _, test := range tests {
+		req := orderedmap.New()
+		if err := json.Unmarshal([]byte(test.body), &req); err != nil {
+			t.Fatal(err)
+		}
+
+		opts, err := getTxOptions(req, test.method)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.method, test.body, err)
+			continue
+		}
+
+		if (opts == nil) != (test.want == nil) || opts != nil && *opts != *test.want {
+			t.Errorf("%s %s: opts = %+v, want %+v", test.method, test.body, opts, test.want)
+		}
+	}
+
+	for _, body := range []string{`{"@transaction": "READ"}`, `{"@transaction": 1}`} {
+		req := orderedmap.New()
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := getTxOptions(req, MethodPost); err == nil {
+			t.Errorf("%s: want error", body)
+		}
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	e := newSQLiteEngine(t)
+	owner := &Visitor{ID: int64(82001)}
+
+	//同时发布动态和评论
+	_, err := e.DB().ExecContext(context.Background(), `INSERT INTO Request (id, version, method, tag, structure) VALUES
+		(8, 1, 'POST', 'moment_comment', '{"Moment": {"MUST": "content"}, "Comment": {"MUST": "momentId,content"}}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(table string) float64 {
+		res := mustParseSQLite(t, e, nil, MethodHead, `{"`+table+`": {"userId": 82001}}`)
+		return getObject(t, res, table)["count"].(float64)
+	}
+
+	moments, comments := count("Moment"), count("Comment")
+
+	//评论的 toId 类型错误，已新增的动态回滚
+	body := `"Moment": {"content": "tx"}, "Comment": {"momentId": 12, "content": "tx", "toId": "abc"}, "tag": "moment_comment", "@role": "OWNER"`
+	if _, err = parseSQLite(t, e, owner, MethodPost, `{`+body+`}`); err == nil {
+		t.Fatal("post with invalid toId should fail")
+	}
+
+	if m, c := count("Moment"), count("Comment"); m != moments || c != comments {
+		t.Errorf("after rollback Moment %v, Comment %v, want %v, %v", m, c, moments, comments)
+	}
+
+	//关闭事务时已执行的不回滚
+	if _, err = parseSQLite(t, e, owner, MethodPost, `{`+body+`, "@transaction": false}`); err == nil {
+		t.Fatal("post with invalid toId should fail")
+	}
+
+	if m := count("Moment"); m != moments+1 {
+		t.Errorf("without transaction Moment %v, want %v", m, moments+1)
+	}
+
+	mustParseSQLite(t, e, owner, MethodPost, `{"Moment": {"content": "tx"}, "Comment": {"momentId": 12, "content": "tx"},
+		"tag": "moment_comment", "@role": "OWNER", "@transaction": "SERIALIZABLE"}`)
+	if m, c := count("Moment"), count("Comment"); m != moments+2 || c != comments+1 {
+		t.Errorf("after commit Moment %v, Comment %v, want %v, %v", m, c, moments+2, comments+1)
+	}
+
+	//只读事务中查询
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, "@column": "id"}, "@transaction": true}`)
+	if id := getObject(t, res, "Moment")["id"]; id != float64(12) {
+		t.Errorf("Moment id = %v, want 12", id)
+	}
+
+	if _, err = parseSQLite(t, e, owner, MethodPost, `{`+body+`, "@transaction": "READ"}`); err == nil {
+		t.Error("invalid isolation level should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package apijson
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
@@ -21,9 +22,6 @@ type NullBool bool
 //NullBool 数据库 bool NULL 类型
 type NullTime string
 
-//TimeLayout time.Parse 解析数据库时间字段到 NullTime 时的 layout，默认为 "2006-01-02 15:04:05 +0800 CST"
-var TimeLayout string
-
 //Scan NullString 类型实现msyql引擎查询赋值接口
 func (ns *NullString) Scan(value interface{}) error {
 	if value == nil {
@@ -106,7 +104,7 @@ func (ns *NullFloat) Scan(value interface{}) error {
 		return nil
 	}
 
-	i, err := strconv.ParseFloat(tmp, 32)
+	i, err := strconv.ParseFloat(tmp, 64)
 
 	if err == nil {
 		*ns = NullFloat(i)
@@ -123,6 +121,12 @@ func (ns *NullTime) Scan(value interface{}) error {
 		return nil
 	}
 
+	//驱动已解析为 time.Time，如 MySQL 的 parseTime=true、SQLite 的 DATETIME
+	if t, ok := value.(time.Time); ok {
+		*ns = NullTime(t.Format("2006-01-02 15:04:05"))
+		return nil
+	}
+
 	tmp, err := rowToString(value)
 	if err != nil {
 		return nil
@@ -132,11 +136,7 @@ func (ns *NullTime) Scan(value interface{}) error {
 		return nil
 	}
 
-	if TimeLayout == "" {
-		TimeLayout = "2006-01-02 15:04:05 +0800 CST"
-	}
-
-	i, err := time.Parse(TimeLayout, tmp)
+	i, err := TimeConfig{}.parseDB(tmp)
 	if err == nil {
 		*ns = NullTime(i.Format("2006-01-02 15:04:05"))
 	}
@@ -187,7 +187,9 @@ func rowToString(row interface{}) (ret string, err error) {
 	case *uint:
 		return fmt.Sprintf("%v", *v), nil
 	case *[]byte:
-		return fmt.Sprintf("%v", *v), nil
+		return string(*v), nil
+	case json.RawMessage:
+		return string(v), nil
 	case string, bool, uint8, uint16, uint32, uint64, int8, int16, int32, int64, float32, float64, int, uint:
 		return fmt.Sprintf("%v", v), nil
 	case []byte:
@@ -207,80 +209,3 @@ func rowToString(row interface{}) (ret string, err error) {
 		return fmt.Sprintf("%v", v), nil
 	}
 }
-
-//日期时间类型
-var typeForMysqlToGo = map[string]string{
-	"bool":               "bool",
-	"int":                "int",
-	"integer":            "int",
-	"tinyint":            "int8",
-	"smallint":           "int16",
-	"mediumint":          "int32",
-	"bigint":             "int64",
-	"int unsigned":       "uint",
-	"integer unsigned":   "uint",
-	"tinyint unsigned":   "uint8",
-	"smallint unsigned":  "uint16",
-	"mediumint unsigned": "uint32",
-	"bigint unsigned":    "uint64",
-	"bit":                "int8",
-	"float":              "float32",
-	"double":             "float64",
-	"decimal":            "float64",
-	"enum":               "string",
-	"set":                "string",
-	"varchar":            "string",
-	"char":               "string",
-	"tinytext":           "string",
-	"mediumtext":         "string",
-	"text":               "string",
-	"longtext":           "string",
-	"blob":               "string",
-	"tinyblob":           "string",
-	"mediumblob":         "string",
-	"longblob":           "string",
-	"date":               "string",
-	"time":               "string",
-	"datetime":           "NullTime",
-	"timestamp":          "NullTime",
-	"binary":             "[]byte",
-	"varbinary":          "[]byte",
-}
-
-var nullTypeForMysqlToGo = map[string]string{
-	"bool":               "NullBool",
-	"int":                "NullInt",
-	"integer":            "NullInt",
-	"tinyint":            "NullInt",
-	"smallint":           "NullInt",
-	"mediumint":          "NullInt",
-	"bigint":             "NullInt",
-	"int unsigned":       "NullInt",
-	"integer unsigned":   "NullInt",
-	"tinyint unsigned":   "NullInt",
-	"smallint unsigned":  "NullInt",
-	"mediumint unsigned": "NullInt",
-	"bigint unsigned":    "NullInt",
-	"bit":                "NullInt",
-	"float":              "NullFloat",
-	"double":             "NullFloat",
-	"decimal":            "NullFloat",
-	"enum":               "NullString",
-	"set":                "NullString",
-	"varchar":            "NullString",
-	"char":               "NullString",
-	"tinytext":           "NullString",
-	"mediumtext":         "NullString",
-	"text":               "NullString",
-	"longtext":           "NullString",
-	"blob":               "NullString",
-	"tinyblob":           "NullString",
-	"mediumblob":         "NullString",
-	"longblob":           "NullString",
-	"date":               "NullString",
-	"time":               "NullString",
-	"datetime":           "NullTime",
-	"timestamp":          "NullTime",
-	"binary":             "NullString",
-	"varbinary":          "NullString",
-}
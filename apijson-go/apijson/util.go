@@ -10,13 +10,20 @@ func encodeResult(head *ParseTree, data *orderedmap.OrderedMap) {
 		return
 	}
 
-	if head.IsArray {
+	if head.IsValue {
+		data.Set(head.Key, head.Values[0])
+	} else if head.IsArray {
+		if head.Query == QueryTotal { //只查询总条数，不返回数组
+			encodeResult(head.Next, data)
+			return
+		}
+
 		if head.IsFieldArray {
 			data.Set(head.Key, &head.FieldData[0])
 		} else {
 			sub := []*orderedmap.OrderedMap{}
 			data.Set(head.Key, &sub)
-			encodeArrayResult(head.Children[0], head.Size, &sub)
+			encodeArrayResult(head.Children[0], head.Children[0].Size, &sub)
 		}
 	} else {
 		data.Set(head.Key, head.Data[0][head.Key])
@@ -32,12 +39,24 @@ func encodeArrayResult(head *ParseTree, size int, datas *[]*orderedmap.OrderedMa
 			break
 		}
 
+		if head.IsArray && head.Query == QueryTotal { //只查询总条数，不返回数组
+			head = head.Next
+			continue
+		}
+
 		if size > 0 {
 			if len(*datas) == 0 {
 				*datas = make([]*orderedmap.OrderedMap, size)
 			}
 
-			if head.IsArray {
+			if head.IsValue {
+				for i := 0; i < size; i++ {
+					if (*datas)[i] == nil {
+						(*datas)[i] = orderedmap.New()
+					}
+					(*datas)[i].Set(head.Key, head.Values[i])
+				}
+			} else if head.IsArray {
 				if head.IsFieldArray {
 					for i := 0; i < size; i++ {
 						if (*datas)[i] == nil {
@@ -57,7 +76,7 @@ func encodeArrayResult(head *ParseTree, size int, datas *[]*orderedmap.OrderedMa
 							continue
 						}
 
-						encodeArrayResult(head.Children[i], head.Size, &sub)
+						encodeArrayResult(head.Children[i], head.Children[i].Size, &sub)
 					}
 				}
 			} else {
@@ -0,0 +1,768 @@
+package apijson
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/orderedmap"
+)
+
+//Request 表校验规则操作，对应 APIJSON 的 Operation
+const (
+	OperationMust    = "MUST"    //必须传的字段，"key0,key1,key2..."
+	OperationRefuse  = "REFUSE"  //不允许传的字段，"key0,key1,key2..."，"!" 为所有非 MUST 字段
+	OperationType    = "TYPE"    //校验类型，{"id": "NUMBER", "pictureList": "URL[]"}
+	OperationVerify  = "VERIFY"  //校验条件，{"phone~": "PHONE", "status{}": [1,2,3], "balance&{}": ">0,<=10000"}
+	OperationExist   = "EXIST"   //校验在表中存在，"key0,key1,key2..."
+	OperationUnique  = "UNIQUE"  //校验在表中不重复，除了本身的记录，"key0,key1,key2..."
+	OperationInsert  = "INSERT"  //不存在时添加，{"key0": value0}
+	OperationUpdate  = "UPDATE"  //强行放入，不存在时添加，存在时修改，{"key0": value0}
+	OperationReplace = "REPLACE" //存在时替换，{"key0": value0}
+	OperationRemove  = "REMOVE"  //移除，"key0,key1,key2..."
+)
+
+var operations = map[string]bool{
+	OperationMust:    true,
+	OperationRefuse:  true,
+	OperationType:    true,
+	OperationVerify:  true,
+	OperationExist:   true,
+	OperationUnique:  true,
+	OperationInsert:  true,
+	OperationUpdate:  true,
+	OperationReplace: true,
+	OperationRemove:  true,
+}
+
+//校验请求结构，非开放请求必须传 tag，按 method 和 tag 从 Request 表加载规则校验并改写 req
+func (p *Parser) verifyRequest(ctx context.Context, req *orderedmap.OrderedMap) error {
+	if p.Method.IsPublic() {
+		return nil
+	}
+
+	tmp, _ := req.Get("tag")
+	tag, _ := tmp.(string)
+	if tag == "" {
+		return fmt.Errorf("tag is required for %s request", p.Method)
+	}
+
+	version := 0
+	if tmp, ok := req.Get("version"); ok {
+		v, err := getNonNegativeInt(tmp)
+		if err != nil {
+			return fmt.Errorf("version is invalid: %v", err)
+		}
+
+		version = v
+	}
+
+	structure, err := p.loadRequestStructure(ctx, tag, version)
+	if err != nil {
+		return err
+	}
+
+	return p.verifyObject(ctx, "", "", wrapStructure(tag, structure, req), req)
+}
+
+//从 Request 表加载校验规则，version 为 0 时取最新版本
+func (p *Parser) loadRequestStructure(ctx context.Context, tag string, version int) (*orderedmap.OrderedMap, error) {
+	where := orderedmap.New()
+	where.Set("method", strings.ToUpper(string(p.Method)))
+	where.Set("tag", tag)
+	if version > 0 {
+		where.Set("version<=", version)
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(p.config().RequestTable)
+	statement.Select("`structure`")
+	statement.Where(where)
+	statement.Order("`version`", true)
+
+	row, err := p.DB.FindOneMap(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+
+	if row == nil {
+		return nil, fmt.Errorf("no %s rule for %s request with tag %s", p.config().RequestTable, p.Method, tag)
+	}
+
+	str, err := rowToString(indirectValue(row["structure"]))
+	if err != nil {
+		return nil, err
+	}
+
+	structure := orderedmap.New()
+	err = json.Unmarshal([]byte(str), structure)
+	if err != nil {
+		return nil, newError(CodeServerError, "%s rule for %s %s is invalid: %v", p.config().RequestTable, p.Method, tag, err)
+	}
+
+	return structure, nil
+}
+
+//tag 为表名时，规则只写了表内的结构，需要包装成 {tag: structure}，批量为 {"Table[]": [structure]}；
+//tag 不是表名时，如 "moment_comments"，规则是多表的完整结构。
+//小写开头的表名如 apijson_user 只能按请求中是否有 tag 对应的 key 判断
+func wrapStructure(tag string, structure, req *orderedmap.OrderedMap) *orderedmap.OrderedMap {
+	if _, ok := structure.Get(tag); ok {
+		return structure
+	}
+
+	if _, ok := req.Get(tag); !ok && !isTableTag(tag) {
+		return structure
+	}
+
+	target := orderedmap.New()
+	if isKeyArray(tag) == IsArrayTrue {
+		target.Set(tag, []interface{}{*structure})
+	} else {
+		target.Set(tag, *structure)
+	}
+
+	return target
+}
+
+//表名以大写字母开头，与 APIJSON 一致
+func isTableTag(tag string) bool {
+	return tag != "" && tag[0] >= 'A' && tag[0] <= 'Z'
+}
+
+//按规则 target 校验并改写 real，path 为 real 在请求中的路径，name 为 real 的 key
+func (p *Parser) verifyObject(ctx context.Context, path, name string,
+	target, real *orderedmap.OrderedMap) error {
+	//移除字段
+	for _, key := range getOperationKeys(target, OperationRemove) {
+		real.Delete(key)
+	}
+
+	//必须传的字段
+	musts := getOperationKeys(target, OperationMust)
+	for _, key := range musts {
+		if v, _ := real.Get(key); v == nil {
+			return pathError(CodeConditionError, path, "%s is required", key)
+		}
+	}
+
+	//往下一级校验，objKeys 为规则中的对象、数组，setKeys 为规则直接放入的值
+	objKeys := map[string]bool{}
+	setKeys := map[string]bool{}
+
+	for _, key := range target.Keys() {
+		if operations[key] {
+			continue
+		}
+
+		tv, _ := target.Get(key)
+		rv, _ := real.Get(key)
+		childPath := path + "/" + key
+
+		switch tvalue := tv.(type) {
+		case orderedmap.OrderedMap:
+			if rv == nil {
+				return pathError(CodeConditionError, path, "%s:{} is required", key)
+			}
+
+			robj, ok := rv.(orderedmap.OrderedMap)
+			if !ok {
+				return pathError(CodeConditionError, childPath, "value must be an object")
+			}
+
+			if path == "" {
+				err := p.verifyTable(key, childPath, &robj)
+				if err != nil {
+					return err
+				}
+			}
+
+			err := p.verifyObject(ctx, childPath, key, &tvalue, &robj)
+			if err != nil {
+				return err
+			}
+
+			real.Set(key, robj)
+			objKeys[key] = true
+		case []interface{}:
+			if rv == nil {
+				continue
+			}
+
+			rarr, ok := rv.([]interface{})
+			if !ok {
+				return pathError(CodeConditionError, childPath, "value must be an array")
+			}
+
+			if p.Method.IsBatch() && isKeyArray(key) == IsArrayTrue {
+				err := p.verifyBatch(ctx, childPath, key, tvalue, rarr)
+				if err != nil {
+					return err
+				}
+
+				objKeys[key] = true
+			}
+		default: //非校验规则的值直接放入
+			real.Set(key, tv)
+			setKeys[key] = true
+		}
+	}
+
+	//不允许传的字段，"!" 时只允许 MUST 和规则中的 key，@ 开头的关键词如 @combine、@column、@role 同样拒绝
+	refuses := map[string]bool{}
+	refuse := getOperationString(target, OperationRefuse)
+	if refuse == "!" {
+		mustMap := map[string]bool{}
+		for _, key := range musts {
+			mustMap[key] = true
+		}
+
+		for _, key := range real.Keys() {
+			if !mustMap[key] && !objKeys[key] && !setKeys[key] {
+				refuses[key] = true
+			}
+		}
+	} else {
+		for _, key := range getOperationKeys(target, OperationRefuse) {
+			refuses[key] = true
+		}
+	}
+
+	for _, key := range real.Keys() {
+		if refuses[key] {
+			return pathError(CodeConditionError, path, "%s is not allowed", key)
+		}
+
+		rv, _ := real.Get(key)
+
+		//不允许传远程函数，只能后端配置
+		if _, isString := rv.(string); isString && strings.HasSuffix(key, "()") {
+			return pathError(CodeConditionError, path, "remote function %s is not allowed in %s request", key, p.Method)
+		}
+
+		if objKeys[key] {
+			continue
+		}
+
+		if _, isObject := rv.(orderedmap.OrderedMap); isObject {
+			return pathError(CodeConditionError, path, "%s:{} is not allowed", key)
+		}
+
+		if _, isList := rv.([]interface{}); isList && p.Method.IsBatch() && isKeyArray(key) == IsArrayTrue {
+			return pathError(CodeConditionError, path, "batch %s:[] is not allowed", key)
+		}
+	}
+
+	//校验与改写
+	for _, operation := range []string{OperationType, OperationVerify, OperationInsert, OperationUpdate, OperationReplace} {
+		err := p.operate(operation, path, target, real)
+		if err != nil {
+			return err
+		}
+	}
+
+	//校验存在、重复
+	for _, key := range getOperationKeys(target, OperationExist) {
+		err := p.verifyExist(ctx, path, name, key, real, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range getOperationKeys(target, OperationUnique) {
+		err := p.verifyExist(ctx, path, name, key, real, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//校验批量新增、修改 "Table[]": [{...}, {...}]，每一项都按 target 的第一项校验
+func (p *Parser) verifyBatch(ctx context.Context, path, key string, target, real []interface{}) error {
+	if len(real) == 0 {
+		return pathError(CodeConditionError, path, "value must be a non-empty array")
+	}
+
+	if maxCount := p.config().Limits.orDefault().MaxUpdateCount; len(real) > maxCount {
+		return pathError(CodeOutOfRange, path, "length must not be greater than %d", maxCount)
+	}
+
+	var tobj *orderedmap.OrderedMap
+	if len(target) > 0 {
+		if t, ok := target[0].(orderedmap.OrderedMap); ok {
+			tobj = &t
+		}
+	}
+
+	table := key[:len(key)-2]
+
+	for i, item := range real {
+		itemPath := fmt.Sprint(path, "/", i)
+
+		robj, ok := item.(orderedmap.OrderedMap)
+		if !ok {
+			return pathError(CodeConditionError, itemPath, "value must be an object")
+		}
+
+		err := p.verifyTable(table, itemPath, &robj)
+		if err != nil {
+			return err
+		}
+
+		if tobj != nil {
+			err = p.verifyObject(ctx, itemPath, table, tobj, &robj)
+			if err != nil {
+				return err
+			}
+		}
+
+		real[i] = robj
+	}
+
+	return nil
+}
+
+//校验表对象的 id，POST 不能传 id，PUT、DELETE 必须传 id 或 id{}
+func (p *Parser) verifyTable(table, path string, real *orderedmap.OrderedMap) error {
+	id, hasID := real.Get("id")
+
+	if p.Method == MethodPost {
+		if hasID {
+			return pathError(CodeConditionError, path, "id is not allowed in %s request", p.Method)
+		}
+
+		return nil
+	}
+
+	if p.Method != MethodPut && p.Method != MethodDelete {
+		return nil
+	}
+
+	if id != nil {
+		switch id.(type) {
+		case float64, string:
+		default:
+			return pathError(CodeConditionError, path, "id must be a number or string")
+		}
+	}
+
+	tmp, hasIDIn := real.Get("id{}")
+	if !hasIDIn || tmp == nil {
+		if id == nil {
+			return pathError(CodeConditionError, path, "id or id{} is required in %s request", p.Method)
+		}
+
+		return nil
+	}
+
+	ids, ok := tmp.([]interface{})
+	if !ok {
+		return pathError(CodeConditionError, path, "id{} must be an array")
+	}
+
+	if maxCount := p.config().Limits.orDefault().MaxUpdateCount; len(ids) > maxCount {
+		return pathError(CodeOutOfRange, path, "length of id{} must not be greater than %d", maxCount)
+	}
+
+	//防止 id{}: [0] 或 id{}: [""] 等绕过 id{} 限制
+	for _, v := range ids {
+		switch vv := v.(type) {
+		case float64:
+			if vv <= 0 {
+				return pathError(CodeConditionError, path, "items of id{} must be positive numbers or non-empty strings")
+			}
+		case string:
+			if strings.TrimSpace(vv) == "" {
+				return pathError(CodeConditionError, path, "items of id{} must be positive numbers or non-empty strings")
+			}
+		default:
+			return pathError(CodeConditionError, path, "items of id{} must be positive numbers or non-empty strings")
+		}
+	}
+
+	return nil
+}
+
+//执行 TYPE、VERIFY、INSERT、UPDATE、REPLACE 操作
+func (p *Parser) operate(operation, path string, target, real *orderedmap.OrderedMap) error {
+	tmp, ok := target.Get(operation)
+	if !ok {
+		return nil
+	}
+
+	rules, ok := tmp.(orderedmap.OrderedMap)
+	if !ok {
+		return pathError(CodeConditionError, path, "%s rule must be an object", operation)
+	}
+
+	for _, tk := range rules.Keys() {
+		tv, _ := rules.Get(tk)
+
+		switch operation {
+		case OperationType:
+			typ, ok := tv.(string)
+			if !ok {
+				return pathError(CodeConditionError, path, "%s rule of %s must be a string", operation, tk)
+			}
+
+			rv, _ := real.Get(tk)
+			err := verifyType(path+"/"+tk, typ, rv)
+			if err != nil {
+				return err
+			}
+		case OperationVerify:
+			err := p.verifyValue(path, tk, tv, real)
+			if err != nil {
+				return err
+			}
+		case OperationUpdate:
+			real.Set(tk, tv)
+		case OperationInsert:
+			if _, exist := real.Get(tk); !exist {
+				real.Set(tk, tv)
+			}
+		case OperationReplace:
+			if _, exist := real.Get(tk); exist {
+				real.Set(tk, tv)
+			}
+		}
+	}
+
+	return nil
+}
+
+//校验类型，BOOLEAN, NUMBER, DECIMAL, STRING, URL, DATE, TIME, DATETIME, OBJECT, ARRAY 或它们的数组，如 URL[]
+func verifyType(path, typ string, rv interface{}) error {
+	if rv == nil {
+		return nil
+	}
+
+	if strings.HasSuffix(typ, "[]") {
+		arr, ok := rv.([]interface{})
+		if !ok {
+			return pathError(CodeConditionError, path, "value must be %s", typ)
+		}
+
+		for i, v := range arr {
+			err := verifyType(fmt.Sprint(path, "/", i), typ[:len(typ)-2], v)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	var valid bool
+	str, isString := rv.(string)
+
+	switch typ {
+	case "BOOLEAN":
+		_, valid = rv.(bool)
+	case "NUMBER":
+		if f, ok := rv.(float64); ok {
+			valid = f == float64(int64(f))
+		} else if isString {
+			_, err := strconv.ParseInt(str, 10, 64)
+			valid = err == nil
+		}
+	case "DECIMAL":
+		if _, ok := rv.(float64); ok {
+			valid = true
+		} else if isString {
+			_, err := strconv.ParseFloat(str, 64)
+			valid = err == nil
+		}
+	case "STRING":
+		valid = isString
+	case "URL":
+		if isString {
+			u, err := url.ParseRequestURI(str)
+			valid = err == nil && u.Scheme != "" && u.Host != ""
+		}
+	case "DATE":
+		valid = isString && isTimeLayout("2006-01-02", str)
+	case "TIME":
+		valid = isString && isTimeLayout("15:04:05", str)
+	case "DATETIME":
+		valid = isString && isTimeLayout("2006-01-02T15:04:05", str)
+	case "OBJECT":
+		_, valid = rv.(orderedmap.OrderedMap)
+	case "ARRAY":
+		_, valid = rv.([]interface{})
+	default:
+		return pathError(CodeConditionError, path, "type %s in %s rule is invalid", typ, OperationType)
+	}
+
+	if !valid {
+		return pathError(CodeConditionError, path, "value must be %s", typ)
+	}
+
+	return nil
+}
+
+func isTimeLayout(layout, value string) bool {
+	_, err := time.Parse(layout, value)
+	return err == nil
+}
+
+//校验值，tk 以 $ 、~ 、{} 、<> 结尾，前面可以带逻辑符 & 、| 、!
+func (p *Parser) verifyValue(path, tk string, tv interface{}, real *orderedmap.OrderedMap) error {
+	var op string
+	for _, suffix := range []string{"$", "~", "{}", "<>"} {
+		if strings.HasSuffix(tk, suffix) {
+			op = suffix
+			break
+		}
+	}
+
+	if op == "" || tv == nil {
+		return pathError(CodeConditionError, path, "%s rule %s is invalid", OperationVerify, tk)
+	}
+
+	rk, logic := getLogic(tk[:len(tk)-len(op)])
+	rv, _ := real.Get(rk)
+	if rv == nil {
+		return nil
+	}
+
+	var match func(t interface{}) (bool, error)
+
+	switch op {
+	case "$": //模糊匹配
+		match = func(t interface{}) (bool, error) {
+			pattern, ok := t.(string)
+			if !ok {
+				return false, pathError(CodeConditionError, path, "%s rule %s must be string or [string]", OperationVerify, tk)
+			}
+
+			return likeToRegexp(pattern).MatchString(fmt.Sprint(rv)), nil
+		}
+	case "~": //正则匹配
+		match = func(t interface{}) (bool, error) {
+			pattern, ok := t.(string)
+			if !ok {
+				return false, pathError(CodeConditionError, path, "%s rule %s must be string or [string]", OperationVerify, tk)
+			}
+
+			reg, ok := p.config().RegexpAliases[pattern]
+			if !ok {
+				var err error
+				reg, err = regexp.Compile(pattern)
+				if err != nil {
+					return false, pathError(CodeConditionError, path, "%s rule %s is invalid: %v", OperationVerify, tk, err)
+				}
+			}
+
+			return reg.MatchString(fmt.Sprint(rv)), nil
+		}
+	case "{}":
+		if conditions, ok := tv.(string); ok { //符合条件，如 ">0,<=10000"
+			tv = toInterfaces(strings.Split(conditions, ","))
+			match = func(t interface{}) (bool, error) {
+				return matchCondition(t.(string), rv), nil
+			}
+		} else { //在数组内
+			arr, ok := tv.([]interface{})
+			if !ok {
+				return pathError(CodeConditionError, path, "%s rule %s must be string or array", OperationVerify, tk)
+			}
+
+			if (indexOfArray(arr, rv) != -1) == (logic == "!") {
+				return pathError(CodeConditionError, path+"/"+rk, "value must match %s: %v", tk, tv)
+			}
+
+			return nil
+		}
+	case "<>": //包含
+		rarr, ok := rv.([]interface{})
+		if !ok {
+			return pathError(CodeConditionError, path+"/"+rk, "value must be an array")
+		}
+
+		match = func(t interface{}) (bool, error) {
+			return indexOfArray(rarr, t) != -1, nil
+		}
+	}
+
+	arr, ok := tv.([]interface{})
+	if !ok {
+		arr = []interface{}{tv}
+	}
+
+	matched, err := matchLogic(arr, logic, match)
+	if err != nil {
+		return err
+	}
+
+	if !matched {
+		return pathError(CodeConditionError, path+"/"+rk, "value must match %s: %v", tk, tv)
+	}
+
+	return nil
+}
+
+//逻辑符，& 全部满足，! 全部不满足，| 或不传为任一满足
+func getLogic(key string) (string, string) {
+	if l := len(key); l > 0 {
+		switch key[l-1:] {
+		case "&", "|", "!":
+			return key[:l-1], key[l-1:]
+		}
+	}
+
+	return key, "|"
+}
+
+func matchLogic(arr []interface{}, logic string, match func(t interface{}) (bool, error)) (bool, error) {
+	for _, t := range arr {
+		m, err := match(t)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case m && logic == "!":
+			return false, nil
+		case m && logic == "|":
+			return true, nil
+		case !m && logic == "&":
+			return false, nil
+		}
+	}
+
+	return logic != "|", nil
+}
+
+//单个条件，如 ">0"、"<=10000"、"=1"，数字按数值比较
+func matchCondition(condition string, rv interface{}) bool {
+	value, operator := getOrOp(strings.TrimSpace(condition))
+
+	rf, rerr := strconv.ParseFloat(fmt.Sprint(rv), 64)
+	tf, terr := strconv.ParseFloat(value, 64)
+	if rerr != nil || terr != nil {
+		return operator == "=" && fmt.Sprint(rv) == value
+	}
+
+	switch operator {
+	case ">":
+		return rf > tf
+	case ">=":
+		return rf >= tf
+	case "<":
+		return rf < tf
+	case "<=":
+		return rf <= tf
+	default:
+		return rf == tf
+	}
+}
+
+//SQL LIKE 转为正则，% 为任意个字符，_ 为单个字符
+func likeToRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+//校验存在，unique 为 true 时校验不重复，排除本身的记录
+func (p *Parser) verifyExist(ctx context.Context, path, table, key string,
+	real *orderedmap.OrderedMap, unique bool) error {
+	value, _ := real.Get(key)
+	if value == nil {
+		return nil
+	}
+
+	switch value.(type) {
+	case orderedmap.OrderedMap, []interface{}:
+		return pathError(CodeConditionError, path+"/"+key, "value must not be an object or array")
+	}
+
+	where := orderedmap.New()
+	where.Set(key, value)
+	if id, _ := real.Get("id"); unique && id != nil {
+		where.Set("id!", id)
+	}
+
+	statement := NewDbStatement()
+	statement.SetTableName(table)
+	statement.Where(where)
+
+	count, err := p.DB.Count(ctx, statement)
+	if err != nil {
+		return err
+	}
+
+	if unique && count > 0 {
+		return pathError(CodeConflict, path+"/"+key, "%v already exists", value)
+	}
+
+	if !unique && count == 0 {
+		return pathError(CodeNotFound, path+"/"+key, "%v does not exist", value)
+	}
+
+	return nil
+}
+
+//获取规则中逗号分隔的字段
+func getOperationKeys(target *orderedmap.OrderedMap, operation string) []string {
+	var keys []string
+
+	for _, key := range strings.Split(getOperationString(target, operation), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+func getOperationString(target *orderedmap.OrderedMap, operation string) string {
+	tmp, _ := target.Get(operation)
+	str, _ := tmp.(string)
+	return strings.TrimSpace(str)
+}
+
+func toInterfaces(strs []string) []interface{} {
+	ret := make([]interface{}, len(strs))
+	for i, s := range strs {
+		ret[i] = s
+	}
+
+	return ret
+}
+
+//数据库查询结果为指针，取指针指向的值
+func indirectValue(val interface{}) interface{} {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Ptr {
+		return val
+	}
+
+	if v.IsNil() {
+		return nil
+	}
+
+	return v.Elem().Interface()
+}
@@ -0,0 +1,84 @@
+package apijson
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestVerifyRequest(t *testing.T) {
+	e := newSQLiteEngine(t)
+	owner := &Visitor{ID: int64(82001)}
+
+	//新版本的规则，请求中指定 version 时取不大于它的最新版本
+	_, err := e.DB().ExecContext(context.Background(), `INSERT INTO Request (id, version, method, tag, structure) VALUES
+		(8, 2, 'POST', 'Comment', '{"MUST": "momentId,content", "REFUSE": "id", "REMOVE": "date", "INSERT": {"toId": 0},
+			"TYPE": {"momentId": "NUMBER", "content": "STRING"}, "VERIFY": {"content~": "^[^<>]+$", "toId{}": ">=0"}, "EXIST": "momentId"}'),
+		(9, 2, 'PUT', 'apijson_user', '{"MUST": "id", "REFUSE": "sex,date", "UNIQUE": "name"}'),
+		(10, 2, 'DELETE', 'Moment', '{"MUST": "id", "REFUSE": "!", "@role": "OWNER"}')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//不是校验规则产生的错误，只要求失败
+	anyError := errors.New("any error")
+
+	tests := []struct {
+		name   string
+		method RequestMethod
+		body   string
+		err    error
+	}{
+		{"post", MethodPost, `{"Comment": {"momentId": 12, "content": "v2", "date": "2000-01-01 00:00:00"}, "tag": "Comment"}`, nil},
+		{"post version 1", MethodPost, `{"Comment": {"momentId": 999, "content": "<v1>"}, "tag": "Comment", "version": 1}`, nil},
+		{"must", MethodPost, `{"Comment": {"momentId": 12}, "tag": "Comment"}`, ErrConditionError},
+		{"refuse", MethodPost, `{"Comment": {"id": 1000, "momentId": 12, "content": "x"}, "tag": "Comment"}`, ErrConditionError},
+		{"type", MethodPost, `{"Comment": {"momentId": 1.5, "content": "x"}, "tag": "Comment"}`, ErrConditionError},
+		{"verify regexp", MethodPost, `{"Comment": {"momentId": 12, "content": "<x>"}, "tag": "Comment"}`, ErrConditionError},
+		{"verify condition", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "toId": -1}, "tag": "Comment"}`, ErrConditionError},
+		{"exist", MethodPost, `{"Comment": {"momentId": 998, "content": "x"}, "tag": "Comment"}`, ErrNotExist},
+		{"remote function", MethodPost, `{"Comment": {"momentId": 12, "content": "x", "f()": "f()"}, "tag": "Comment"}`, ErrConditionError},
+		{"unknown tag", MethodPost, `{"Comment": {"momentId": 12, "content": "x"}, "tag": "Comments"}`, anyError},
+		{"invalid version", MethodPost, `{"Comment": {"momentId": 12, "content": "x"}, "tag": "Comment", "version": -1}`, anyError},
+
+		{"unique", MethodPut, `{"apijson_user": {"id": 82001, "name": "Strong"}, "tag": "apijson_user"}`, ErrConflict},
+		{"unique self", MethodPut, `{"apijson_user": {"id": 82001, "name": "Test"}, "tag": "apijson_user"}`, nil},
+		{"put refuse", MethodPut, `{"apijson_user": {"id": 82001, "sex": 1}, "tag": "apijson_user"}`, ErrConditionError},
+		{"put without id", MethodPut, `{"Comment": {"content": "x"}, "tag": "Comment"}`, ErrConditionError},
+		{"refuse all", MethodDelete, `{"Comment": {"id": 22, "content": "x"}, "tag": "Comment"}`, ErrConditionError},
+		{"refuse combine", MethodDelete, `{"Comment": {"id": 22, "@combine": "id"}, "tag": "Comment"}`, ErrConditionError},
+		{"refuse column", MethodDelete, `{"Comment": {"id": 22, "@column": "id"}, "tag": "Comment"}`, ErrConditionError},
+		{"refuse role", MethodDelete, `{"Comment": {"id": 22, "@role": "OWNER"}, "tag": "Comment"}`, ErrConditionError},
+		//规则中的 @role 覆盖请求中的
+		{"rule role", MethodDelete, `{"Moment": {"id": 15, "@role": "ADMIN"}, "tag": "Moment", "version": 2}`, nil},
+	}
+
+	for _, test := range tests {
+		_, err := parseSQLite(t, e, owner, test.method, `{"@role": "OWNER", `+test.body[1:])
+		switch {
+		case test.err == anyError:
+			if err == nil {
+				t.Errorf("%s: want error", test.name)
+			}
+		case test.err != nil:
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: error = %v, want %v", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+
+	//INSERT 补上缺少的字段，REMOVE 移除传入的字段
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Comment": {"content": "v2"}}`)
+	if comment := getObject(t, res, "Comment"); comment["toId"] != float64(0) || comment["date"] == "2000-01-01 00:00:00" {
+		t.Errorf("Comment = %v", comment)
+	}
+
+	//版本 1 的规则 INSERT 了空的点赞列表
+	mustParseSQLite(t, e, &Visitor{ID: int64(70793)}, MethodPost, `{"Moment": {"content": "insert"}, "tag": "Moment", "@role": "OWNER"}`)
+	res = mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"content": "insert", "@column": "praiseUserIdList"}}`)
+	if ids, err := getJSONArray(getObject(t, res, "Moment")["praiseUserIdList"]); err != nil || len(ids) != 0 {
+		t.Errorf("praiseUserIdList = %v, want []", res["Moment"])
+	}
+}
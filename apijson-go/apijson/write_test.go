@@ -0,0 +1,113 @@
+package apijson
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+//Moment 12 的点赞用户，SQLite 中为 JSON 字符串
+func getPraiseUserIDs(t *testing.T, e *Engine) []interface{} {
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Moment": {"id": 12, "@column": "praiseUserIdList"}}`)
+	ids, err := getJSONArray(getObject(t, res, "Moment")["praiseUserIdList"])
+	if err != nil {
+		t.Fatalf("praiseUserIdList is not an array: %v", res)
+	}
+
+	return ids
+}
+
+func TestPutIncrement(t *testing.T) {
+	e := newSQLiteEngine(t)
+	owner := &Visitor{ID: int64(70793)}
+
+	//评论 47 的 toId 为 4
+	mustParseSQLite(t, e, owner, MethodPut, `{"Comment": {"id": 47, "toId+": 2}, "tag": "Comment", "@role": "OWNER"}`)
+	mustParseSQLite(t, e, owner, MethodPut, `{"Comment": {"id": 47, "toId-": 1}, "tag": "Comment", "@role": "OWNER"}`)
+
+	res := mustParseSQLite(t, e, nil, MethodGet, `{"Comment": {"id": 47}}`)
+	if toID := getObject(t, res, "Comment")["toId"]; toID != float64(5) {
+		t.Errorf("toId = %v, want 5", toID)
+	}
+
+	//Moment 12 的点赞用户为 [70793, 82003, 82002, 82001]
+	mustParseSQLite(t, e, owner, MethodPut, `{"Moment": {"id": 12, "praiseUserIdList+": [38710]}, "tag": "Moment", "@role": "OWNER"}`)
+	mustParseSQLite(t, e, owner, MethodPut, `{"Moment": {"id": 12, "praiseUserIdList-": [82003, 82001]}, "tag": "Moment", "@role": "OWNER"}`)
+
+	if ids := getPraiseUserIDs(t, e); !equalIDs(ids, 70793, 82002, 38710) {
+		t.Errorf("praiseUserIdList = %v, want [70793, 82002, 38710]", ids)
+	}
+
+	tests := []struct {
+		body string
+		want error
+	}{
+		{`{"Moment": {"id": 12, "praiseUserIdList+": [82002]}, "tag": "Moment", "@role": "OWNER"}`, ErrConflict},
+		{`{"Moment": {"id": 12, "praiseUserIdList-": [90000]}, "tag": "Moment", "@role": "OWNER"}`, ErrNotExist},
+	}
+
+	for _, test := range tests {
+		if _, err := parseSQLite(t, e, owner, MethodPut, test.body); !errors.Is(err, test.want) {
+			t.Errorf("%s: error = %v, want %v", test.body, err, test.want)
+		}
+	}
+
+	//增删数组需要在事务中锁定后修改
+	_, err := parseSQLite(t, e, owner, MethodPut, `{
+		"Moment": {"id": 12, "praiseUserIdList+": [90000]}, "tag": "Moment", "@role": "OWNER", "@transaction": false
+	}`)
+	if err == nil {
+		t.Error("put array without transaction should fail")
+	}
+}
+
+//并发增加数组元素，不会丢失其它请求增加的元素
+func TestPutArrayConcurrent(t *testing.T) {
+	for _, txlock := range []string{"", "&_txlock=immediate"} {
+		e := newSQLiteEngine(t, func(config *Config) {
+			config.DataSource += txlock
+		})
+		owner := &Visitor{ID: int64(70793)}
+
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				_, errs[i] = parseSQLite(t, e, owner, MethodPut, fmt.Sprintf(`{
+					"Moment": {"id": 12, "praiseUserIdList+": [%d]}, "tag": "Moment", "@role": "OWNER"
+				}`, 90000+i))
+			}(i)
+		}
+
+		wg.Wait()
+
+		want := []float64{70793, 82003, 82002, 82001}
+		for i, err := range errs {
+			//未排队时后写入的事务返回 database is locked，整个请求回滚
+			if err != nil && txlock == "" {
+				continue
+			}
+
+			if err != nil {
+				t.Fatalf("txlock %s: %v", txlock, err)
+			}
+
+			want = append(want, float64(90000+i))
+		}
+
+		ids := getPraiseUserIDs(t, e)
+		if len(ids) != len(want) {
+			t.Fatalf("txlock %s: praiseUserIdList = %v, want %v", txlock, ids, want)
+		}
+
+		for _, id := range want {
+			if indexOfArray(ids, id) == -1 {
+				t.Errorf("txlock %s: praiseUserIdList = %v, lost %v", txlock, ids, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+//sqlite3 驱动需要 cgo，CGO_ENABLED=0 编译时不支持 sqlite3 数据源
+import _ "github.com/mattn/go-sqlite3"
@@ -1,9 +1,12 @@
 module apijson
 
-go 1.14
+go 1.17
 
 require (
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/iancoleman/orderedmap v0.2.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.6
 	go.uber.org/automaxprocs v1.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
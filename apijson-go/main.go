@@ -3,35 +3,263 @@ package main
 import (
 	"apijson/apijson"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v3"
 
 	stdhttp "net/http"
 )
 
-func HttpHandler(w stdhttp.ResponseWriter, r *stdhttp.Request) {
-	reqbody, err := ioutil.ReadAll(r.Body)
+//Duration 配置中的时长，格式为 time.ParseDuration 的格式，如 "30s"、"1m"
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalYAML 解析 "30s" 格式的时长，JSON 配置文件也按 YAML 解析
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
 	if err != nil {
-		_, _ = fmt.Fprintln(w, "read body error")
+		return err
 	}
 
-	ctx := context.Background()
-	dbName := `root:apijson@tcp(apijson.cn:3306)/sys?timeout=1s&parseTime=true&charset=utf8&loc=Local`
-	out, err := apijson.Parse(ctx, dbName, reqbody)
-	_, _ = fmt.Fprintln(w, string(out))
+	d.Duration = v
+	return nil
 }
 
-func main() {
-	addr := "127.0.0.1:8000"
+//ServerConfig 服务配置，从 YAML 或 JSON 配置文件读取，环境变量优先
+type ServerConfig struct {
+	Addr            string   `yaml:"addr"`            //监听地址，环境变量 APIJSON_ADDR
+	Driver          string   `yaml:"driver"`          //数据库驱动名，mysql、postgres 或 sqlite3，环境变量 APIJSON_DRIVER
+	DataSource      string   `yaml:"dataSource"`      //默认数据源名称，环境变量 APIJSON_DATA_SOURCE
+	MaxOpenConns    int      `yaml:"maxOpenConns"`    //最大连接数，环境变量 APIJSON_MAX_OPEN_CONNS
+	MaxIdleConns    int      `yaml:"maxIdleConns"`    //最大空闲连接数，环境变量 APIJSON_MAX_IDLE_CONNS
+	ConnMaxLifetime Duration `yaml:"connMaxLifetime"` //连接最长复用时间，环境变量 APIJSON_CONN_MAX_LIFETIME
+	ReadTimeout     Duration `yaml:"readTimeout"`     //读取请求的超时，环境变量 APIJSON_READ_TIMEOUT
+	WriteTimeout    Duration `yaml:"writeTimeout"`    //写入响应的超时，需大于 requestTimeout，环境变量 APIJSON_WRITE_TIMEOUT
+	IdleTimeout     Duration `yaml:"idleTimeout"`     //keep-alive 空闲连接的超时，环境变量 APIJSON_IDLE_TIMEOUT
+	ShutdownTimeout Duration `yaml:"shutdownTimeout"` //收到 SIGTERM 后等待请求处理完的最长时间，环境变量 APIJSON_SHUTDOWN_TIMEOUT
+	RequestTimeout  Duration `yaml:"requestTimeout"`  //每个请求的处理超时，环境变量 APIJSON_REQUEST_TIMEOUT
+	MaxBodySize     int64    `yaml:"maxBodySize"`     //请求体最大字节数，环境变量 APIJSON_MAX_BODY_SIZE
+	AllowOrigins    []string `yaml:"allowOrigins"`    //允许跨域请求的 Origin，环境变量 APIJSON_ALLOW_ORIGINS，逗号分隔
+	Gzip            bool     `yaml:"gzip"`            //是否 gzip 压缩响应，环境变量 APIJSON_GZIP
+
+	DataSources map[string]string `yaml:"dataSources"` //其它数据源，key 为请求中 "@datasource" 的名称，只能在配置文件中指定
+}
+
+//默认服务配置，数据源必须在配置文件或环境变量中指定
+func defaultServerConfig() ServerConfig {
+	engine := apijson.NewConfig("mysql", "")
+
+	return ServerConfig{
+		Addr:            "127.0.0.1:8000",
+		Driver:          engine.DriverName,
+		MaxOpenConns:    engine.Pool.MaxOpenConns,
+		MaxIdleConns:    engine.Pool.MaxIdleConns,
+		ConnMaxLifetime: Duration{engine.Pool.ConnMaxLifetime},
+		ReadTimeout:     Duration{10 * time.Second},
+		WriteTimeout:    Duration{engine.HTTP.Timeout + 10*time.Second},
+		IdleTimeout:     Duration{60 * time.Second},
+		ShutdownTimeout: Duration{30 * time.Second},
+		RequestTimeout:  Duration{engine.HTTP.Timeout},
+		MaxBodySize:     engine.HTTP.MaxBodySize,
+		AllowOrigins:    engine.HTTP.AllowOrigins,
+		Gzip:            engine.HTTP.Gzip,
+	}
+}
+
+//LoadServerConfig 读取配置文件，path 为空时只读取环境变量，再用环境变量覆盖
+func LoadServerConfig(path string) (ServerConfig, error) {
+	config := defaultServerConfig()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("read config file error: %v", err)
+		}
+
+		//JSON 是 YAML 的子集，两种格式都按 YAML 解析
+		if err = yaml.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("parse config file %s error: %v", path, err)
+		}
+	}
+
+	if err := config.loadEnv(); err != nil {
+		return config, err
+	}
+
+	if config.DataSource == "" {
+		return config, errors.New("dataSource is empty, set it in the config file or APIJSON_DATA_SOURCE")
+	}
+
+	return config, nil
+}
+
+//用环境变量覆盖配置，未设置的环境变量不覆盖
+func (c *ServerConfig) loadEnv() error {
+	setDuration := func(d *Duration) func(string) error {
+		return func(s string) (err error) {
+			d.Duration, err = time.ParseDuration(s)
+			return err
+		}
+	}
+
+	setInt := func(n *int) func(string) error {
+		return func(s string) (err error) {
+			*n, err = strconv.Atoi(s)
+			return err
+		}
+	}
+
+	setters := []struct {
+		name string
+		set  func(string) error
+	}{
+		{"APIJSON_ADDR", func(s string) error { c.Addr = s; return nil }},
+		{"APIJSON_DRIVER", func(s string) error { c.Driver = s; return nil }},
+		{"APIJSON_DATA_SOURCE", func(s string) error { c.DataSource = s; return nil }},
+		{"APIJSON_MAX_OPEN_CONNS", setInt(&c.MaxOpenConns)},
+		{"APIJSON_MAX_IDLE_CONNS", setInt(&c.MaxIdleConns)},
+		{"APIJSON_CONN_MAX_LIFETIME", setDuration(&c.ConnMaxLifetime)},
+		{"APIJSON_READ_TIMEOUT", setDuration(&c.ReadTimeout)},
+		{"APIJSON_WRITE_TIMEOUT", setDuration(&c.WriteTimeout)},
+		{"APIJSON_IDLE_TIMEOUT", setDuration(&c.IdleTimeout)},
+		{"APIJSON_SHUTDOWN_TIMEOUT", setDuration(&c.ShutdownTimeout)},
+		{"APIJSON_REQUEST_TIMEOUT", setDuration(&c.RequestTimeout)},
+		{"APIJSON_MAX_BODY_SIZE", func(s string) (err error) {
+			c.MaxBodySize, err = strconv.ParseInt(s, 10, 64)
+			return err
+		}},
+		{"APIJSON_ALLOW_ORIGINS", func(s string) error {
+			c.AllowOrigins = nil
+			for _, origin := range strings.Split(s, ",") {
+				if origin = strings.TrimSpace(origin); origin != "" {
+					c.AllowOrigins = append(c.AllowOrigins, origin)
+				}
+			}
+
+			return nil
+		}},
+		{"APIJSON_GZIP", func(s string) (err error) {
+			c.Gzip, err = strconv.ParseBool(s)
+			return err
+		}},
+	}
+
+	for _, setter := range setters {
+		s, ok := os.LookupEnv(setter.name)
+		if !ok {
+			continue
+		}
+
+		if err := setter.set(s); err != nil {
+			return fmt.Errorf("env %s=%s is invalid: %v", setter.name, s, err)
+		}
+	}
 
-	stdhttp.HandleFunc("/", HttpHandler)
-	err := stdhttp.ListenAndServe(addr, nil)
+	return nil
+}
+
+//EngineConfig 服务配置对应的引擎配置
+func (c ServerConfig) EngineConfig() apijson.Config {
+	config := apijson.NewConfig(c.Driver, c.DataSource)
+	config.DataSources = c.DataSources
+	config.Pool = apijson.PoolConfig{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: c.ConnMaxLifetime.Duration,
+	}
+	config.HTTP = apijson.HTTPConfig{
+		MaxBodySize:  c.MaxBodySize,
+		AllowOrigins: c.AllowOrigins,
+		Gzip:         c.Gzip,
+		Timeout:      c.RequestTimeout.Duration,
+	}
+
+	return config
+}
+
+//按配置创建引擎和服务，驱动未注册时返回错误，调用方负责关闭引擎
+func newServer(config ServerConfig) (*stdhttp.Server, *apijson.Engine, error) {
+	engine, err := apijson.New(config.EngineConfig())
+	if err != nil {
+		return nil, nil, fmt.Errorf("create engine error: %v", err)
+	}
+
+	srv := &stdhttp.Server{
+		Addr:         config.Addr,
+		Handler:      engine.Handler(),
+		ReadTimeout:  config.ReadTimeout.Duration,
+		WriteTimeout: config.WriteTimeout.Duration,
+		IdleTimeout:  config.IdleTimeout.Duration,
+	}
+
+	return srv, engine, nil
+}
+
+//启动服务，收到 SIGINT、SIGTERM 后不再接受新连接，等待正在处理的请求完成后退出
+func run(config ServerConfig) error {
+	srv, engine, err := newServer(config)
 	if err != nil {
-		fmt.Printf("listen serve error: %v \n", err)
-		return
+		return err
 	}
 
-	HttpHandler(nil, nil)
+	defer engine.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	fmt.Printf("listen on %s \n", config.Addr)
+
+	select {
+	case err = <-errc:
+		return fmt.Errorf("listen serve error: %v", err)
+	case sig := <-quit:
+		fmt.Printf("received %s, shutting down \n", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if err = srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown error: %v", err)
+	}
+
+	return nil
+}
+
+func main() {
+	path := flag.String("config", os.Getenv("APIJSON_CONFIG"), "YAML or JSON config file, default is $APIJSON_CONFIG")
+	flag.Parse()
+
+	config, err := LoadServerConfig(*path)
+	if err == nil {
+		err = run(config)
+	}
+
+	if err != nil {
+		fmt.Printf("%v \n", err)
+		os.Exit(1)
+	}
 }